@@ -33,8 +33,9 @@ var _time = struct {
 
 type generateMigrationCommand struct {
 	option struct {
-		ORM  string `short:"o" long:"orm"`
-		Help bool   `short:"h" long:"help"`
+		ORM        string `short:"o" long:"orm"`
+		EventQueue bool   `long:"event-queue"`
+		Help       bool   `short:"h" long:"help"`
 	}
 }
 
@@ -49,6 +50,8 @@ Generate the skeleton files of migration.
 
 Options:
     -o, --orm=ORM     ORM to be used for a transaction [default: "genmai"]
+        --event-queue generate the migration to create the table of
+                      the event queue of github.com/naoina/kocha/event/database
     -h, --help        display this help and exit
 
 `, c.Name())
@@ -78,8 +81,12 @@ func (c *generateMigrationCommand) Run(args []string) error {
 		"ImportPath": orm.ImportPath(),
 		"TxType":     reflect.TypeOf(orm.TransactionType()).String(),
 	}
+	tmpl := "migration.go"
+	if c.option.EventQueue {
+		tmpl = "event_queue.go"
+	}
 	if err := util.CopyTemplate(
-		filepath.Join(skeletonDir("migration"), "migration.go"+util.TemplateSuffix),
+		filepath.Join(skeletonDir("migration"), tmpl+util.TemplateSuffix),
 		filepath.Join("db", "migration", fmt.Sprintf("%v_%v.go", now, util.ToSnakeCase(name))),
 		data,
 	); err != nil {
@@ -36,53 +36,21 @@ func Test_generateMigrationCommand_Run(t *testing.T) {
 		}
 	}()
 
-	// test for default TYPE.
-	func() {
-		tempdir, err := ioutil.TempDir("", "Test_migrationGenerator_Generate")
-		if err != nil {
-			t.Fatal(err)
-		}
-		defer os.RemoveAll(tempdir)
-		os.Chdir(tempdir)
-		f, err := os.OpenFile(os.DevNull, os.O_WRONLY, os.ModePerm)
-		if err != nil {
-			t.Fatal(err)
-		}
-		defer f.Close()
-		oldStdout, oldStderr := os.Stdout, os.Stderr
-		os.Stdout, os.Stderr = f, f
-		defer func() {
-			os.Stdout, os.Stderr = oldStdout, oldStderr
-		}()
-		fixedTime, err := time.Parse("20060102150405", "20140305090617")
-		if err != nil {
-			t.Fatal(err)
-		}
-		_time.Now = func() time.Time { return fixedTime }
-		defer func() {
-			_time.Now = time.Now
-		}()
-		c := &generateMigrationCommand{}
-		c.option.ORM = ""
-		args := []string{"test_create_table"}
-		err = c.Run(args)
-		var actual interface{} = err
-		var expect interface{} = nil
-		if !reflect.DeepEqual(actual, expect) {
-			t.Errorf(`generate(%#v) => %#v; want %#v`, args, actual, expect)
-		}
-
-		outpath := filepath.Join("db", "migration", fmt.Sprintf("%s_test_create_table.go", fixedTime.Format("20060102150405")))
-		if _, err := os.Stat(outpath); os.IsNotExist(err) {
-			t.Errorf("generate(%#v); %#v is not exists; want exists", args, outpath)
-		}
-
-		body, err := ioutil.ReadFile(outpath)
-		if err != nil {
-			t.Fatal(err)
-		}
-		actual = string(body)
-		expect = `package migration
+	// test for generated files.
+	fixedTime, err := time.Parse("20060102150405", "20140305090617")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_time.Now = func() time.Time { return fixedTime }
+	defer func() {
+		_time.Now = time.Now
+	}()
+	for _, v := range []struct {
+		eventQueue bool
+		name       string
+		expect     string
+	}{
+		{false, "test_create_table", `package migration
 
 import "github.com/naoina/genmai"
 
@@ -93,9 +61,72 @@ func (m *Migration) Up_20140305090617_TestCreateTable(tx *genmai.DB) {
 func (m *Migration) Down_20140305090617_TestCreateTable(tx *genmai.DB) {
 	// FIXME: Revert the change done by Up_20140305090617_TestCreateTable.
 }
-`
-		if !reflect.DeepEqual(actual, expect) {
-			t.Errorf("%#v => %#v, want %#v", outpath, actual, expect)
+`},
+		{true, "create_event_queue", `package migration
+
+import (
+	"github.com/naoina/genmai"
+	"github.com/naoina/kocha/event/database"
+)
+
+func (m *Migration) Up_20140305090617_CreateEventQueue(tx *genmai.DB) {
+	for _, query := range database.CreateTableQueries(database.DefaultTableName) {
+		if _, err := tx.Exec(query); err != nil {
+			panic(err)
 		}
-	}()
+	}
+}
+
+func (m *Migration) Down_20140305090617_CreateEventQueue(tx *genmai.DB) {
+	for _, query := range database.DropTableQueries(database.DefaultTableName) {
+		if _, err := tx.Exec(query); err != nil {
+			panic(err)
+		}
+	}
+}
+`},
+	} {
+		func() {
+			tempdir, err := ioutil.TempDir("", "Test_migrationGenerator_Generate")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tempdir)
+			os.Chdir(tempdir)
+			f, err := os.OpenFile(os.DevNull, os.O_WRONLY, os.ModePerm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			oldStdout, oldStderr := os.Stdout, os.Stderr
+			os.Stdout, os.Stderr = f, f
+			defer func() {
+				os.Stdout, os.Stderr = oldStdout, oldStderr
+			}()
+			c := &generateMigrationCommand{}
+			c.option.EventQueue = v.eventQueue
+			args := []string{v.name}
+			err = c.Run(args)
+			var actual interface{} = err
+			var expect interface{} = nil
+			if !reflect.DeepEqual(actual, expect) {
+				t.Errorf(`generate(%#v) => %#v; want %#v`, args, actual, expect)
+			}
+
+			outpath := filepath.Join("db", "migration", fmt.Sprintf("%s_%s.go", fixedTime.Format("20060102150405"), v.name))
+			if _, err := os.Stat(outpath); os.IsNotExist(err) {
+				t.Errorf("generate(%#v); %#v is not exists; want exists", args, outpath)
+			}
+
+			body, err := ioutil.ReadFile(outpath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual = string(body)
+			expect = v.expect
+			if !reflect.DeepEqual(actual, expect) {
+				t.Errorf("%#v => %#v, want %#v", outpath, actual, expect)
+			}
+		}()
+	}
 }
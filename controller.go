@@ -15,6 +15,8 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+
+	"github.com/naoina/kocha/log"
 )
 
 var contextPool = &sync.Pool{
@@ -139,6 +141,23 @@ type Context struct {
 	Flash    Flash        // flash messages.
 	App      *Application // an application.
 
+	// CSRFToken is the token for CSRF protection of the current request.
+	// CSRFToken will be set by CSRFMiddleware.
+	CSRFToken string
+
+	// CSPNonce is the nonce for Content-Security-Policy of the current request.
+	// CSPNonce will be set by SecurityHeadersMiddleware.
+	CSPNonce string
+
+	// RequestID is the ID of the current request.
+	// RequestID will be set by RequestIDMiddleware.
+	RequestID string
+
+	// Logger is the logger for the current request.
+	// Logger is the same as App.Logger by default. RequestIDMiddleware
+	// replaces it with the logger that has the request ID in the fields.
+	Logger log.Logger
+
 	// Errors represents the map of errors that related to the form values.
 	// A map key is field name, and value is slice of errors.
 	// Errors will be set by Context.Params.Bind().
@@ -255,7 +274,8 @@ func (c *Context) RenderText(content string) error {
 // RenderError renders an error page with statusCode.
 //
 // RenderError is similar to Render, but there is the points where some different.
-// If err is not nil, RenderError outputs the err to log using c.App.Logger.Error.
+// If err is not nil, RenderError outputs the err to log using c.Logger.Error
+// with the fields of err. See also log.WithError.
 // RenderError retrieves a template file from statusCode and c.Response.ContentType.
 // e.g. If statusCode is 500 and ContentType is "application/xml", RenderError will
 // try to retrieve the template file "errors/500.xml".
@@ -263,7 +283,7 @@ func (c *Context) RenderText(content string) error {
 // Also ContentType set to "text/html" if not specified.
 func (c *Context) RenderError(statusCode int, err error, data interface{}) error {
 	if err != nil {
-		c.App.Logger.Error(c.errorWithLine(err))
+		log.WithError(c.App.logger(c), err).Error(err)
 	}
 	if err := c.setData(data); err != nil {
 		return c.errorWithLine(err)
@@ -456,6 +476,10 @@ func (c *Context) reset() {
 	c.Params = nil
 	c.Session = nil
 	c.Flash = nil
+	c.CSRFToken = ""
+	c.CSPNonce = ""
+	c.RequestID = ""
+	c.Logger = nil
 }
 
 func (c *Context) reuse() {
@@ -491,3 +515,82 @@ type ErrorController struct {
 func (ec *ErrorController) GET(c *Context) error {
 	return c.RenderError(ec.StatusCode, nil, nil)
 }
+
+// maxCSPReportSize is the maximum size of a CSP violation report.
+const maxCSPReportSize = 64 * 1024
+
+// CSPReportController is generic controller to receive the violation reports
+// of Content-Security-Policy.
+// The received reports will be output to the log with WARN level.
+// Note that if you use CSRFMiddleware, the route of CSPReportController must
+// be added to CSRFMiddleware.ExemptRoutes.
+type CSPReportController struct {
+	*DefaultController
+}
+
+func (cc *CSPReportController) POST(c *Context) error {
+	var report struct {
+		Report map[string]interface{} `json:"csp-report"`
+	}
+	if err := json.NewDecoder(io.LimitReader(c.Request.Body, maxCSPReportSize)).Decode(&report); err != nil {
+		return c.RenderError(http.StatusBadRequest, nil, nil)
+	}
+	fields := log.Fields{}
+	for k, v := range report.Report {
+		fields[k] = v
+	}
+	c.App.logger(c).With(fields).Warn("kocha: csp violation")
+	c.Response.StatusCode = http.StatusNoContent
+	c.Response.WriteHeader(c.Response.StatusCode)
+	return nil
+}
+
+// LogLevelController is generic controller to get and change the levels of
+// the loggers at runtime.
+//
+// GET returns the level of the logger that is specified by "name" parameter.
+// If the logger hasn't been created yet, GET responds with 404 Not Found.
+// PUT and POST change the level of the logger that is specified by "name"
+// parameter to "level" parameter such as "debug", and create the logger if it
+// doesn't exist. The empty name represents the root logger. See also
+// log.Named.
+//
+// LogLevelController requires FormMiddleware. Note that you must protect the
+// route of LogLevelController by authentication or network restriction, because
+// anyone who can access it can change the levels.
+type LogLevelController struct {
+	*DefaultController
+}
+
+func (lc *LogLevelController) GET(c *Context) error {
+	name := c.Params.Get("name")
+	logger := c.App.Logger
+	if name != "" {
+		var exists bool
+		if logger, exists = log.Lookup(c.App.Logger, name); !exists {
+			c.Response.StatusCode = http.StatusNotFound
+			return c.RenderText(fmt.Sprintf("kocha: log: unknown logger: %v", name))
+		}
+	}
+	return c.RenderText(logger.Level().String())
+}
+
+func (lc *LogLevelController) PUT(c *Context) error {
+	level, err := log.ParseLevel(c.Params.Get("level"))
+	if err != nil {
+		c.Response.StatusCode = http.StatusBadRequest
+		return c.RenderText(err.Error())
+	}
+	name := c.Params.Get("name")
+	logger := c.App.namedLogger(name)
+	logger.SetLevel(level)
+	c.App.logger(c).With(log.Fields{
+		"name":  name,
+		"level": level,
+	}).Warn("kocha: log level changed")
+	return c.RenderText(logger.Level().String())
+}
+
+func (lc *LogLevelController) POST(c *Context) error {
+	return lc.PUT(c)
+}
@@ -8,15 +8,18 @@ import (
 	"mime"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/naoina/kocha"
 	"github.com/naoina/kocha/log"
+	"github.com/naoina/kocha/util"
 )
 
 func TestMimeTypeFormats(t *testing.T) {
@@ -473,6 +476,7 @@ func TestContext_RenderError(t *testing.T) {
 		c := newTestContext("testctrlr", "")
 		var buf bytes.Buffer
 		c.App.Logger = log.New(&buf, c.App.Config.Logger.Formatter, c.App.Config.Logger.Level)
+		log.SetReportCaller(c.App.Logger, true)
 		c.Response.ContentType = v.contentType
 		var actual interface{} = c.RenderError(http.StatusInternalServerError, v.err, nil)
 		_, file, line, _ := runtime.Caller(0)
@@ -483,10 +487,18 @@ func TestContext_RenderError(t *testing.T) {
 		}
 
 		func() {
+			if v.err == nil {
+				return
+			}
 			actual := buf.String()
-			expect := fmt.Sprintf("message:%s:%d: %v", file, line, v.err)
-			if v.err != nil && !strings.Contains(actual, expect) {
-				t.Errorf(`Context.RenderError(%#v, %#v, %#v); log => %#v; want %#v`, http.StatusInternalServerError, v.err, nil, actual, expect)
+			for _, expect := range []string{
+				fmt.Sprintf("message:%v\t", v.err),
+				fmt.Sprintf("error:%v\t", v.err),
+				"function:github.com/naoina/kocha.(*Context).RenderError",
+			} {
+				if !strings.Contains(actual, expect) {
+					t.Errorf(`Context.RenderError(%#v, %#v, %#v); log => %#v; want contains %#v`, http.StatusInternalServerError, v.err, nil, actual, expect)
+				}
 			}
 		}()
 	}
@@ -696,3 +708,91 @@ func TestContext_Redirect(t *testing.T) {
 		}
 	}
 }
+
+func TestCSPReportController_POST(t *testing.T) {
+	now := time.Now()
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	for _, v := range []struct {
+		body   string
+		status int
+		log    string
+	}{
+		{`{"csp-report":{"document-uri":"http://example.com/","violated-directive":"script-src 'self'"}}`, http.StatusNoContent,
+			"level:WARN\ttime:" + now.Format(time.RFC3339Nano) + "\tmessage:kocha: csp violation\tdocument-uri:http://example.com/\tviolated-directive:script-src 'self'\n"},
+		{`invalid`, http.StatusBadRequest, ""},
+	} {
+		c := newTestContext("csp_report", "")
+		var buf bytes.Buffer
+		c.App.Logger = log.New(&buf, &log.LTSVFormatter{}, log.INFO)
+		req, err := http.NewRequest("POST", "/", strings.NewReader(v.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/csp-report")
+		c.Request = &kocha.Request{Request: req}
+		w := httptest.NewRecorder()
+		c.Response = &kocha.Response{ResponseWriter: w, StatusCode: http.StatusOK}
+		if err := (&kocha.CSPReportController{}).POST(c); err != nil {
+			t.Fatal(err)
+		}
+		var actual interface{} = w.Code
+		var expect interface{} = v.status
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`CSPReportController.POST(c) with %#v; status => %#v; want %#v`, v.body, actual, expect)
+		}
+		actual = buf.String()
+		expect = v.log
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`CSPReportController.POST(c) with %#v; log => %#v; want %#v`, v.body, actual, expect)
+		}
+	}
+}
+
+func TestLogLevelController(t *testing.T) {
+	c := newTestContext("log_level", "")
+	c.App.Logger = log.New(ioutil.Discard, &log.LTSVFormatter{}, log.INFO)
+	for _, v := range []struct {
+		method string
+		params url.Values
+		status int
+		body   string
+	}{
+		{"GET", url.Values{}, http.StatusOK, "INFO"},
+		{"GET", url.Values{"name": {"kocha.session"}}, http.StatusNotFound, "kocha: log: unknown logger: kocha.session"},
+		{"PUT", url.Values{"name": {"kocha"}, "level": {"debug"}}, http.StatusOK, "DEBUG"},
+		{"GET", url.Values{"name": {"kocha"}}, http.StatusOK, "DEBUG"},
+		{"GET", url.Values{"name": {"kocha.session"}}, http.StatusNotFound, "kocha: log: unknown logger: kocha.session"},
+		{"GET", url.Values{}, http.StatusOK, "INFO"},
+		{"POST", url.Values{"name": {"kocha.session"}, "level": {"ERROR"}}, http.StatusOK, "ERROR"},
+		{"GET", url.Values{"name": {"kocha.session"}}, http.StatusOK, "ERROR"},
+		{"PUT", url.Values{"level": {"unknown"}}, http.StatusBadRequest, "kocha: log: unknown level: unknown"},
+	} {
+		w := httptest.NewRecorder()
+		c.Response = &kocha.Response{ResponseWriter: w, StatusCode: http.StatusOK}
+		c.Params = &kocha.Params{Values: v.params}
+		ctrl := &kocha.LogLevelController{}
+		var err error
+		switch v.method {
+		case "GET":
+			err = ctrl.GET(c)
+		case "PUT":
+			err = ctrl.PUT(c)
+		case "POST":
+			err = ctrl.POST(c)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actual interface{} = w.Code
+		var expect interface{} = v.status
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`LogLevelController.%s(c) with %#v; status => %#v; want %#v`, v.method, v.params, actual, expect)
+		}
+		actual = w.Body.String()
+		expect = v.body
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`LogLevelController.%s(c) with %#v; body => %#v; want %#v`, v.method, v.params, actual, expect)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// Package cron provides the parser of the cron expressions.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule represents a schedule of the cron expression.
+type Schedule interface {
+	// Next returns the next time of the schedule after t.
+	// It returns the zero time if the schedule won't be activated any more.
+	Next(t time.Time) time.Time
+}
+
+// field represents a field of the cron expression.
+type field struct {
+	name     string
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	secondField = field{name: "second", min: 0, max: 59}
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// descriptors is a map of the predefined schedules.
+var descriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// Parse parses the cron expression and returns the Schedule in the local time
+// zone. See ParseInLocation for the details.
+func Parse(spec string) (Schedule, error) {
+	return ParseInLocation(spec, time.Local)
+}
+
+// ParseInLocation parses the cron expression and returns the Schedule in loc.
+//
+// The expression consists of 5 fields of minute, hour, day of month, month
+// and day of week, or 6 fields that the field of second is prepended to them.
+// Each field accepts "*", a number, a range such as "1-5", a step such as
+// "*/15" or "0-30/10", and a comma-separated list of them. The month and the
+// day of week also accept the names such as "JAN" and "SUN". If both day of
+// month and day of week are restricted, the schedule is activated when either
+// field matches.
+//
+// The expression can be prefixed with "CRON_TZ=<time zone>" or
+// "TZ=<time zone>" to specify the time zone instead of loc.
+// The predefined schedules such as "@daily", "@hourly" and
+// "@every <duration>" are also accepted.
+func ParseInLocation(spec string, loc *time.Location) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.IndexAny(spec, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("kocha: cron: missing fields in `%s'", spec)
+		}
+		name := spec[strings.Index(spec, "=")+1 : i]
+		l, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("kocha: cron: invalid time zone `%s': %v", name, err)
+		}
+		loc, spec = l, strings.TrimSpace(spec[i:])
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			return nil, fmt.Errorf("kocha: cron: invalid duration in `%s': %v", spec, err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("kocha: cron: duration must be 1s or more, but %v", d)
+		}
+		return every(d), nil
+	}
+	if s, ok := descriptors[strings.ToLower(spec)]; ok {
+		spec = s
+	}
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("kocha: cron: expected 5 or 6 fields, but %d in `%s'", len(fields), spec)
+	}
+	s := &schedule{loc: loc}
+	var err error
+	for i, v := range []struct {
+		bits  *uint64
+		field field
+	}{
+		{&s.second, secondField},
+		{&s.minute, minuteField},
+		{&s.hour, hourField},
+		{&s.dom, domField},
+		{&s.month, monthField},
+		{&s.dow, dowField},
+	} {
+		if *v.bits, err = v.field.parse(fields[i]); err != nil {
+			return nil, err
+		}
+	}
+	// 7 is also Sunday.
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1<<0
+	}
+	s.domStar = fields[3] == "*" || fields[3] == "?"
+	s.dowStar = fields[5] == "*" || fields[5] == "?"
+	return s, nil
+}
+
+// parse parses the expression of the field and returns the bits of the
+// matched values.
+func (f field) parse(expr string) (bits uint64, err error) {
+	for _, expr := range strings.Split(expr, ",") {
+		b, err := f.parseRange(expr)
+		if err != nil {
+			return 0, err
+		}
+		bits |= b
+	}
+	return bits, nil
+}
+
+func (f field) parseRange(expr string) (bits uint64, err error) {
+	rangeExpr, step := expr, uint(1)
+	if i := strings.Index(expr, "/"); i >= 0 {
+		n, err := strconv.ParseUint(expr[i+1:], 10, 8)
+		if err != nil || n == 0 {
+			return 0, fmt.Errorf("kocha: cron: invalid step of %s in `%s'", f.name, expr)
+		}
+		rangeExpr, step = expr[:i], uint(n)
+	}
+	var start, end uint
+	switch {
+	case rangeExpr == "*" || rangeExpr == "?":
+		start, end = f.min, f.max
+	case strings.Contains(rangeExpr, "-"):
+		i := strings.Index(rangeExpr, "-")
+		if start, err = f.parseValue(rangeExpr[:i]); err != nil {
+			return 0, err
+		}
+		if end, err = f.parseValue(rangeExpr[i+1:]); err != nil {
+			return 0, err
+		}
+		if start > end {
+			return 0, fmt.Errorf("kocha: cron: invalid range of %s in `%s'", f.name, expr)
+		}
+	default:
+		if start, err = f.parseValue(rangeExpr); err != nil {
+			return 0, err
+		}
+		end = start
+		if step > 1 {
+			end = f.max
+		}
+	}
+	for v := start; v <= end; v += step {
+		bits |= 1 << v
+	}
+	return bits, nil
+}
+
+func (f field) parseValue(s string) (uint, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 8)
+	if err != nil || uint(v) < f.min || uint(v) > f.max {
+		return 0, fmt.Errorf("kocha: cron: %s must be in range %d-%d, but `%s'", f.name, f.min, f.max, s)
+	}
+	return uint(v), nil
+}
+
+// schedule is the Schedule of the cron expression.
+type schedule struct {
+	second, minute, hour, dom, month, dow uint64
+	domStar, dowStar                      bool
+	loc                                   *time.Location
+}
+
+// maxYears is the number of years to find the next time.
+// The schedule that won't be activated within it such as Feb 30 is
+// considered that will never be activated.
+const maxYears = 5
+
+func (s *schedule) Next(t time.Time) time.Time {
+	origLoc := t.Location()
+	t = t.In(s.loc).Add(time.Second - time.Duration(t.Nanosecond())*time.Nanosecond)
+	limit := t.Year() + maxYears
+	for t.Year() <= limit {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.loc)
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+		if s.second&(1<<uint(t.Second())) == 0 {
+			t = t.Add(time.Second)
+			continue
+		}
+		return t.In(origLoc)
+	}
+	return time.Time{}
+}
+
+// matchDay reports whether the day of t matches the day of month and the day
+// of week of the schedule.
+func (s *schedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// every is the Schedule that is activated at the fixed interval.
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e)).Truncate(time.Second)
+}
@@ -0,0 +1,77 @@
+package cron
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseInLocation(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip(err)
+	}
+	base := time.Date(2015, 3, 14, 10, 20, 30, 500, time.UTC)
+	for _, v := range []struct {
+		spec   string
+		expect time.Time
+	}{
+		{"* * * * *", time.Date(2015, 3, 14, 10, 21, 0, 0, time.UTC)},
+		{"* * * * * *", time.Date(2015, 3, 14, 10, 20, 31, 0, time.UTC)},
+		{"*/15 * * * * *", time.Date(2015, 3, 14, 10, 20, 45, 0, time.UTC)},
+		{"5,30 * * * *", time.Date(2015, 3, 14, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2015, 3, 14, 13, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * feb MON", time.Date(2016, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2015, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2015, 3, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2016, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+		{"@hourly", time.Date(2015, 3, 14, 11, 0, 0, 0, time.UTC)},
+		{"@every 1m30s", time.Date(2015, 3, 14, 10, 22, 0, 0, time.UTC)},
+		{"CRON_TZ=Asia/Tokyo 0 0 * * *", time.Date(2015, 3, 14, 15, 0, 0, 0, time.UTC)},
+		{"TZ=Asia/Tokyo @daily", time.Date(2015, 3, 14, 15, 0, 0, 0, time.UTC)},
+	} {
+		s, err := ParseInLocation(v.spec, time.UTC)
+		if err != nil {
+			t.Errorf("ParseInLocation(%q, UTC) => _, %#v; want nil", v.spec, err)
+			continue
+		}
+		actual := s.Next(base)
+		if !actual.Equal(v.expect) || actual.Location() != base.Location() {
+			t.Errorf("ParseInLocation(%q, UTC).Next(%v) => %v; want %v", v.spec, base, actual, v.expect)
+		}
+	}
+
+	s, err := ParseInLocation("0 9 * * *", tokyo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := s.Next(base)
+	expected := time.Date(2015, 3, 15, 0, 0, 0, 0, time.UTC)
+	if !reflect.DeepEqual(actual.UTC(), expected) {
+		t.Errorf(`ParseInLocation("0 9 * * *", Asia/Tokyo).Next(%v) => %v; want %v`, base, actual, expected)
+	}
+
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+		"@every 1ms",
+		"@every foo",
+		"CRON_TZ=Unknown/Zone * * * * *",
+		"CRON_TZ=Asia/Tokyo",
+	} {
+		if _, err := ParseInLocation(spec, time.UTC); err == nil {
+			t.Errorf("ParseInLocation(%q, UTC) => _, nil; want error", spec)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package kocha
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// csrfSecretLength is the length of the CSRF secret in bytes.
+const csrfSecretLength = 32
+
+// maskCSRFToken returns a new token that masked the secret by one-time pad.
+// The token will be changed on each call even if the secret is the same, to
+// mitigate BREACH attack.
+func maskCSRFToken(secret []byte) (string, error) {
+	token := make([]byte, len(secret)*2)
+	pad := token[:len(secret)]
+	if _, err := io.ReadFull(rand.Reader, pad); err != nil {
+		return "", err
+	}
+	for i, b := range secret {
+		token[len(secret)+i] = pad[i] ^ b
+	}
+	return base64.URLEncoding.EncodeToString(token), nil
+}
+
+// unmaskCSRFToken returns the secret from the token masked by maskCSRFToken.
+func unmaskCSRFToken(token string) ([]byte, error) {
+	buf, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("kocha: csrf: invalid token: %v", err)
+	}
+	if len(buf) != csrfSecretLength*2 {
+		return nil, fmt.Errorf("kocha: csrf: invalid token length: %v", len(buf))
+	}
+	pad, masked := buf[:csrfSecretLength], buf[csrfSecretLength:]
+	secret := make([]byte, csrfSecretLength)
+	for i := range secret {
+		secret[i] = pad[i] ^ masked[i]
+	}
+	return secret, nil
+}
+
+// verifyCSRFOrigin verifies that the request came from the same origin by
+// Origin header, or Referer header if Origin isn't present.
+func verifyCSRFOrigin(r *Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Referer()
+	}
+	if origin == "" {
+		return fmt.Errorf("kocha: csrf: neither Origin nor Referer is present")
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return fmt.Errorf("kocha: csrf: invalid origin: %v", err)
+	}
+	if u.Scheme != r.Scheme() || u.Host != r.Host {
+		return fmt.Errorf("kocha: csrf: origin mismatch: %v", origin)
+	}
+	return nil
+}
@@ -4,18 +4,30 @@ import (
 	"os"
 	"reflect"
 	"strconv"
+	"time"
 
 	"github.com/naoina/kocha/event"
+	"github.com/naoina/kocha/log"
+	"github.com/naoina/kocha/util"
 )
 
 // EventHandlerMap represents a map of event handlers.
 type EventHandlerMap map[event.Queue]map[string]func(app *Application, args ...interface{}) error
 
+// EventRetryHandlerMap represents a map of event handlers that receive the
+// number of the current attempt.
+type EventRetryHandlerMap map[event.Queue]map[string]func(app *Application, attempt int, args ...interface{}) error
+
 // Evevnt represents the event.
 type Event struct {
 	// HandlerMap is a map of queue/handlers.
 	HandlerMap EventHandlerMap
 
+	// RetryHandlerMap is a map of queue/handlers that receive the number of
+	// the current attempt that starts from 1. It can be used with HandlerMap
+	// for the same event. See also RetryPolicies.
+	RetryHandlerMap EventRetryHandlerMap
+
 	// WorkersPerQueue is a number of workers per queue.
 	// The default value is taken from GOMAXPROCS.
 	// If value of GOMAXPROCS is invalid, set to 1.
@@ -23,33 +35,227 @@ type Event struct {
 
 	// ErrorHandler is the handler for error.
 	// If you want to use your own error handler, please set to ErrorHandler.
+	// The error that the handler of the event triggered by TriggerContext
+	// (or TriggerAtContext, TriggerAfterContext and TriggerUniqueContext)
+	// returns will be passed as *EventError with the request ID. Use its
+	// Unwrap to get the original error.
 	ErrorHandler func(err interface{})
 
+	// RetryPolicies is a map of event name/retry policy.
+	// The handlers of the event name will be retried according to the policy
+	// when they return an error. The handlers in RetryHandlerMap can know the
+	// number of the current attempt. See event.RetryPolicy.
+	RetryPolicies map[string]event.RetryPolicy
+
+	// DeadLetterQueue is the queue to store the payloads that the handler has
+	// failed after all attempts. The stored payloads can be enqueued again by
+	// Requeue.
+	DeadLetterQueue event.DeadLetterQueue
+
+	// Priorities is a map of event name/priority.
+	// The payloads of the event will be dequeued in order of the priority if
+	// the queue implements event.PriorityQueue.
+	Priorities map[string]event.Priority
+
+	// Types is a map of event name/value of the argument type.
+	// The argument of the event will be passed to the handlers as the same
+	// type as the value instead of the decoded type of JSON such as
+	// map[string]interface{}. See event.RegisterType.
+	Types map[string]interface{}
+
+	// Codec is the codec to encode the arguments of the events in Types.
+	// If nil, event.JSONCodec will be used.
+	Codec event.Codec
+
+	// SlowHandlerThreshold is the threshold of the latency of the slow
+	// handlers. If greater than 0, an attempt of the handler that takes
+	// SlowHandlerThreshold or more will be logged as a warning.
+	SlowHandlerThreshold time.Duration
+
+	// StopTimeout is the time to wait for the handlers to complete on the
+	// shutdown. The payloads that haven't completed in time will be handed
+	// back to the queue and logged as abandoned. If 0, the shutdown waits for
+	// the handlers forever.
+	StopTimeout time.Duration
+
 	e   *event.Event
 	app *Application
 }
 
 // Trigger emits the event.
-// The name is an event name that is defined in e.HandlerMap.
-// If args given, they will be passed to event handler that is defined in e.HandlerMap.
+// The name is an event name that is defined in e.HandlerMap or e.RetryHandlerMap.
+// If args given, they will be passed to event handler that is defined in e.HandlerMap or e.RetryHandlerMap.
+// Trigger doesn't carry the request ID. Use TriggerContext in the controllers
+// to carry it.
 func (e *Event) Trigger(name string, args ...interface{}) error {
 	return e.e.Trigger(name, args...)
 }
 
-func (e *Event) addHandler(name string, queueName string, handler func(app *Application, args ...interface{}) error) error {
-	return e.e.AddHandler(name, queueName, func(args ...interface{}) error {
-		return handler(e.app, args...)
+// TriggerAt emits the event at t.
+// It returns the ID of the scheduled event that can be used to Cancel.
+func (e *Event) TriggerAt(t time.Time, name string, args ...interface{}) (id string, err error) {
+	return e.e.TriggerAt(t, name, args...)
+}
+
+// TriggerAtContext is similar to TriggerAt, but it also carries the request
+// ID of c in the payload as TriggerContext.
+func (e *Event) TriggerAtContext(c *Context, t time.Time, name string, args ...interface{}) (id string, err error) {
+	return e.e.TriggerAtMeta(t, name, contextMeta(c), args...)
+}
+
+// TriggerAfter emits the event after d.
+// It returns the ID of the scheduled event that can be used to Cancel.
+func (e *Event) TriggerAfter(d time.Duration, name string, args ...interface{}) (id string, err error) {
+	return e.e.TriggerAfter(d, name, args...)
+}
+
+// TriggerAfterContext is similar to TriggerAfter, but it also carries the
+// request ID of c in the payload as TriggerContext.
+func (e *Event) TriggerAfterContext(c *Context, d time.Duration, name string, args ...interface{}) (id string, err error) {
+	return e.e.TriggerAfterMeta(d, name, contextMeta(c), args...)
+}
+
+// Cancel cancels the scheduled event of id.
+func (e *Event) Cancel(id string) error {
+	return e.e.Cancel(id)
+}
+
+// TriggerUnique emits the event unless the event of u.Key already exists in
+// the queue. If the event hasn't been emitted, it returns event.ErrDuplicate.
+// See event.Unique for details.
+func (e *Event) TriggerUnique(u event.Unique, name string, args ...interface{}) error {
+	return e.e.TriggerUnique(u, name, args...)
+}
+
+// TriggerUniqueContext is similar to TriggerUnique, but it also carries the
+// request ID of c in the payload as TriggerContext.
+func (e *Event) TriggerUniqueContext(c *Context, u event.Unique, name string, args ...interface{}) error {
+	return e.e.TriggerUniqueMeta(u, name, contextMeta(c), args...)
+}
+
+// TriggerContext is similar to Trigger, but it also carries the request ID of
+// c in the payload. If the handler returns an error, the error will be passed
+// to ErrorHandler as *EventError with the request ID, so that the log of
+// workers can be joined with the log of the request.
+func (e *Event) TriggerContext(c *Context, name string, args ...interface{}) error {
+	return e.e.TriggerMeta(name, contextMeta(c), args...)
+}
+
+// contextMeta returns the metadata of the event that carries the request ID
+// of c.
+func contextMeta(c *Context) event.Meta {
+	if c.RequestID == "" {
+		return nil
+	}
+	return event.Meta{requestIDKey: c.RequestID}
+}
+
+// Done returns a channel that is closed when the application is shutting
+// down. The long-running handlers should watch it to return promptly.
+func (e *Event) Done() <-chan struct{} {
+	return e.e.Done()
+}
+
+// Metrics returns the snapshot of the metrics of each queue such as the
+// number of the processed payloads and the latency histogram of the handlers.
+// The key of the map is the type name of the queue in HandlerMap.
+func (e *Event) Metrics() map[string]event.QueueMetrics {
+	return e.e.Metrics()
+}
+
+// Requeue enqueues the payload of letter that is stored in DeadLetterQueue
+// again.
+func (e *Event) Requeue(letter *event.DeadLetter) error {
+	return e.e.Requeue(letter)
+}
+
+func (e *Event) addHandler(name string, queueName string, handler func(app *Application, attempt int, args ...interface{}) error) error {
+	return e.e.AddRetryHandler(name, queueName, e.RetryPolicies[name], func(attempt int, meta event.Meta, args ...interface{}) error {
+		err := handler(e.app, attempt, args...)
+		if err == nil || meta[requestIDKey] == "" {
+			return err
+		}
+		return &EventError{
+			Name:      name,
+			RequestID: meta[requestIDKey],
+			Attempt:   attempt,
+			Err:       err,
+		}
 	})
 }
 
+// handleError passes err to e.ErrorHandler if it isn't nil.
+// Otherwise, it outputs err to the log of the application.
+func (e *Event) handleError(err interface{}) {
+	if e.ErrorHandler != nil {
+		e.ErrorHandler(err)
+		return
+	}
+	logger := log.Named(e.app.Logger, "kocha.event")
+	if err, ok := err.(*EventError); ok {
+		logger = logger.With(log.Fields{
+			"event":      err.Name,
+			"attempt":    err.Attempt,
+			requestIDKey: err.RequestID,
+		})
+	}
+	logger.Error(err)
+}
+
+// logSlowHandler outputs the latency of the slow handler to the log of the
+// application.
+func (e *Event) logSlowHandler(name, queueName string, elapsed time.Duration) {
+	log.Named(e.app.Logger, "kocha.event").With(log.Fields{
+		"event":   name,
+		"queue":   queueName,
+		"elapsed": elapsed.String(),
+	}).Warn("kocha: event: slow handler")
+}
+
 func (e *Event) build(app *Application) (*Event, error) {
 	if e == nil {
 		e = &Event{}
 	}
 	e.e = event.New()
-	for queue, handlerMap := range e.HandlerMap {
+	e.e.Codec = e.Codec
+	e.app = app
+	for name, priority := range e.Priorities {
+		e.e.SetPriority(name, priority)
+	}
+	for name, v := range e.Types {
+		if err := e.e.RegisterType(name, v); err != nil {
+			return nil, err
+		}
+	}
+	queueNames := make(map[event.Queue]string)
+	registerQueue := func(queue event.Queue) (string, error) {
+		if queueName, exist := queueNames[queue]; exist {
+			return queueName, nil
+		}
 		queueName := reflect.TypeOf(queue).String()
 		if err := e.e.RegisterQueue(queueName, queue); err != nil {
+			return "", err
+		}
+		queueNames[queue] = queueName
+		return queueName, nil
+	}
+	for queue, handlerMap := range e.HandlerMap {
+		queueName, err := registerQueue(queue)
+		if err != nil {
+			return nil, err
+		}
+		for name, handler := range handlerMap {
+			handler := handler
+			if err := e.addHandler(name, queueName, func(app *Application, attempt int, args ...interface{}) error {
+				return handler(app, args...)
+			}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for queue, handlerMap := range e.RetryHandlerMap {
+		queueName, err := registerQueue(queue)
+		if err != nil {
 			return nil, err
 		}
 		for name, handler := range handlerMap {
@@ -65,14 +271,48 @@ func (e *Event) build(app *Application) (*Event, error) {
 		}
 	}
 	e.e.SetWorkersPerQueue(n)
-	e.e.ErrorHandler = e.ErrorHandler
+	e.e.ErrorHandler = e.handleError
+	e.e.DeadLetterQueue = e.DeadLetterQueue
+	e.e.SlowThreshold = e.SlowHandlerThreshold
+	e.e.SlowHandler = e.logSlowHandler
 	return e, nil
 }
 
+// EventError represents an error that occurred in the handler of the event
+// that has been triggered by TriggerContext or its variants.
+type EventError struct {
+	Name      string // event name.
+	RequestID string // request ID of the request that emits the event.
+	Attempt   int    // number of attempts of the handler.
+	Err       error  // original error.
+}
+
+func (e *EventError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the original error.
+func (e *EventError) Unwrap() error {
+	return e.Err
+}
+
 func (e *Event) start() {
 	e.e.Start()
 }
 
 func (e *Event) stop() {
-	e.e.Stop()
+	var deadline time.Time
+	if e.StopTimeout > 0 {
+		deadline = util.Now().Add(e.StopTimeout)
+	}
+	for _, a := range e.e.StopDeadline(deadline) {
+		logger := log.Named(e.app.Logger, "kocha.event").With(log.Fields{
+			"event": a.Name,
+			"queue": a.Queue,
+		})
+		if a.Err != nil {
+			logger = log.WithError(logger, a.Err)
+		}
+		logger.Warn("kocha: event: handlers have been abandoned by the shutdown")
+	}
 }
@@ -0,0 +1,77 @@
+package event
+
+// Lease represents the data that has been fetched from the queue and must be
+// acknowledged.
+type Lease interface {
+	// Data returns the fetched data.
+	Data() string
+
+	// Ack notifies that the data has been processed, then the data will be
+	// removed from the queue.
+	Ack() error
+
+	// Nack notifies that the data couldn't be processed, then the data will
+	// be delivered again if the queue supports it.
+	Nack() error
+}
+
+// AckQueue is the interface that is implemented by the queue that supports
+// the acknowledgement for the at-least-once delivery.
+//
+// If the queue implements AckQueue, the workers fetch the data by Lease
+// instead of Dequeue. The lease will be acknowledged after all handlers for
+// the data have completed, including the handlers that have failed after all
+// attempts. It will be nacked only if the retries have been interrupted by
+// Stop or the payload has been abandoned by StopDeadline.
+// The queue that doesn't implement AckQueue will be adapted by NewAckQueue.
+type AckQueue interface {
+	Queue
+
+	// Lease returns the lease of the data that fetch from the queue.
+	// The data won't be delivered to the other workers until the lease is
+	// nacked. It will return ErrDone as err when Stop is called.
+	Lease() (Lease, error)
+}
+
+// NewAckQueue returns queue as AckQueue.
+// If queue doesn't implement AckQueue, the returned AckQueue considers that
+// the data has been processed when it is dequeued, so Ack and Nack of the
+// lease do nothing.
+func NewAckQueue(queue Queue) AckQueue {
+	if q, ok := queue.(AckQueue); ok {
+		return q
+	}
+	return &autoAckQueue{queue}
+}
+
+// autoAckQueue is an adapter of Queue to AckQueue.
+type autoAckQueue struct {
+	Queue
+}
+
+func (q *autoAckQueue) New(n int) Queue {
+	return NewAckQueue(q.Queue.New(n))
+}
+
+func (q *autoAckQueue) Lease() (Lease, error) {
+	data, err := q.Dequeue()
+	if err != nil {
+		return nil, err
+	}
+	return autoAckLease(data), nil
+}
+
+// autoAckLease is the lease that has already been acknowledged.
+type autoAckLease string
+
+func (l autoAckLease) Data() string {
+	return string(l)
+}
+
+func (l autoAckLease) Ack() error {
+	return nil
+}
+
+func (l autoAckLease) Nack() error {
+	return nil
+}
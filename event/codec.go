@@ -0,0 +1,89 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Codec is the interface that encodes and decodes the arguments of the typed
+// events. See RegisterType.
+type Codec interface {
+	// Name returns the name of the codec.
+	// The name is carried in the payload to select the codec for decoding.
+	Name() string
+
+	// Marshal returns the encoding of v.
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes data and stores the result into v.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec is the Codec with encoding/json.
+	// This is used by default.
+	JSONCodec Codec = jsonCodec{}
+
+	// MsgpackCodec is the Codec with the MessagePack format.
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+// codecs is a map of the builtin codecs by name.
+var codecs = map[string]Codec{
+	JSONCodec.Name():    JSONCodec,
+	MsgpackCodec.Name(): MsgpackCodec,
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+var msgpackHandle = &codec.MsgpackHandle{}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) Marshal(v interface{}) (data []byte, err error) {
+	if err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(v); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, msgpackHandle).Decode(v)
+}
+
+// codec returns the Codec to encode the typed arguments.
+func (e *Event) codec() Codec {
+	if e.Codec != nil {
+		return e.Codec
+	}
+	return JSONCodec
+}
+
+// codecByName returns the Codec of name to decode the typed arguments.
+func (e *Event) codecByName(name string) (Codec, error) {
+	if c := e.codec(); c.Name() == name {
+		return c, nil
+	}
+	if c, exist := codecs[name]; exist {
+		return c, nil
+	}
+	return nil, fmt.Errorf("kocha: event: unknown codec `%s'", name)
+}
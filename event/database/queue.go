@@ -0,0 +1,553 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/naoina/kocha/event"
+	"github.com/naoina/kocha/util"
+)
+
+const (
+	// DefaultTableName is the default name of the table to store the queued
+	// data.
+	DefaultTableName = "kocha_event_queue"
+
+	// DefaultName is the default name of the queue in the table.
+	DefaultName = "default"
+
+	// DefaultVisibilityTimeout is the default duration that the dequeued data
+	// is invisible from the other workers.
+	DefaultVisibilityTimeout = 5 * time.Minute
+
+	// DefaultPollInterval is the default interval to poll the table.
+	DefaultPollInterval = 1 * time.Second
+)
+
+// errConflict is returned when the data has been claimed by another worker.
+var errConflict = errors.New("kocha: event: database: conflict")
+
+// CreateTableQueries returns the queries to create the table of table name.
+// The queries are compatible with MySQL, PostgreSQL and SQLite3.
+// You can add them to the migration by "kocha generate migration --event-queue".
+func CreateTableQueries(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE %s (
+    id VARCHAR(64) NOT NULL,
+    queue VARCHAR(255) NOT NULL,
+    data TEXT NOT NULL,
+    enqueued_at BIGINT NOT NULL,
+    visible_at BIGINT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    unique_key VARCHAR(255),
+    unique_until BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (queue, id)
+)`, table),
+		fmt.Sprintf(`CREATE INDEX %s_queue_visible_at ON %s (queue, visible_at)`, table, table),
+		fmt.Sprintf(`CREATE UNIQUE INDEX %s_queue_unique_key ON %s (queue, unique_key)`, table, table),
+	}
+}
+
+// DropTableQueries returns the queries to drop the table of table name.
+func DropTableQueries(table string) []string {
+	return []string{
+		fmt.Sprintf(`DROP TABLE %s`, table),
+	}
+}
+
+// EventQueue implements the AckQueue, DelayQueue, DepthQueue and UniqueQueue
+// interfaces with the table of the database.
+// This doesn't require the message brokers, and the queued data will be
+// shared between the servers that connect to the same database.
+//
+// Lease claims the oldest visible data by making it invisible for
+// VisibilityTimeout, so that the data that has been claimed by a crashed
+// worker will become visible again. The row will be deleted by Ack, and will
+// be visible again by Nack. Ack and Nack do nothing if the visibility timeout
+// has expired and the row has been claimed again by another worker. The row will be locked by "SELECT ... FOR
+// UPDATE" if the dialect supports it, otherwise it will be claimed by the
+// optimistic update such as SQLite3.
+//
+// The table must be created in advance by CreateTableQueries.
+type EventQueue struct {
+	// Driver is the name of the database driver such as "mysql".
+	// Driver is also used to determine the SQL dialect.
+	Driver string
+
+	// DSN is the data source name to open the database.
+	DSN string
+
+	// DB is the database to store the queued data.
+	// If DB is nil, the database will be opened by Driver and DSN.
+	DB *sql.DB
+
+	// Table is the name of the table.
+	// If Table is empty, DefaultTableName will be used.
+	Table string
+
+	// Name is the name of the queue in the table. The queues of the different
+	// names can share the same table.
+	// If Name is empty, DefaultName will be used.
+	Name string
+
+	// VisibilityTimeout is the duration that the dequeued data is invisible
+	// from the other workers.
+	// If VisibilityTimeout is 0, DefaultVisibilityTimeout will be used.
+	VisibilityTimeout time.Duration
+
+	// PollInterval is the interval to poll the table when the queue is empty.
+	// If PollInterval is 0, DefaultPollInterval will be used.
+	PollInterval time.Duration
+
+	s    *state
+	done chan struct{}
+	once sync.Once
+	mu   sync.Mutex
+}
+
+// New returns a new EventQueue that shares the database with q.
+func (q *EventQueue) New(n int) event.Queue {
+	return &EventQueue{
+		Driver:            q.Driver,
+		DSN:               q.DSN,
+		DB:                q.DB,
+		Table:             q.Table,
+		Name:              q.Name,
+		VisibilityTimeout: q.VisibilityTimeout,
+		PollInterval:      q.PollInterval,
+		s:                 q.state(),
+		done:              make(chan struct{}),
+	}
+}
+
+// Enqueue adds data to the queue.
+func (q *EventQueue) Enqueue(data string) error {
+	s := q.state()
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	now := util.Now().UnixNano()
+	if _, err := db.Exec(s.query(`INSERT INTO %s (id, queue, data, enqueued_at, visible_at, attempts) VALUES (?, ?, ?, ?, ?, 0)`),
+		hex.EncodeToString(util.GenerateRandomKey(16)), s.name, data, now, now); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// Depth returns the number of the data that is visible to the workers.
+func (q *EventQueue) Depth() (int, error) {
+	return q.state().depth()
+}
+
+// EnqueueUnique adds data to the queue unless the data of u.Key exists.
+// The data is considered to be running while it is claimed and the
+// visibility timeout hasn't expired.
+// The uniqueness is guaranteed by the unique index of the table, so that only
+// one of the data of the same key is enqueued even if they are enqueued at
+// the same time by the different servers.
+func (q *EventQueue) EnqueueUnique(u event.Unique, data string) error {
+	s := q.state()
+	for {
+		switch err := s.enqueueUnique(u, data); err {
+		case errConflict:
+			continue
+		case nil:
+			s.notify()
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// EnqueueAt adds data to the queue that will be delivered at t.
+func (q *EventQueue) EnqueueAt(id string, data string, t time.Time) error {
+	s := q.state()
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(s.query(`INSERT INTO %s (id, queue, data, enqueued_at, visible_at, attempts) VALUES (?, ?, ?, ?, ?, 0)`),
+		id, s.name, data, util.Now().UnixNano(), t.UnixNano()); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// Cancel removes the scheduled data of id that hasn't been delivered yet.
+func (q *EventQueue) Cancel(id string) error {
+	s := q.state()
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	result, err := db.Exec(s.query(`DELETE FROM %s WHERE queue = ? AND id = ? AND attempts = 0`), s.name, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return event.ErrNotScheduled
+	}
+	return nil
+}
+
+// Dequeue returns the data that fetch from the queue and deletes it.
+// If the queue is empty, Dequeue polls the table every PollInterval until the
+// data is enqueued or Stop is called.
+func (q *EventQueue) Dequeue() (data string, err error) {
+	l, err := q.Lease()
+	if err != nil {
+		return "", err
+	}
+	if err := l.Ack(); err != nil {
+		return "", err
+	}
+	return l.Data(), nil
+}
+
+// Lease returns the lease of the data that fetch from the queue.
+// If the queue is empty, Lease polls the table every PollInterval until the
+// data is enqueued or Stop is called.
+// If the data couldn't be claimed by an error such as the lost connection,
+// Lease returns the error after waiting PollInterval, so that the worker
+// doesn't retry it immediately.
+func (q *EventQueue) Lease() (event.Lease, error) {
+	s := q.state()
+	for {
+		signal := s.wait()
+		l, err := s.claim()
+		switch err {
+		case nil:
+			return l, nil
+		case errConflict:
+			continue
+		case sql.ErrNoRows:
+		default:
+			// the enqueued data doesn't resolve the error.
+			signal = nil
+		}
+		timer := time.NewTimer(s.pollInterval)
+		select {
+		case <-signal:
+		case <-timer.C:
+		case <-q.done:
+			timer.Stop()
+			return nil, event.ErrDone
+		}
+		timer.Stop()
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+}
+
+// Stop stops the queue.
+func (q *EventQueue) Stop() {
+	q.once.Do(func() {
+		if q.done != nil {
+			close(q.done)
+		}
+	})
+}
+
+func (q *EventQueue) state() *state {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.s == nil {
+		q.s = newState(q)
+	}
+	return q.s
+}
+
+// state is the state that is shared by the EventQueues.
+type state struct {
+	driver            string
+	dsn               string
+	db                *sql.DB
+	table             string
+	name              string
+	visibilityTimeout time.Duration
+	pollInterval      time.Duration
+	signal            chan struct{} // closed when the data is enqueued.
+	mu                sync.Mutex
+}
+
+func newState(q *EventQueue) *state {
+	s := &state{
+		driver:            q.Driver,
+		dsn:               q.DSN,
+		db:                q.DB,
+		table:             q.Table,
+		name:              q.Name,
+		visibilityTimeout: q.VisibilityTimeout,
+		pollInterval:      q.PollInterval,
+		signal:            make(chan struct{}),
+	}
+	if s.table == "" {
+		s.table = DefaultTableName
+	}
+	if s.name == "" {
+		s.name = DefaultName
+	}
+	if s.visibilityTimeout <= 0 {
+		s.visibilityTimeout = DefaultVisibilityTimeout
+	}
+	if s.pollInterval <= 0 {
+		s.pollInterval = DefaultPollInterval
+	}
+	return s
+}
+
+// open returns the database. It opens the database at the first call.
+func (s *state) open() (*sql.DB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db != nil {
+		return s.db, nil
+	}
+	db, err := sql.Open(s.driver, s.dsn)
+	if err != nil {
+		return nil, err
+	}
+	s.db = db
+	return db, nil
+}
+
+// wait returns the channel that will be closed when the data is enqueued by
+// this process.
+func (s *state) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.signal
+}
+
+func (s *state) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.signal)
+	s.signal = make(chan struct{})
+}
+
+// claim makes the oldest visible data invisible for the visibility timeout,
+// and returns the lease of it.
+// If there is no visible data, it returns sql.ErrNoRows. If the data has been
+// claimed by another worker, it returns errConflict.
+func (s *state) claim() (l *lease, err error) {
+	db, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	now := util.Now().UnixNano()
+	var (
+		id, data  string
+		visibleAt int64
+	)
+	if err := tx.QueryRow(s.query(`SELECT id, data, visible_at FROM %s WHERE queue = ? AND visible_at <= ? ORDER BY enqueued_at, id LIMIT 1`+s.lockClause()),
+		s.name, now).Scan(&id, &data, &visibleAt); err != nil {
+		return nil, err
+	}
+	claimedAt := now + int64(s.visibilityTimeout)
+	result, err := tx.Exec(s.query(`UPDATE %s SET visible_at = ?, attempts = attempts + 1 WHERE queue = ? AND id = ? AND visible_at = ?`),
+		claimedAt, s.name, id, visibleAt)
+	if err != nil {
+		return nil, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 {
+		return nil, errConflict
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &lease{s: s, id: id, data: data, visibleAt: claimedAt}, nil
+}
+
+// enqueueUnique adds data of u.Key unless the data of the key exists.
+// Only one row holds the key at the same time. If the existing data is
+// running and the new data should be enqueued, the running data releases the
+// key. If the row has been changed by another process, it returns errConflict.
+func (s *state) enqueueUnique(u event.Unique, data string) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	now := util.Now().UnixNano()
+	until := int64(0)
+	if u.Window > 0 {
+		until = now + int64(u.Window)
+	}
+	var (
+		id                     string
+		visibleAt, uniqueUntil int64
+		attempts               int
+	)
+	switch err := db.QueryRow(s.query(`SELECT id, visible_at, attempts, unique_until FROM %s WHERE queue = ? AND unique_key = ?`),
+		s.name, u.Key).Scan(&id, &visibleAt, &attempts, &uniqueUntil); {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	case attempts > 0 && visibleAt > now: // running.
+		if uniqueUntil == 0 || now < uniqueUntil {
+			switch u.Policy {
+			case event.UniqueSkip:
+				return event.ErrDuplicate
+			case event.UniqueExtend:
+				if err := s.update(`UPDATE %s SET unique_until = ? WHERE queue = ? AND id = ? AND unique_key = ?`, until, s.name, id, u.Key); err != nil {
+					return err
+				}
+				return event.ErrDuplicate
+			}
+			// the running data can't be replaced, so the new data will be
+			// enqueued.
+		}
+		if err := s.update(`UPDATE %s SET unique_key = NULL WHERE queue = ? AND id = ? AND visible_at = ? AND attempts = ?`, s.name, id, visibleAt, attempts); err != nil {
+			return err
+		}
+	default: // pending.
+		switch u.Policy {
+		case event.UniqueReplace:
+			return s.update(`UPDATE %s SET data = ?, unique_until = ? WHERE queue = ? AND id = ? AND visible_at = ? AND attempts = ?`, data, until, s.name, id, visibleAt, attempts)
+		case event.UniqueExtend:
+			if err := s.update(`UPDATE %s SET unique_until = ? WHERE queue = ? AND id = ? AND visible_at = ? AND attempts = ?`, until, s.name, id, visibleAt, attempts); err != nil {
+				return err
+			}
+		}
+		return event.ErrDuplicate
+	}
+	if _, err := db.Exec(s.query(`INSERT INTO %s (id, queue, data, enqueued_at, visible_at, attempts, unique_key, unique_until) VALUES (?, ?, ?, ?, ?, 0, ?, ?)`),
+		hex.EncodeToString(util.GenerateRandomKey(16)), s.name, data, now, now, u.Key, until); err != nil {
+		// the data of the same key has been enqueued by another process if
+		// the insert violates the unique index.
+		var n int
+		if e := db.QueryRow(s.query(`SELECT COUNT(*) FROM %s WHERE queue = ? AND unique_key = ?`), s.name, u.Key).Scan(&n); e == nil && n > 0 {
+			return errConflict
+		}
+		return err
+	}
+	return nil
+}
+
+// update executes the query to update the row.
+// If no row has been updated, it returns errConflict.
+func (s *state) update(query string, args ...interface{}) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	result, err := db.Exec(s.query(query), args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n < 1 {
+		return errConflict
+	}
+	return nil
+}
+
+// depth returns the number of the visible data.
+func (s *state) depth() (n int, err error) {
+	db, err := s.open()
+	if err != nil {
+		return 0, err
+	}
+	err = db.QueryRow(s.query(`SELECT COUNT(*) FROM %s WHERE queue = ? AND visible_at <= ?`), s.name, util.Now().UnixNano()).Scan(&n)
+	return n, err
+}
+
+// delete deletes the claimed data.
+// The data that has been claimed again by another worker after the visibility
+// timeout won't be deleted.
+func (s *state) delete(id string, visibleAt int64) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(s.query(`DELETE FROM %s WHERE queue = ? AND id = ? AND visible_at = ?`), s.name, id, visibleAt)
+	return err
+}
+
+// release makes the claimed data visible again.
+// The data that has been claimed again by another worker after the visibility
+// timeout won't be released.
+func (s *state) release(id string, visibleAt int64) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(s.query(`UPDATE %s SET visible_at = ? WHERE queue = ? AND id = ? AND visible_at = ?`), util.Now().UnixNano(), s.name, id, visibleAt)
+	return err
+}
+
+// lockClause returns the clause to lock the selected row.
+func (s *state) lockClause() string {
+	switch s.driver {
+	case "postgres":
+		return " FOR UPDATE SKIP LOCKED"
+	case "mysql":
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+// query returns the query that the table name has been embedded, and the
+// placeholders have been converted for the dialect.
+func (s *state) query(q string) string {
+	q = fmt.Sprintf(q, s.table)
+	if s.driver != "postgres" {
+		return q
+	}
+	parts := strings.Split(q, "?")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = "$" + strconv.Itoa(i) + parts[i]
+	}
+	return strings.Join(parts, "")
+}
+
+// lease implements the event.Lease interface.
+type lease struct {
+	s         *state
+	id        string
+	data      string
+	visibleAt int64 // visible_at of the row while it is claimed by this lease.
+}
+
+func (l *lease) Data() string {
+	return l.data
+}
+
+func (l *lease) Ack() error {
+	return l.s.delete(l.id, l.visibleAt)
+}
+
+func (l *lease) Nack() error {
+	return l.s.release(l.id, l.visibleAt)
+}
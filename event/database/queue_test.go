@@ -0,0 +1,375 @@
+package database
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/naoina/kocha/event"
+	"github.com/naoina/kocha/util"
+)
+
+func openTestDB(t *testing.T) (db *sql.DB, cleanup func()) {
+	dir, err := ioutil.TempDir("", "TestEventQueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = sql.Open("sqlite3", filepath.Join(dir, "test.db")+"?_busy_timeout=5000")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	for _, query := range CreateTableQueries(DefaultTableName) {
+		if _, err := db.Exec(query); err != nil {
+			db.Close()
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEventQueue(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	e := event.New()
+	if err := e.RegisterQueue("database", &EventQueue{Driver: "sqlite3", DB: db}); err != nil {
+		t.Fatal(err)
+	}
+	e.SetWorkersPerQueue(2)
+	e.Start()
+	defer e.Stop()
+
+	handlerName := "testEventQueueHandler"
+	called := make(chan interface{})
+	if err := e.AddHandler(handlerName, "database", func(args ...interface{}) error {
+		called <- args[0]
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Trigger(handlerName, "arg"); err != nil {
+		t.Errorf("event.Trigger(%q) => %#v, want nil", handlerName, err)
+	}
+	select {
+	case actual := <-called:
+		expected := "arg"
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("handler called with %#v; want %#v", actual, expected)
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("event.Trigger(%q) has try to call handler but hasn't been called within 3 seconds", handlerName)
+	}
+}
+
+func TestEventQueue_visibilityTimeout(t *testing.T) {
+	now := time.Now()
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	q := (&EventQueue{Driver: "sqlite3", DB: db, VisibilityTimeout: time.Minute}).New(1).(*EventQueue)
+	defer q.Stop()
+	for _, data := range []string{"1", "2"} {
+		if err := q.Enqueue(data); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(1 * time.Second)
+	}
+
+	// claimed by a crashed worker.
+	l, err := q.s.claim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual interface{} = l.data
+	var expected interface{} = "1"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("claim() => %#v; want %#v", actual, expected)
+	}
+
+	for _, v := range []struct {
+		elapsed time.Duration
+		expect  string
+	}{
+		{0, "2"},
+		{1 * time.Minute, "1"},
+	} {
+		now = now.Add(v.elapsed)
+		actual, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, v.expect) {
+			t.Errorf("Dequeue() after %v => %#v; want %#v", v.elapsed, actual, v.expect)
+		}
+	}
+	if _, err := q.s.claim(); err != sql.ErrNoRows {
+		t.Errorf("claim() => %#v; want %#v", err, sql.ErrNoRows)
+	}
+}
+
+func TestEventQueue_Lease_stale(t *testing.T) {
+	now := time.Now()
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	q := (&EventQueue{Driver: "sqlite3", DB: db, VisibilityTimeout: time.Minute}).New(1).(*EventQueue)
+	defer q.Stop()
+	if err := q.Enqueue("1"); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := q.Lease()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	l, err := q.Lease()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := func() (n int) {
+		if err := db.QueryRow(`SELECT COUNT(*) FROM ` + DefaultTableName).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if err := stale.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	var actual interface{} = count()
+	var expected interface{} = 1
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Ack() of the stale lease; number of the rows => %#v; want %#v", actual, expected)
+	}
+	if err := stale.Nack(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.s.claim(); err != sql.ErrNoRows {
+		t.Errorf("Nack() of the stale lease; claim() => %#v; want %#v", err, sql.ErrNoRows)
+	}
+	if err := l.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	actual = count()
+	expected = 0
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Ack() of the current lease; number of the rows => %#v; want %#v", actual, expected)
+	}
+}
+
+func TestEventQueue_Lease_withError(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	interval := 100 * time.Millisecond
+	q := (&EventQueue{Driver: "sqlite3", DB: db, Table: "missing", PollInterval: interval}).New(1).(*EventQueue)
+	start := time.Now()
+	if _, err := q.Lease(); err == nil {
+		t.Errorf("Lease() with the missing table => nil; want error")
+	}
+	if elapsed := time.Since(start); elapsed < interval {
+		t.Errorf("Lease() with the missing table returned after %v; want after %v", elapsed, interval)
+	}
+
+	q = (&EventQueue{Driver: "sqlite3", DB: db, Table: "missing", PollInterval: time.Hour}).New(1).(*EventQueue)
+	go func() {
+		time.Sleep(interval)
+		q.Stop()
+	}()
+	done := make(chan error, 1)
+	go func() {
+		_, err := q.Lease()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != event.ErrDone {
+			t.Errorf("Lease() with the missing table then Stop() => %#v; want %#v", err, event.ErrDone)
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("Lease() with the missing table hasn't returned within 3 seconds after Stop()")
+	}
+}
+
+func TestEventQueue_EnqueueAt(t *testing.T) {
+	now := time.Now()
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	q := (&EventQueue{Driver: "sqlite3", DB: db}).New(1).(*EventQueue)
+	defer q.Stop()
+	if err := q.EnqueueAt("1", "1", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.EnqueueAt("canceled", "canceled", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Cancel("canceled"); err != nil {
+		t.Errorf("Cancel(%q) => %#v; want nil", "canceled", err)
+	}
+	if err := q.Cancel("canceled"); err != event.ErrNotScheduled {
+		t.Errorf("Cancel(%q) twice => %#v; want %#v", "canceled", err, event.ErrNotScheduled)
+	}
+	if _, err := q.s.claim(); err != sql.ErrNoRows {
+		t.Errorf("claim() before due => %#v; want %#v", err, sql.ErrNoRows)
+	}
+	now = now.Add(time.Hour)
+	l, err := q.s.claim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual interface{} = l.data
+	var expected interface{} = "1"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("claim() after due => %#v; want %#v", actual, expected)
+	}
+}
+
+func TestEventQueue_EnqueueAt_sharedTable(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	longName := strings.Repeat("q", 64)
+	q1 := (&EventQueue{Driver: "sqlite3", DB: db}).New(1).(*EventQueue)
+	defer q1.Stop()
+	q2 := (&EventQueue{Driver: "sqlite3", DB: db, Name: longName}).New(1).(*EventQueue)
+	defer q2.Stop()
+	due := time.Now().Add(time.Hour)
+	for _, q := range []*EventQueue{q1, q2} {
+		if err := q.EnqueueAt("1", "1", due); err != nil {
+			t.Fatalf("EnqueueAt(%q, %q, %v) to the queue %q => %#v; want nil", "1", "1", due, q.s.name, err)
+		}
+	}
+	if err := q2.Cancel("1"); err != nil {
+		t.Errorf("Cancel(%q) of the queue %q => %#v; want nil", "1", longName, err)
+	}
+	var actual []string
+	rows, err := db.Query(`SELECT queue, id FROM ` + DefaultTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var queue, id string
+		if err := rows.Scan(&queue, &id); err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, queue+":"+id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{DefaultName + ":1"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("rows after Cancel(%q) of the queue %q => %#v; want %#v", "1", longName, actual, expected)
+	}
+}
+
+func TestEventQueue_EnqueueUnique(t *testing.T) {
+	now := time.Now()
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	q := (&EventQueue{Driver: "sqlite3", DB: db, VisibilityTimeout: time.Hour}).New(1).(*EventQueue)
+	defer q.Stop()
+	for _, v := range []struct {
+		unique event.Unique
+		data   string
+		expect error
+	}{
+		{event.Unique{Key: "a", Window: time.Minute}, "a1", nil},
+		{event.Unique{Key: "a"}, "a2", event.ErrDuplicate},
+		{event.Unique{Key: "a", Policy: event.UniqueReplace, Window: time.Minute}, "a3", nil},
+		{event.Unique{Key: "b"}, "b1", nil},
+	} {
+		if err := q.EnqueueUnique(v.unique, v.data); err != v.expect {
+			t.Errorf("EnqueueUnique(%#v, %q) => %#v; want %#v", v.unique, v.data, err, v.expect)
+		}
+		now = now.Add(time.Millisecond)
+	}
+	l, err := q.s.claim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual interface{} = l.data
+	var expected interface{} = "a3"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("claim() => %#v; want %#v", actual, expected)
+	}
+	for _, v := range []struct {
+		elapsed time.Duration
+		unique  event.Unique
+		expect  error
+	}{
+		{30 * time.Second, event.Unique{Key: "a", Policy: event.UniqueExtend, Window: time.Minute}, event.ErrDuplicate},
+		{45 * time.Second, event.Unique{Key: "a"}, event.ErrDuplicate},
+		{30 * time.Second, event.Unique{Key: "a"}, nil},
+		{0, event.Unique{Key: "a"}, event.ErrDuplicate},
+	} {
+		now = now.Add(v.elapsed)
+		if err := q.EnqueueUnique(v.unique, "a4"); err != v.expect {
+			t.Errorf("EnqueueUnique(%#v, %q) after %v => %#v; want %#v", v.unique, "a4", v.elapsed, err, v.expect)
+		}
+	}
+}
+
+func TestEventQueue_EnqueueUnique_concurrent(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	q := (&EventQueue{Driver: "sqlite3", DB: db}).New(1).(*EventQueue)
+	defer q.Stop()
+	const n = 10
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- q.EnqueueUnique(event.Unique{Key: "a"}, "a")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	var enqueued, duplicated int
+	for err := range errs {
+		switch err {
+		case nil:
+			enqueued++
+		case event.ErrDuplicate:
+			duplicated++
+		default:
+			t.Errorf("EnqueueUnique(%#v, %q) => %#v; want nil or %#v", event.Unique{Key: "a"}, "a", err, event.ErrDuplicate)
+		}
+	}
+	var actual interface{} = []int{enqueued, duplicated}
+	var expected interface{} = []int{1, n - 1}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("concurrent EnqueueUnique => %v enqueued and %v duplicated; want %v enqueued and %v duplicated", enqueued, duplicated, 1, n-1)
+	}
+	actual, err := q.Depth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = 1
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Depth() => %#v; want %#v", actual, expected)
+	}
+
+	// enqueued by another process at the same time.
+	if _, err := db.Exec(`INSERT INTO `+DefaultTableName+` (id, queue, data, enqueued_at, visible_at, unique_key) VALUES ('other', ?, 'a', 0, 0, ?)`, DefaultName, "a"); err == nil {
+		t.Errorf("INSERT of the data of the existing key => nil; want error")
+	}
+}
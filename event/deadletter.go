@@ -0,0 +1,31 @@
+package event
+
+import "time"
+
+// DeadLetter represents the payload that the handler has failed after all
+// attempts.
+type DeadLetter struct {
+	ID       string        // identifier that is assigned by DeadLetterQueue.
+	Name     string        // event name.
+	Queue    string        // queue name.
+	Handler  int           // index of the failed handler in the queue.
+	Args     []interface{} // arguments of the event.
+	Meta     Meta          // metadata of the event.
+	Attempts int           // number of attempts.
+	Err      string        // message of the last error.
+	Time     time.Time     // time of the last failure.
+}
+
+// DeadLetterQueue is the interface that stores the dead letters so that they
+// can be inspected and be enqueued again by Event.Requeue.
+type DeadLetterQueue interface {
+	// Add adds the letter to the queue.
+	// Add must set the unique ID to letter.ID.
+	Add(letter *DeadLetter) error
+
+	// List returns the letters in the order added.
+	List() ([]*DeadLetter, error)
+
+	// Remove removes the letter of id from the queue.
+	Remove(id string) error
+}
@@ -0,0 +1,215 @@
+package disk
+
+import (
+	"sync"
+	"time"
+
+	"github.com/naoina/kocha/event"
+)
+
+// DefaultSegmentSize is the default size of a segment file.
+const DefaultSegmentSize = 16 * 1024 * 1024
+
+// EventQueue implements the AckQueue, DelayQueue, DepthQueue and UniqueQueue
+// interfaces with the files on the local disk.
+// This doesn't require the external storages such as Redis as well as
+// memory.EventQueue, but queued data will survive the restart and the crash
+// of the process.
+//
+// The data will be appended to the segment file in Dir. When the segment file
+// exceeds SegmentSize, the data that has not been dequeued yet will be copied
+// to a new segment file, and the old segment files will be removed. At the
+// start, the data that has not been dequeued will be restored from the
+// segment files. The leased data that hasn't been acknowledged will also be
+// restored.
+// Note that Dir must not be shared between the processes.
+type EventQueue struct {
+	// Dir is the directory to store the segment files.
+	Dir string
+
+	// SegmentSize is the size of a segment file in bytes to be compacted.
+	// If SegmentSize is 0, DefaultSegmentSize will be used.
+	SegmentSize int64
+
+	// SyncInterval is the interval to sync the segment file to the disk.
+	// If SyncInterval is 0, the file will be synced on every Enqueue and
+	// Dequeue. If SyncInterval is negative, the file won't be synced
+	// explicitly, so it is fast but the data may be lost by the crash of the
+	// OS.
+	SyncInterval time.Duration
+
+	s    *store
+	err  error
+	done chan struct{}
+	once sync.Once
+	mu   sync.Mutex
+}
+
+// New returns a new EventQueue that shares the segment files with q.
+// The segment files will be opened at the first call of New.
+func (q *EventQueue) New(n int) event.Queue {
+	s, err := q.store()
+	if err == nil {
+		s.acquire()
+	}
+	return &EventQueue{
+		Dir:          q.Dir,
+		SegmentSize:  q.SegmentSize,
+		SyncInterval: q.SyncInterval,
+		s:            s,
+		err:          err,
+		done:         make(chan struct{}),
+	}
+}
+
+// Enqueue adds data to the queue.
+func (q *EventQueue) Enqueue(data string) error {
+	s, err := q.store()
+	if err != nil {
+		return err
+	}
+	return s.enqueue("", data, 0)
+}
+
+// EnqueueUnique adds data to the queue unless the data of u.Key exists.
+// The unique key is also stored in the segment files, so the uniqueness is
+// kept after the restart.
+func (q *EventQueue) EnqueueUnique(u event.Unique, data string) error {
+	s, err := q.store()
+	if err != nil {
+		return err
+	}
+	return s.enqueueUnique(u, data)
+}
+
+// EnqueueAt adds data to the queue that will be delivered at t.
+// The scheduled data is also stored in the segment files.
+func (q *EventQueue) EnqueueAt(id string, data string, t time.Time) error {
+	s, err := q.store()
+	if err != nil {
+		return err
+	}
+	return s.enqueue(id, data, t.UnixNano())
+}
+
+// Cancel removes the scheduled data of id that hasn't been delivered yet.
+func (q *EventQueue) Cancel(id string) error {
+	s, err := q.store()
+	if err != nil {
+		return err
+	}
+	return s.cancel(id)
+}
+
+// Dequeue returns the data that fetch from the queue.
+// If the segment files couldn't be opened, Dequeue returns the error at once,
+// and then it waits for Stop.
+func (q *EventQueue) Dequeue() (data string, err error) {
+	if q.s == nil {
+		if err, q.err = q.err, nil; err != nil {
+			return "", err
+		}
+		<-q.done
+		return "", event.ErrDone
+	}
+	return q.s.dequeue(q.done)
+}
+
+// Lease returns the lease of the data that fetch from the queue.
+// The nacked data will be delivered again before the newer data.
+func (q *EventQueue) Lease() (event.Lease, error) {
+	if q.s == nil {
+		_, err := q.Dequeue()
+		return nil, err
+	}
+	e, err := q.s.lease(q.done)
+	if err != nil {
+		return nil, err
+	}
+	return &lease{s: q.s, e: e}, nil
+}
+
+// Stop stops the queue.
+// The segment files will be closed when all queues returned by New have been
+// stopped.
+func (q *EventQueue) Stop() {
+	q.once.Do(func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if q.done == nil { // q isn't returned by New.
+			if q.s != nil {
+				q.s.close()
+			}
+			return
+		}
+		close(q.done)
+		if q.s != nil {
+			q.s.release()
+		}
+	})
+}
+
+// Compact copies the data that has not been dequeued yet to a new segment
+// file, and removes the old segment files.
+func (q *EventQueue) Compact() error {
+	s, err := q.store()
+	if err != nil {
+		return err
+	}
+	return s.compact()
+}
+
+// Depth returns the number of the data that is waiting to be leased.
+// The scheduled data that the due time hasn't come isn't included.
+func (q *EventQueue) Depth() (int, error) {
+	s, err := q.store()
+	if err != nil {
+		return 0, err
+	}
+	return s.depth(), nil
+}
+
+// store returns the store of q. It opens the store if it hasn't been opened or
+// it has been closed.
+func (q *EventQueue) store() (*store, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.s != nil && !q.s.isClosed() {
+		return q.s, nil
+	}
+	size := q.SegmentSize
+	if size < 1 {
+		size = DefaultSegmentSize
+	}
+	s, err := openStore(q.Dir, size, q.SyncInterval)
+	if err != nil {
+		return nil, err
+	}
+	q.s = s
+	return s, nil
+}
+
+// lease implements the event.Lease interface.
+type lease struct {
+	s    *store
+	e    entry
+	once sync.Once
+}
+
+func (l *lease) Data() string {
+	return l.e.data
+}
+
+func (l *lease) Ack() (err error) {
+	l.once.Do(func() {
+		err = l.s.ack(l.e.seq)
+	})
+	return err
+}
+
+func (l *lease) Nack() error {
+	l.once.Do(func() {
+		l.s.nack(l.e.seq)
+	})
+	return nil
+}
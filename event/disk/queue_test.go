@@ -0,0 +1,298 @@
+package disk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/naoina/kocha/event"
+	"github.com/naoina/kocha/util"
+)
+
+func TestEventQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestEventQueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	e := event.New()
+	if err := e.RegisterQueue("disk", &EventQueue{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	e.SetWorkersPerQueue(2)
+	e.Start()
+	defer e.Stop()
+
+	handlerName := "testEventQueueHandler"
+	called := make(chan interface{})
+	if err := e.AddHandler(handlerName, "disk", func(args ...interface{}) error {
+		called <- args[0]
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Trigger(handlerName, "arg"); err != nil {
+		t.Errorf("event.Trigger(%q) => %#v, want nil", handlerName, err)
+	}
+	select {
+	case actual := <-called:
+		expected := "arg"
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("handler called with %#v; want %#v", actual, expected)
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("event.Trigger(%q) has try to call handler but hasn't been called within 3 seconds", handlerName)
+	}
+}
+
+func TestEventQueue_restart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestEventQueue_restart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	q := (&EventQueue{Dir: dir, SegmentSize: 64, SyncInterval: -1}).New(1)
+	for _, data := range []string{"1", "2", "3", "4", "5"} {
+		if err := q.Enqueue(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, expected := range []string{"1", "2"} {
+		actual, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("EventQueue.Dequeue() => %#v; want %#v", actual, expected)
+		}
+	}
+	q.Stop()
+
+	// simulate a torn record by a crash.
+	paths, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual interface{} = len(paths)
+	var expected interface{} = 1
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("number of segment files => %#v; want %#v", actual, expected)
+	}
+	f, err := os.OpenFile(paths[0], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("torn")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	q = (&EventQueue{Dir: dir}).New(1)
+	defer q.Stop()
+	if err := q.Enqueue("6"); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"3", "4", "5", "6"} {
+		actual, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("EventQueue.Dequeue() after restart => %#v; want %#v", actual, expected)
+		}
+	}
+	if err := q.(*EventQueue).Compact(); err != nil {
+		t.Fatal(err)
+	}
+	q.Stop()
+	q = (&EventQueue{Dir: dir}).New(1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		actual, err := q.Dequeue()
+		if err != event.ErrDone {
+			t.Errorf("EventQueue.Dequeue() after Compact => (%#v, %#v); want (%#v, %#v)", actual, err, "", event.ErrDone)
+		}
+	}()
+	q.Stop()
+	<-done
+}
+
+func TestEventQueue_Lease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestEventQueue_Lease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	q := (&EventQueue{Dir: dir}).New(1).(*EventQueue)
+	for _, data := range []string{"1", "2", "3"} {
+		if err := q.Enqueue(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lease := func() event.Lease {
+		l, err := q.Lease()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	l1 := lease()
+	l2 := lease()
+	if err := l1.Nack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l2.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	l1 = lease()
+	var actual interface{} = l1.Data()
+	var expected interface{} = "1"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Lease().Data() after Nack => %#v; want %#v", actual, expected)
+	}
+	if err := l1.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	actual = lease().Data()
+	expected = "3"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Lease().Data() => %#v; want %#v", actual, expected)
+	}
+	// simulate a crash before Ack.
+	q.s.close()
+
+	q = (&EventQueue{Dir: dir}).New(1).(*EventQueue)
+	defer q.Stop()
+	actual, err = q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Dequeue() after restart => %#v; want %#v", actual, expected)
+	}
+}
+
+func TestEventQueue_EnqueueAt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestEventQueue_EnqueueAt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Now()
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	q := (&EventQueue{Dir: dir}).New(1).(*EventQueue)
+	for _, v := range []struct {
+		id    string
+		after time.Duration
+	}{
+		{"2", 2 * time.Hour},
+		{"canceled", time.Hour},
+		{"1", time.Hour},
+	} {
+		if err := q.EnqueueAt(v.id, v.id, now.Add(v.after)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Enqueue("0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Cancel("canceled"); err != nil {
+		t.Errorf("Cancel(%q) => %#v; want nil", "canceled", err)
+	}
+	if err := q.Cancel("unknown"); err != event.ErrNotScheduled {
+		t.Errorf("Cancel(%q) => %#v; want %#v", "unknown", err, event.ErrNotScheduled)
+	}
+	q.Stop()
+
+	q = (&EventQueue{Dir: dir}).New(1).(*EventQueue)
+	defer q.Stop()
+	for _, v := range []struct {
+		elapsed time.Duration
+		expect  string
+	}{
+		{0, "0"},
+		{time.Hour, "1"},
+		{time.Hour, "2"},
+	} {
+		now = now.Add(v.elapsed)
+		actual, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, v.expect) {
+			t.Errorf("Dequeue() after %v => %#v; want %#v", v.elapsed, actual, v.expect)
+		}
+	}
+}
+
+func TestEventQueue_EnqueueUnique(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestEventQueue_EnqueueUnique")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	q := (&EventQueue{Dir: dir}).New(1).(*EventQueue)
+	for _, v := range []struct {
+		unique event.Unique
+		data   string
+		expect error
+	}{
+		{event.Unique{Key: "a"}, "a1", nil},
+		{event.Unique{Key: "a"}, "a2", event.ErrDuplicate},
+		{event.Unique{Key: "a", Policy: event.UniqueReplace}, "a3", nil},
+		{event.Unique{Key: "b"}, "b1", nil},
+	} {
+		if err := q.EnqueueUnique(v.unique, v.data); err != v.expect {
+			t.Errorf("EnqueueUnique(%#v, %q) => %#v; want %#v", v.unique, v.data, err, v.expect)
+		}
+	}
+	q.Stop()
+
+	q = (&EventQueue{Dir: dir}).New(1).(*EventQueue)
+	defer q.Stop()
+	if err := q.EnqueueUnique(event.Unique{Key: "a"}, "a4"); err != event.ErrDuplicate {
+		t.Errorf("EnqueueUnique after restart => %#v; want %#v", err, event.ErrDuplicate)
+	}
+	l, err := q.Lease()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual interface{} = l.Data()
+	var expected interface{} = "a3"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Lease().Data() after restart => %#v; want %#v", actual, expected)
+	}
+	if err := q.EnqueueUnique(event.Unique{Key: "a"}, "a4"); err != event.ErrDuplicate {
+		t.Errorf("EnqueueUnique while running => %#v; want %#v", err, event.ErrDuplicate)
+	}
+	if err := l.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.EnqueueUnique(event.Unique{Key: "a"}, "a4"); err != nil {
+		t.Errorf("EnqueueUnique after Ack => %#v; want nil", err)
+	}
+}
+
+func Test_syncDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "Test_syncDir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := syncDir(dir); err != nil {
+		t.Errorf("syncDir(%q) => %#v; want nil", dir, err)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	missing := filepath.Join(dir, "missing")
+	if err := syncDir(missing); err == nil {
+		t.Errorf("syncDir(%q) => nil; want error", missing)
+	}
+}
@@ -0,0 +1,728 @@
+package disk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/naoina/kocha/event"
+	"github.com/naoina/kocha/util"
+)
+
+// The types of the records in the segment files.
+const (
+	recordData    byte = iota + 1 // enqueued data.
+	recordAck                     // acknowledgement of the data of the sequence.
+	recordDelayed                 // enqueued data with the ID and the due time.
+	recordUnique                  // enqueued data with the unique key.
+)
+
+// recordHeaderSize is the size of the record header.
+// The header consists of CRC-32 (4 bytes), length of data (4 bytes), type (1
+// byte) and sequence number (8 bytes). CRC-32 is calculated over the rest of
+// the record.
+const recordHeaderSize = 4 + 4 + 1 + 8
+
+// segmentExt is the extension of the segment files.
+const segmentExt = ".log"
+
+var errClosed = errors.New("kocha: event: disk: queue is closed")
+
+// entry is an unacknowledged data in the queue.
+type entry struct {
+	seq  uint64
+	data string
+	id   string // ID of the scheduled data.
+	due  int64  // due time of the scheduled data in Unix nanoseconds.
+
+	key   string // key of the unique data.
+	until int64  // end of the window of the unique data in Unix nanoseconds.
+}
+
+// store is the append-only segment log that is shared by the EventQueues.
+type store struct {
+	dir          string
+	segmentSize  int64
+	syncInterval time.Duration
+
+	file      *os.File
+	segment   uint64 // number of the active segment.
+	size      int64  // size of the active segment after the compaction.
+	seq       uint64 // last sequence number.
+	pending   []entry
+	scheduled []entry           // scheduled data in order of the due time.
+	inflight  map[uint64]entry  // leased data.
+	unique    map[string]uint64 // sequence numbers of the unique data by key.
+	signal    chan struct{}     // closed when the data is enqueued.
+	dirty     bool
+	refs      int
+	closed    bool
+	buf       bytes.Buffer
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+}
+
+func openStore(dir string, segmentSize int64, syncInterval time.Duration) (*store, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("kocha: event: disk: Dir must be specified")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &store{
+		dir:          dir,
+		segmentSize:  segmentSize,
+		syncInterval: syncInterval,
+		inflight:     make(map[uint64]entry),
+		unique:       make(map[string]uint64),
+		signal:       make(chan struct{}),
+		stop:         make(chan struct{}),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.rewrite(); err != nil {
+		return nil, err
+	}
+	if s.syncInterval > 0 {
+		s.wg.Add(1)
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+// load reads the segment files and restores the unacknowledged data.
+func (s *store) load() error {
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+	unacked := make(map[uint64]entry)
+	for _, segment := range segments {
+		if err := readSegment(s.segmentPath(segment), func(typ byte, seq uint64, data string) {
+			switch typ {
+			case recordData:
+				unacked[seq] = entry{seq: seq, data: data}
+			case recordDelayed:
+				if e, ok := decodeDelayed(data); ok {
+					e.seq = seq
+					unacked[seq] = e
+				}
+			case recordUnique:
+				if e, ok := decodeUnique(data); ok {
+					e.seq = seq
+					unacked[seq] = e
+				}
+			case recordAck:
+				delete(unacked, seq)
+			}
+			if seq > s.seq {
+				s.seq = seq
+			}
+		}); err != nil {
+			return err
+		}
+		s.segment = segment
+	}
+	now := util.Now().UnixNano()
+	for _, e := range unacked {
+		if e.key != "" {
+			s.unique[e.key] = e.seq
+		}
+		if e.due > now {
+			s.scheduled = append(s.scheduled, e)
+		} else {
+			s.pending = append(s.pending, e)
+		}
+	}
+	sort.Sort(entries(s.pending))
+	sort.Sort(entriesByDue(s.scheduled))
+	return nil
+}
+
+// rewrite writes the unacknowledged data to a new segment, and removes the old
+// segments.
+// It must be called with the lock except at the opening.
+func (s *store) rewrite() error {
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+	next := s.segment + 1
+	f, err := os.OpenFile(s.segmentPath(next), os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	unacked := make([]entry, 0, len(s.pending)+len(s.scheduled)+len(s.inflight))
+	unacked = append(unacked, s.pending...)
+	unacked = append(unacked, s.scheduled...)
+	for _, e := range s.inflight {
+		unacked = append(unacked, e)
+	}
+	sort.Sort(entries(unacked))
+	s.buf.Reset()
+	for _, e := range unacked {
+		writeEntry(&s.buf, e)
+	}
+	if _, err := f.Write(s.buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	// the entry of the new segment must be persisted before the old segments
+	// are removed, otherwise the data may be lost by crash.
+	if err := syncDir(s.dir); err != nil {
+		f.Close()
+		return err
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file, s.segment, s.size, s.dirty = f, next, 0, false
+	for _, segment := range segments {
+		if err := os.Remove(s.segmentPath(segment)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// segments returns the numbers of the segment files in increasing order.
+func (s *store) segments() ([]uint64, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+	var segments []uint64
+	for _, path := range paths {
+		n, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, n)
+	}
+	sort.Sort(uint64s(segments))
+	return segments, nil
+}
+
+func (s *store) segmentPath(segment uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", segment, segmentExt))
+}
+
+// syncDir commits the entries of the directory such as the created files to
+// the disk. It does nothing on Windows that can't sync the directory.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// append appends the record of the entry to the active segment.
+// It must be called with the lock.
+func (s *store) append(e entry) error {
+	s.buf.Reset()
+	writeEntry(&s.buf, e)
+	return s.flush()
+}
+
+// appendAck appends the acknowledgement record of seq to the active segment.
+// It must be called with the lock.
+func (s *store) appendAck(seq uint64) error {
+	s.buf.Reset()
+	writeRecord(&s.buf, recordAck, seq, "")
+	return s.flush()
+}
+
+// flush writes the buffer to the active segment.
+// It must be called with the lock.
+func (s *store) flush() error {
+	n, err := s.file.Write(s.buf.Bytes())
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if s.syncInterval == 0 {
+		return s.file.Sync()
+	}
+	s.dirty = true
+	return nil
+}
+
+// enqueue adds data to the queue.
+// If due isn't 0, the data will be delivered at due.
+func (s *store) enqueue(id, data string, due int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errClosed
+	}
+	if s.segmentSize > 0 && s.size >= s.segmentSize {
+		if err := s.rewrite(); err != nil {
+			return err
+		}
+	}
+	e := entry{seq: s.seq + 1, data: data, id: id, due: due}
+	if err := s.append(e); err != nil {
+		return err
+	}
+	s.seq = e.seq
+	if e.due > util.Now().UnixNano() {
+		i := sort.Search(len(s.scheduled), func(i int) bool { return s.scheduled[i].due > e.due })
+		s.scheduled = append(s.scheduled, entry{})
+		copy(s.scheduled[i+1:], s.scheduled[i:])
+		s.scheduled[i] = e
+	} else {
+		s.pending = append(s.pending, e)
+	}
+	s.notify()
+	return nil
+}
+
+// enqueueUnique adds data to the queue unless the data of u.Key exists.
+func (s *store) enqueueUnique(u event.Unique, data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errClosed
+	}
+	now := util.Now().UnixNano()
+	until := int64(0)
+	if u.Window > 0 {
+		until = now + int64(u.Window)
+	}
+	if seq, exists := s.unique[u.Key]; exists {
+		if e, running := s.inflight[seq]; running {
+			if e.until == 0 || now < e.until {
+				switch u.Policy {
+				case event.UniqueSkip:
+					return event.ErrDuplicate
+				case event.UniqueExtend:
+					e.until = until
+					if err := s.append(e); err != nil {
+						return err
+					}
+					s.inflight[seq] = e
+					return event.ErrDuplicate
+				}
+				// the running data can't be replaced, so the new data will be
+				// enqueued.
+			}
+		} else if i := s.pendingIndex(seq); i >= 0 {
+			e := s.pending[i]
+			switch u.Policy {
+			case event.UniqueSkip:
+				return event.ErrDuplicate
+			case event.UniqueReplace:
+				e.data, e.until = data, until
+			case event.UniqueExtend:
+				e.until = until
+			}
+			if err := s.append(e); err != nil {
+				return err
+			}
+			s.pending[i] = e
+			if u.Policy == event.UniqueReplace {
+				return nil
+			}
+			return event.ErrDuplicate
+		}
+	}
+	if s.segmentSize > 0 && s.size >= s.segmentSize {
+		if err := s.rewrite(); err != nil {
+			return err
+		}
+	}
+	e := entry{seq: s.seq + 1, data: data, key: u.Key, until: until}
+	if err := s.append(e); err != nil {
+		return err
+	}
+	s.seq = e.seq
+	s.pending = append(s.pending, e)
+	s.unique[e.key] = e.seq
+	s.notify()
+	return nil
+}
+
+// cancel removes the scheduled data of id that hasn't been leased yet.
+func (s *store) cancel(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errClosed
+	}
+	for _, es := range []*[]entry{&s.scheduled, &s.pending} {
+		for i, e := range *es {
+			if e.id != id {
+				continue
+			}
+			if err := s.appendAck(e.seq); err != nil {
+				return err
+			}
+			*es = append((*es)[:i], (*es)[i+1:]...)
+			return nil
+		}
+	}
+	return event.ErrNotScheduled
+}
+
+// promote moves the scheduled data that the due time has come to the pending
+// data. It returns the duration until the next due time, or -1 if there is
+// no scheduled data.
+// It must be called with the lock.
+func (s *store) promote() time.Duration {
+	now := util.Now().UnixNano()
+	for len(s.scheduled) > 0 && s.scheduled[0].due <= now {
+		s.insertPending(s.scheduled[0])
+		s.scheduled = s.scheduled[1:]
+	}
+	if len(s.scheduled) == 0 {
+		return -1
+	}
+	return time.Duration(s.scheduled[0].due - now)
+}
+
+// notify wakes up the waiters of the data.
+// It must be called with the lock.
+func (s *store) notify() {
+	close(s.signal)
+	s.signal = make(chan struct{})
+}
+
+// lease returns the oldest data in the queue, and makes it in-flight until
+// ack or nack is called. The lease holds the reference of s.
+// If the queue is empty, it waits for the data to be enqueued or done to be
+// closed.
+func (s *store) lease(done <-chan struct{}) (entry, error) {
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return entry{}, event.ErrDone
+		}
+		wait := s.promote()
+		if len(s.pending) > 0 {
+			e := s.pending[0]
+			s.pending = s.pending[1:]
+			s.inflight[e.seq] = e
+			s.refs++
+			s.mu.Unlock()
+			return e, nil
+		}
+		signal := s.signal
+		s.mu.Unlock()
+		var timer *time.Timer
+		var due <-chan time.Time
+		if wait >= 0 {
+			timer = time.NewTimer(wait)
+			due = timer.C
+		}
+		select {
+		case <-signal:
+		case <-due:
+		case <-done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return entry{}, event.ErrDone
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// ack removes the in-flight data of seq from the queue.
+// If it fails, the data will be returned to the queue.
+func (s *store) ack(seq uint64) error {
+	defer s.release()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, exists := s.inflight[seq]
+	if !exists {
+		return nil
+	}
+	if err := s.appendAck(seq); err != nil {
+		s.requeue(seq)
+		return err
+	}
+	delete(s.inflight, seq)
+	if e.key != "" && s.unique[e.key] == seq {
+		delete(s.unique, e.key)
+	}
+	return nil
+}
+
+// nack returns the in-flight data of seq to the queue.
+func (s *store) nack(seq uint64) {
+	defer s.release()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.inflight[seq]; exists {
+		s.requeue(seq)
+	}
+}
+
+// requeue moves the in-flight data of seq to the pending data in order of
+// the sequence.
+// It must be called with the lock.
+func (s *store) requeue(seq uint64) {
+	e := s.inflight[seq]
+	delete(s.inflight, seq)
+	s.insertPending(e)
+	s.notify()
+}
+
+// pendingIndex returns the index of the pending data of seq, or -1 if not
+// found.
+// It must be called with the lock.
+func (s *store) pendingIndex(seq uint64) int {
+	i := sort.Search(len(s.pending), func(i int) bool { return s.pending[i].seq >= seq })
+	if i < len(s.pending) && s.pending[i].seq == seq {
+		return i
+	}
+	return -1
+}
+
+// insertPending inserts e to the pending data in order of the sequence.
+// It must be called with the lock.
+func (s *store) insertPending(e entry) {
+	i := sort.Search(len(s.pending), func(i int) bool { return s.pending[i].seq > e.seq })
+	s.pending = append(s.pending, entry{})
+	copy(s.pending[i+1:], s.pending[i:])
+	s.pending[i] = e
+}
+
+// dequeue returns the oldest data in the queue and removes it.
+func (s *store) dequeue(done <-chan struct{}) (string, error) {
+	e, err := s.lease(done)
+	if err != nil {
+		return "", err
+	}
+	if err := s.ack(e.seq); err != nil {
+		return "", err
+	}
+	return e.data, nil
+}
+
+// depth returns the number of the data that can be dequeued.
+func (s *store) depth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.promote()
+	return len(s.pending)
+}
+
+// compact rewrites the unacknowledged data to a new segment.
+func (s *store) compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errClosed
+	}
+	return s.rewrite()
+}
+
+// acquire increments the reference count of s.
+func (s *store) acquire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs++
+}
+
+// release decrements the reference count of s, and closes s if it reaches 0.
+func (s *store) release() error {
+	s.mu.Lock()
+	if s.refs--; s.refs > 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+	return s.close()
+}
+
+func (s *store) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *store) close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	s.mu.Unlock()
+	s.wg.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Sync(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+func (s *store) syncLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.dirty {
+				if err := s.file.Sync(); err != nil {
+					fmt.Fprintf(os.Stderr, "kocha: event: disk: failed to sync: %v\n", err)
+				}
+				s.dirty = false
+			}
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// writeEntry writes the record of the entry to buf.
+func writeEntry(buf *bytes.Buffer, e entry) {
+	if e.key != "" {
+		writeRecord(buf, recordUnique, e.seq, encodeUnique(e))
+		return
+	}
+	if e.id == "" && e.due == 0 {
+		writeRecord(buf, recordData, e.seq, e.data)
+		return
+	}
+	writeRecord(buf, recordDelayed, e.seq, encodeDelayed(e))
+}
+
+// encodeUnique encodes the entry of the unique data to the data of the
+// record. The data consists of the end of the window (8 bytes), length of the
+// key (2 bytes), the key and the data of the entry.
+func encodeUnique(e entry) string {
+	var header [8 + 2]byte
+	binary.BigEndian.PutUint64(header[0:8], uint64(e.until))
+	binary.BigEndian.PutUint16(header[8:10], uint16(len(e.key)))
+	return string(header[:]) + e.key + e.data
+}
+
+// decodeUnique decodes the data of the record that is encoded by
+// encodeUnique.
+func decodeUnique(data string) (e entry, ok bool) {
+	if len(data) < 8+2 {
+		return e, false
+	}
+	n := int(binary.BigEndian.Uint16([]byte(data[8:10])))
+	if len(data) < 8+2+n {
+		return e, false
+	}
+	e.until = int64(binary.BigEndian.Uint64([]byte(data[0:8])))
+	e.key = data[10 : 10+n]
+	e.data = data[10+n:]
+	return e, true
+}
+
+// encodeDelayed encodes the entry of the scheduled data to the data of the
+// record. The data consists of the due time (8 bytes), length of the ID (2
+// bytes), the ID and the data of the entry.
+func encodeDelayed(e entry) string {
+	var header [8 + 2]byte
+	binary.BigEndian.PutUint64(header[0:8], uint64(e.due))
+	binary.BigEndian.PutUint16(header[8:10], uint16(len(e.id)))
+	return string(header[:]) + e.id + e.data
+}
+
+// decodeDelayed decodes the data of the record that is encoded by
+// encodeDelayed.
+func decodeDelayed(data string) (e entry, ok bool) {
+	if len(data) < 8+2 {
+		return e, false
+	}
+	n := int(binary.BigEndian.Uint16([]byte(data[8:10])))
+	if len(data) < 8+2+n {
+		return e, false
+	}
+	e.due = int64(binary.BigEndian.Uint64([]byte(data[0:8])))
+	e.id = data[10 : 10+n]
+	e.data = data[10+n:]
+	return e, true
+}
+
+// writeRecord writes the record to buf.
+func writeRecord(buf *bytes.Buffer, typ byte, seq uint64, data string) {
+	var header [recordHeaderSize]byte
+	binary.BigEndian.PutUint32(header[4:8], uint32(len(data)))
+	header[8] = typ
+	binary.BigEndian.PutUint64(header[9:17], seq)
+	crc := crc32.NewIEEE()
+	crc.Write(header[8:])
+	crc.Write([]byte(data))
+	binary.BigEndian.PutUint32(header[0:4], crc.Sum32())
+	buf.Write(header[:])
+	buf.WriteString(data)
+}
+
+// readSegment reads the records in the segment file of path, and calls fn for
+// each record. The torn record at the end of the file such as by a crash will
+// be ignored.
+func readSegment(path string, fn func(typ byte, seq uint64, data string)) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for len(buf) >= recordHeaderSize {
+		size := int(binary.BigEndian.Uint32(buf[4:8]))
+		if size < 0 || len(buf)-recordHeaderSize < size {
+			break
+		}
+		record := buf[8 : recordHeaderSize+size]
+		if crc32.ChecksumIEEE(record) != binary.BigEndian.Uint32(buf[0:4]) {
+			break
+		}
+		fn(buf[8], binary.BigEndian.Uint64(buf[9:17]), string(buf[recordHeaderSize:recordHeaderSize+size]))
+		buf = buf[recordHeaderSize+size:]
+	}
+	return nil
+}
+
+type entries []entry
+
+func (es entries) Len() int           { return len(es) }
+func (es entries) Less(i, j int) bool { return es[i].seq < es[j].seq }
+func (es entries) Swap(i, j int)      { es[i], es[j] = es[j], es[i] }
+
+type uint64s []uint64
+
+func (ns uint64s) Len() int           { return len(ns) }
+func (ns uint64s) Less(i, j int) bool { return ns[i] < ns[j] }
+func (ns uint64s) Swap(i, j int)      { ns[i], ns[j] = ns[j], ns[i] }
+
+type entriesByDue []entry
+
+func (es entriesByDue) Len() int           { return len(es) }
+func (es entriesByDue) Less(i, j int) bool { return es[i].due < es[j].due }
+func (es entriesByDue) Swap(i, j int)      { es[i], es[j] = es[j], es[i] }
@@ -3,7 +3,12 @@ package event
 import (
 	"errors"
 	"fmt"
+	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/naoina/kocha/util"
 )
 
 var (
@@ -27,6 +32,21 @@ func Trigger(name string, args ...interface{}) error {
 	return DefaultEvent.Trigger(name, args...)
 }
 
+// AddMetaHandler is shorthand of the DefaultEvent.AddMetaHandler.
+func AddMetaHandler(name string, queueName string, handler func(meta Meta, args ...interface{}) error) error {
+	return DefaultEvent.AddMetaHandler(name, queueName, handler)
+}
+
+// TriggerMeta is shorthand of the DefaultEvent.TriggerMeta.
+func TriggerMeta(name string, meta Meta, args ...interface{}) error {
+	return DefaultEvent.TriggerMeta(name, meta, args...)
+}
+
+// AddRetryHandler is shorthand of the DefaultEvent.AddRetryHandler.
+func AddRetryHandler(name string, queueName string, policy RetryPolicy, handler func(attempt int, meta Meta, args ...interface{}) error) error {
+	return DefaultEvent.AddRetryHandler(name, queueName, policy, handler)
+}
+
 // RegisterQueue is shorthand of the DefaultEvent.RegisterQueue.
 func RegisterQueue(name string, queue Queue) error {
 	return DefaultEvent.RegisterQueue(name, queue)
@@ -42,17 +62,53 @@ func Stop() {
 	DefaultEvent.Stop()
 }
 
+// Meta represents the metadata of an event such as the request ID.
+// Meta is carried in the payload along with the arguments, and it is passed
+// to the handlers added by AddMetaHandler.
+type Meta map[string]string
+
 // Event represents an Event.
 type Event struct {
 	// ErrorHandler is the error handler.
 	// If you want to use your own error handler, set ErrorHandler.
 	ErrorHandler func(err interface{})
 
+	// DeadLetterQueue is the queue to store the payloads that the handler has
+	// failed after all attempts of the RetryPolicy.
+	// If DeadLetterQueue is nil, the payloads will be discarded.
+	DeadLetterQueue DeadLetterQueue
+
+	// Codec is the codec to encode the arguments of the typed events.
+	// If Codec is nil, JSONCodec will be used. See RegisterType.
+	Codec Codec
+
+	// LatencyBuckets is the upper bounds of the buckets of the latency
+	// histograms in Metrics. If nil, DefaultLatencyBuckets will be used.
+	LatencyBuckets []time.Duration
+
+	// SlowThreshold is the threshold of the latency of the slow handlers.
+	// If SlowThreshold is greater than 0, SlowHandler will be called with the
+	// event name, the queue name and the latency when an attempt of the
+	// handler takes SlowThreshold or more.
+	SlowThreshold time.Duration
+	SlowHandler   func(name, queueName string, elapsed time.Duration)
+
 	workersPerQueue int
 	queues          map[string]Queue
-	handlerQueues   map[string]map[string][]handlerFunc
+	handlerQueues   map[string]map[string][]*eventHandler
+	types           map[string]reflect.Type // argument types of the typed events.
+	priorities      map[string]Priority     // priorities of the events.
 	workers         []*worker
 	wg              struct{ enqueue, dequeue sync.WaitGroup }
+	timers          map[string][]*time.Timer // timers of the scheduled events.
+	timersMu        sync.Mutex
+	metrics         map[string]*queueMetrics // metrics by queue name.
+	metricsMu       sync.Mutex
+	stopping        uint32        // 1 if the event is stopping.
+	done            chan struct{} // closed when the event is stopping.
+	doneMu          sync.Mutex
+	inflight        map[*inflight]struct{} // payloads in progress.
+	inflightMu      sync.Mutex
 }
 
 // New returns a new Event.
@@ -60,7 +116,7 @@ func New() *Event {
 	return &Event{
 		workersPerQueue: 1,
 		queues:          make(map[string]Queue),
-		handlerQueues:   make(map[string]map[string][]handlerFunc),
+		handlerQueues:   make(map[string]map[string][]*eventHandler),
 		wg:              struct{ enqueue, dequeue sync.WaitGroup }{},
 	}
 }
@@ -72,15 +128,33 @@ func New() *Event {
 // to that name additionally.
 // If queue of queueName still hasn't been registered, it returns error.
 func (e *Event) AddHandler(name string, queueName string, handler func(args ...interface{}) error) error {
+	return e.AddMetaHandler(name, queueName, func(meta Meta, args ...interface{}) error {
+		return handler(args...)
+	})
+}
+
+// AddMetaHandler is similar to AddHandler, but handler also receives the
+// metadata that is given by TriggerMeta.
+// If the event is emitted by Trigger, the metadata will be nil.
+func (e *Event) AddMetaHandler(name string, queueName string, handler func(meta Meta, args ...interface{}) error) error {
+	return e.AddRetryHandler(name, queueName, RetryPolicy{}, func(attempt int, meta Meta, args ...interface{}) error {
+		return handler(meta, args...)
+	})
+}
+
+// AddRetryHandler is similar to AddMetaHandler, but handler will be retried
+// according to policy when it returns an error.
+// The attempt is the number of the current attempt that starts from 1.
+func (e *Event) AddRetryHandler(name string, queueName string, policy RetryPolicy, handler func(attempt int, meta Meta, args ...interface{}) error) error {
 	queue := e.queues[queueName]
 	if queue == nil {
 		return fmt.Errorf("kocha: event: queue `%s' isn't registered", queueName)
 	}
 	if _, exist := e.handlerQueues[name]; !exist {
-		e.handlerQueues[name] = make(map[string][]handlerFunc)
+		e.handlerQueues[name] = make(map[string][]*eventHandler)
 	}
 	hq := e.handlerQueues[name]
-	hq[queueName] = append(hq[queueName], handler)
+	hq[queueName] = append(hq[queueName], &eventHandler{fn: handler, retry: policy})
 	return nil
 }
 
@@ -89,11 +163,24 @@ func (e *Event) AddHandler(name string, queueName string, handler func(args ...i
 // If Trigger called by not added name, it returns error.
 // If args are given, they will be passed to handlers added by AddHandler.
 func (e *Event) Trigger(name string, args ...interface{}) error {
+	return e.TriggerMeta(name, nil, args...)
+}
+
+// TriggerMeta is similar to Trigger, but it emits the event with meta.
+// The meta will be passed to handlers added by AddMetaHandler.
+func (e *Event) TriggerMeta(name string, meta Meta, args ...interface{}) error {
+	if e.stopped() {
+		return ErrStopped
+	}
 	hq, exist := e.handlerQueues[name]
 	if !exist {
 		return fmt.Errorf("kocha: event: handler `%s' isn't added", name)
 	}
-	e.triggerAll(hq, name, args...)
+	pld, err := e.newPayload(name, meta, args)
+	if err != nil {
+		return err
+	}
+	e.triggerAll(hq, pld)
 	return nil
 }
 
@@ -110,10 +197,34 @@ func (e *Event) RegisterQueue(name string, queue Queue) error {
 	return nil
 }
 
-func (e *Event) triggerAll(hq map[string][]handlerFunc, name string, args ...interface{}) {
+// Requeue enqueues the payload of letter to the queue again and removes letter
+// from DeadLetterQueue.
+// Only the handler that has failed will be called.
+func (e *Event) Requeue(letter *DeadLetter) error {
+	if e.stopped() {
+		return ErrStopped
+	}
+	if e.queues[letter.Queue] == nil {
+		return fmt.Errorf("kocha: event: queue `%s' isn't registered", letter.Queue)
+	}
+	pld, err := e.newPayload(letter.Name, letter.Meta, letter.Args)
+	if err != nil {
+		return err
+	}
+	pld.Handler = letter.Handler + 1
+	if err := e.enqueue(letter.Queue, pld); err != nil {
+		return err
+	}
+	if e.DeadLetterQueue == nil {
+		return nil
+	}
+	return e.DeadLetterQueue.Remove(letter.ID)
+}
+
+func (e *Event) triggerAll(hq map[string][]*eventHandler, pld payload) {
 	e.wg.enqueue.Add(len(hq))
 	for queueName := range hq {
-		queue := e.queues[queueName]
+		queueName := queueName
 		go func() {
 			defer e.wg.enqueue.Done()
 			defer func() {
@@ -123,28 +234,36 @@ func (e *Event) triggerAll(hq map[string][]handlerFunc, name string, args ...int
 					}
 				}
 			}()
-			if err := e.enqueue(queue, payload{name, args}); err != nil {
+			if err := e.enqueue(queueName, pld); err != nil {
 				panic(err)
 			}
 		}()
 	}
 }
 
-// alias.
-type handlerFunc func(args ...interface{}) error
+// eventHandler represents the event handler with its retry policy.
+type eventHandler struct {
+	fn    func(attempt int, meta Meta, args ...interface{}) error
+	retry RetryPolicy
+}
 
-func (e *Event) enqueue(queue Queue, pld payload) error {
+func (e *Event) enqueue(queueName string, pld payload) error {
 	var data string
 	if err := pld.encode(&data); err != nil {
 		return err
 	}
-	return queue.Enqueue(data)
+	if err := e.enqueueData(e.queues[queueName], pld.Name, data); err != nil {
+		return err
+	}
+	atomic.AddUint64(&e.queueMetrics(queueName).enqueued, 1)
+	return nil
 }
 
 // Start starts background event workers.
 // By default, workers per queue is 1. To set the workers per queue, use
 // SetWorkersPerQueue before Start calls.
 func (e *Event) Start() {
+	e.restart()
 	for name, queue := range e.queues {
 		for i := 0; i < e.workersPerQueue; i++ {
 			worker := e.newWorker(name, queue.New(e.workersPerQueue))
@@ -164,27 +283,23 @@ func (e *Event) SetWorkersPerQueue(n int) {
 }
 
 // Stop wait for all workers to complete.
+// The scheduled events that are held by the timers in memory will be
+// discarded. If you want to give up the handlers that don't complete in time,
+// use StopDeadline.
 func (e *Event) Stop() {
-	e.wg.enqueue.Wait()
-	defer func() {
-		e.workers = nil
-	}()
-	defer e.wg.dequeue.Wait()
-	for _, worker := range e.workers {
-		worker.stop()
-	}
+	e.StopDeadline(time.Time{})
 }
 
 type worker struct {
 	queueName string
-	queue     Queue
+	queue     AckQueue
 	e         *Event
 }
 
 func (e *Event) newWorker(queueName string, queue Queue) *worker {
 	return &worker{
 		queueName: queueName,
-		queue:     queue,
+		queue:     NewAckQueue(queue),
 		e:         e,
 	}
 }
@@ -214,46 +329,130 @@ func (w *worker) start() {
 func (w *worker) run() (err error) {
 	w.e.wg.dequeue.Add(1)
 	defer w.e.wg.dequeue.Done()
-	pld, err := w.dequeue()
+	lease, err := w.queue.Lease()
 	if err != nil {
 		return err
 	}
+	var pld payload
+	if err := pld.decode(lease.Data()); err != nil {
+		// the data will never be processed.
+		w.finish(lease, true)
+		return err
+	}
 	hq, exist := w.e.handlerQueues[pld.Name]
 	if !exist {
+		w.finish(lease, true)
 		return ErrNotExist
 	}
-	w.runAll(hq, pld)
+	if err := w.e.decodeArgs(&pld); err != nil {
+		w.finish(lease, true)
+		return err
+	}
+	w.runAll(hq, pld, lease)
 	return nil
 }
 
-func (w *worker) runAll(hq map[string][]handlerFunc, pld payload) {
+// runAll calls the handlers of the queue of w in parallel, and acknowledges
+// the lease after all handlers have completed. The lease will be nacked if
+// any retries have been interrupted by Stop.
+func (w *worker) runAll(hq map[string][]*eventHandler, pld payload, lease Lease) {
+	f := w.e.begin(w.queueName, pld, lease)
+	var wg sync.WaitGroup
+	var interrupted uint32
 	for queueName, handlers := range hq {
 		if w.queueName != queueName {
 			continue
 		}
-		w.e.wg.dequeue.Add(len(handlers))
-		for _, h := range handlers {
-			go func(handler handlerFunc) {
+		for i, h := range handlers {
+			if pld.Handler > 0 && pld.Handler != i+1 {
+				continue
+			}
+			wg.Add(1)
+			w.e.wg.dequeue.Add(1)
+			go func(index int, h *eventHandler) {
 				defer w.e.wg.dequeue.Done()
-				if err := handler(pld.Args...); err != nil {
-					if w.e.ErrorHandler != nil {
-						w.e.ErrorHandler(err)
-					}
+				defer wg.Done()
+				if !w.runHandler(index, h, pld) {
+					atomic.StoreUint32(&interrupted, 1)
 				}
-			}(h)
+			}(i, h)
 		}
 	}
+	w.e.wg.dequeue.Add(1)
+	go func() {
+		defer w.e.wg.dequeue.Done()
+		wg.Wait()
+		if w.e.end(f) {
+			w.finish(lease, atomic.LoadUint32(&interrupted) == 0)
+		}
+	}()
 }
 
-func (w *worker) dequeue() (pld payload, err error) {
-	data, err := w.queue.Dequeue()
-	if err != nil {
-		return pld, err
+// finish acknowledges the lease if ok is true, otherwise nacks it.
+func (w *worker) finish(lease Lease, ok bool) {
+	var err error
+	if ok {
+		err = lease.Ack()
+	} else {
+		err = lease.Nack()
+	}
+	if err != nil && w.e.ErrorHandler != nil {
+		w.e.ErrorHandler(err)
 	}
-	if err := pld.decode(data); err != nil {
-		return pld, err
+}
+
+// runHandler calls the handler and retries it according to its RetryPolicy.
+// If all attempts are failed, the payload will be added to DeadLetterQueue,
+// or discarded if DeadLetterQueue is nil.
+// It reports whether the payload has been done with. It returns false only if
+// the retries have been interrupted by Stop to deliver the payload again.
+func (w *worker) runHandler(index int, h *eventHandler, pld payload) bool {
+	var err error
+	metrics := w.e.queueMetrics(w.queueName)
+	maxAttempts := h.retry.maxAttempts()
+	attempt := 1
+	for ; ; attempt++ {
+		start := time.Now()
+		err = h.fn(attempt, pld.Meta, pld.Args...)
+		w.e.observe(w.queueName, pld.Name, time.Since(start))
+		if err == nil {
+			atomic.AddUint64(&metrics.processed, 1)
+			return true
+		}
+		if attempt >= maxAttempts {
+			break
+		}
+		timer := time.NewTimer(h.retry.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-w.e.Done():
+			// the payload will be delivered again after restart.
+			timer.Stop()
+			return false
+		}
+	}
+	atomic.AddUint64(&metrics.failed, 1)
+	if w.e.ErrorHandler != nil {
+		w.e.ErrorHandler(err)
+	}
+	if w.e.DeadLetterQueue == nil {
+		return true
+	}
+	if err := w.e.DeadLetterQueue.Add(&DeadLetter{
+		Name:     pld.Name,
+		Queue:    w.queueName,
+		Handler:  index,
+		Args:     pld.Args,
+		Meta:     pld.Meta,
+		Attempts: attempt,
+		Err:      err.Error(),
+		Time:     util.Now(),
+	}); err != nil {
+		if w.e.ErrorHandler != nil {
+			w.e.ErrorHandler(err)
+		}
 	}
-	return pld, nil
+	return true
 }
 
 func (w *worker) stop() {
@@ -272,6 +471,8 @@ type Queue interface {
 
 	// Dequeue returns the data that fetch from the queue.
 	// It will return ErrDone as err when Stop is called.
+	// The data is considered to have been processed when it is dequeued. If
+	// the queue supports the acknowledgement, implement AckQueue.
 	Dequeue() (data string, err error)
 
 	// Stop wait for Enqueue and/or Dequeue to complete then will stop a queue.
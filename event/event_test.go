@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/naoina/kocha/event"
+	"github.com/naoina/kocha/event/memory"
 )
 
 const (
@@ -255,3 +257,616 @@ func TestEvent_ErrorHandler(t *testing.T) {
 		t.Errorf("ErrorHandler hasn't been called within 3 seconds")
 	}
 }
+
+func TestEvent_TriggerMeta(t *testing.T) {
+	e := event.New()
+	e.RegisterQueue(queueName, &fakeQueue{c: make(chan string), done: make(chan struct{})})
+	e.Start()
+	defer e.Stop()
+
+	handlerName := "testTriggerMeta"
+	var actual string
+	timer := make(chan struct{})
+	if err := e.AddMetaHandler(handlerName, queueName, func(meta event.Meta, args ...interface{}) error {
+		defer func() {
+			timer <- struct{}{}
+		}()
+		actual = fmt.Sprintf("call %s(%v) with %v", handlerName, args, meta)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		meta   event.Meta
+		expect string
+	}{
+		{nil, "call testTriggerMeta([arg]) with map[]"},
+		{event.Meta{"request_id": "abc"}, "call testTriggerMeta([arg]) with map[request_id:abc]"},
+	} {
+		if err := e.TriggerMeta(handlerName, v.meta, "arg"); err != nil {
+			t.Errorf("TriggerMeta(%q, %#v, %q) => %#v, want nil", handlerName, v.meta, "arg", err)
+		}
+		select {
+		case <-timer:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("TriggerMeta(%q, %#v, %q) has try to call handler but hasn't been called within 3 seconds", handlerName, v.meta, "arg")
+		}
+		if !reflect.DeepEqual(actual, v.expect) {
+			t.Errorf("TriggerMeta(%q, %#v, %q) has try to call handler, actual => %#v, want %#v", handlerName, v.meta, "arg", actual, v.expect)
+		}
+	}
+}
+
+func TestEvent_AddRetryHandler(t *testing.T) {
+	e := event.New()
+	e.RegisterQueue(queueName, &fakeQueue{c: make(chan string), done: make(chan struct{})})
+	dlq := &memory.DeadLetterQueue{}
+	e.DeadLetterQueue = dlq
+	e.Start()
+	defer e.Stop()
+
+	handlerName := "testAddRetryHandler"
+	attempts := make(chan int)
+	succeeded := make(chan struct{}, 2)
+	if err := e.AddHandler(handlerName, queueName, func(args ...interface{}) error {
+		succeeded <- struct{}{}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddRetryHandler(handlerName, queueName, event.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     1 * time.Millisecond,
+		Jitter:      0.5,
+	}, func(attempt int, meta event.Meta, args ...interface{}) error {
+		attempts <- attempt
+		return fmt.Errorf("attempt %d failed", attempt)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan interface{})
+	e.ErrorHandler = func(err interface{}) {
+		errCh <- err
+	}
+	if err := e.TriggerMeta(handlerName, event.Meta{"request_id": "abc"}, "arg"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		select {
+		case actual := <-attempts:
+			if !reflect.DeepEqual(actual, i) {
+				t.Errorf("attempt => %#v; want %#v", actual, i)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("handler hasn't been retried within 3 seconds")
+		}
+	}
+	select {
+	case err := <-errCh:
+		var actual interface{} = err
+		var expected interface{} = fmt.Errorf("attempt 3 failed")
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("ErrorHandler called with %#v; want %#v", actual, expected)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("ErrorHandler hasn't been called within 3 seconds")
+	}
+	e.Stop()
+	letters, err := dlq.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("len(DeadLetterQueue.List()) => %#v; want %#v", len(letters), 1)
+	}
+	letters[0].Time = time.Time{}
+	var actual interface{} = letters[0]
+	var expected interface{} = &event.DeadLetter{
+		ID:       "1",
+		Name:     handlerName,
+		Queue:    queueName,
+		Handler:  1,
+		Args:     []interface{}{"arg"},
+		Meta:     event.Meta{"request_id": "abc"},
+		Attempts: 3,
+		Err:      "attempt 3 failed",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("DeadLetterQueue.List()[0] => %#v; want %#v", actual, expected)
+	}
+	<-succeeded
+
+	e = event.New()
+	e.RegisterQueue(queueName, &fakeQueue{c: make(chan string), done: make(chan struct{})})
+	e.DeadLetterQueue = dlq
+	e.AddHandler(handlerName, queueName, func(args ...interface{}) error {
+		succeeded <- struct{}{}
+		return nil
+	})
+	e.AddRetryHandler(handlerName, queueName, event.RetryPolicy{}, func(attempt int, meta event.Meta, args ...interface{}) error {
+		attempts <- attempt
+		return nil
+	})
+	e.Start()
+	defer e.Stop()
+	if err := e.Requeue(letters[0]); err != nil {
+		t.Errorf("Requeue(%#v) => %#v; want nil", letters[0], err)
+	}
+	select {
+	case <-attempts:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Requeue(%#v) has try to call the failed handler but hasn't been called within 3 seconds", letters[0])
+	}
+	select {
+	case <-succeeded:
+		t.Errorf("Requeue(%#v) has called the succeeded handler; want not to be called", letters[0])
+	default:
+	}
+	letters, err = dlq.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 0 {
+		t.Errorf("len(DeadLetterQueue.List()) after Requeue => %#v; want %#v", len(letters), 0)
+	}
+}
+
+func TestEvent_AddRetryHandler_withoutDeadLetterQueue(t *testing.T) {
+	e := event.New()
+	q := &memory.EventQueue{}
+	q.New(1)
+	e.RegisterQueue("memory", q)
+	handlerName := "testAddRetryHandlerWithoutDLQ"
+	var succeeded, attempts uint32
+	if err := e.AddHandler(handlerName, "memory", func(args ...interface{}) error {
+		atomic.AddUint32(&succeeded, 1)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddRetryHandler(handlerName, "memory", event.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     1 * time.Millisecond,
+	}, func(attempt int, meta event.Meta, args ...interface{}) error {
+		atomic.AddUint32(&attempts, 1)
+		return fmt.Errorf("attempt %d failed", attempt)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan interface{}, 1)
+	e.ErrorHandler = func(err interface{}) {
+		select {
+		case errCh <- err:
+		default:
+		}
+	}
+	e.Start()
+	defer e.Stop()
+	if err := e.Trigger(handlerName); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-errCh:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("ErrorHandler hasn't been called within 3 seconds")
+	}
+	// wait for the redelivery if the payload has been nacked.
+	time.Sleep(100 * time.Millisecond)
+	for _, v := range []struct {
+		name   string
+		actual int
+		expect int
+	}{
+		{"failed handler", int(atomic.LoadUint32(&attempts)), 3},
+		{"succeeded handler", int(atomic.LoadUint32(&succeeded)), 1},
+	} {
+		if !reflect.DeepEqual(v.actual, v.expect) {
+			t.Errorf("%s has been called %#v times; want %#v times", v.name, v.actual, v.expect)
+		}
+	}
+}
+
+type fakeAckQueue struct {
+	*fakeQueue
+	acked chan string
+}
+
+func (q *fakeAckQueue) New(n int) event.Queue {
+	return q
+}
+
+func (q *fakeAckQueue) Lease() (event.Lease, error) {
+	data, err := q.Dequeue()
+	if err != nil {
+		return nil, err
+	}
+	return &fakeLease{data: data, acked: q.acked}, nil
+}
+
+type fakeLease struct {
+	data  string
+	acked chan string
+}
+
+func (l *fakeLease) Data() string { return l.data }
+func (l *fakeLease) Ack() error   { l.acked <- "ack"; return nil }
+func (l *fakeLease) Nack() error  { l.acked <- "nack"; return nil }
+
+func TestEvent_AckQueue(t *testing.T) {
+	for _, v := range []struct {
+		err    error
+		dlq    event.DeadLetterQueue
+		expect string
+	}{
+		{nil, nil, "ack"},
+		{fmt.Errorf("failed"), nil, "ack"},
+		{fmt.Errorf("failed"), &memory.DeadLetterQueue{}, "ack"},
+	} {
+		func() {
+			e := event.New()
+			q := &fakeAckQueue{
+				fakeQueue: &fakeQueue{c: make(chan string), done: make(chan struct{})},
+				acked:     make(chan string),
+			}
+			e.RegisterQueue(queueName, q)
+			e.ErrorHandler = func(err interface{}) {}
+			e.DeadLetterQueue = v.dlq
+			handlerName := "testAckQueue"
+			for i := 0; i < 2; i++ {
+				err := error(nil)
+				if i == 1 {
+					err = v.err
+				}
+				if err := e.AddHandler(handlerName, queueName, func(args ...interface{}) error {
+					return err
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			e.Start()
+			defer e.Stop()
+			if err := e.Trigger(handlerName); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case actual := <-q.acked:
+				if !reflect.DeepEqual(actual, v.expect) {
+					t.Errorf("handler returns %#v with DeadLetterQueue %#v; lease => %#v; want %#v", v.err, v.dlq, actual, v.expect)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("lease hasn't been acknowledged within 3 seconds")
+			}
+		}()
+	}
+}
+
+func TestEvent_TriggerAfter(t *testing.T) {
+	e := event.New()
+	e.RegisterQueue(queueName, &fakeQueue{c: make(chan string), done: make(chan struct{})})
+	e.Start()
+	defer e.Stop()
+
+	handlerName := "testTriggerAfter"
+	called := make(chan interface{}, 2)
+	if err := e.AddHandler(handlerName, queueName, func(args ...interface{}) error {
+		called <- args[0]
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	canceled, err := e.TriggerAfter(50*time.Millisecond, handlerName, "canceled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.TriggerAfter(100*time.Millisecond, handlerName, "arg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Cancel(canceled); err != nil {
+		t.Errorf("Cancel(%q) => %#v; want nil", canceled, err)
+	}
+	if err := e.Cancel(canceled); err != event.ErrNotScheduled {
+		t.Errorf("Cancel(%q) twice => %#v; want %#v", canceled, err, event.ErrNotScheduled)
+	}
+	select {
+	case actual := <-called:
+		expected := "arg"
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("TriggerAfter(%v, %q, %q) has try to call handler with %#v; want %#v", 100*time.Millisecond, handlerName, "arg", actual, expected)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("TriggerAfter(%v, %q, %q) has try to call handler but hasn't been called within 3 seconds", 100*time.Millisecond, handlerName, "arg")
+	}
+	if _, err := e.TriggerAfter(time.Second, "unknown"); err == nil {
+		t.Errorf("TriggerAfter(%v, %q) => nil; want error", time.Second, "unknown")
+	}
+}
+
+type testTypedArg struct {
+	ID   int64
+	Tags []string
+}
+
+func TestEvent_AddTypedHandler(t *testing.T) {
+	for _, codec := range []event.Codec{nil, event.JSONCodec, event.MsgpackCodec} {
+		func() {
+			e := event.New()
+			e.Codec = codec
+			e.RegisterQueue(queueName, &fakeQueue{c: make(chan string), done: make(chan struct{})})
+			e.Start()
+			defer e.Stop()
+
+			called := make(chan interface{}, 2)
+			if err := e.AddTypedHandler("typed", queueName, func(v *testTypedArg) error {
+				called <- v
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if err := e.RegisterType("untyped", testTypedArg{}); err != nil {
+				t.Fatal(err)
+			}
+			if err := e.AddHandler("untyped", queueName, func(args ...interface{}) error {
+				called <- args[0]
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			arg := testTypedArg{ID: 1 << 60, Tags: []string{"a", "b"}}
+			for _, v := range []struct {
+				name   string
+				arg    interface{}
+				expect interface{}
+			}{
+				{"typed", arg, &arg},
+				{"typed", &arg, &arg},
+				{"untyped", &arg, arg},
+			} {
+				if err := e.Trigger(v.name, v.arg); err != nil {
+					t.Errorf("Trigger(%q, %#v) with %#v => %#v; want nil", v.name, v.arg, codec, err)
+					continue
+				}
+				select {
+				case actual := <-called:
+					if !reflect.DeepEqual(actual, v.expect) {
+						t.Errorf("Trigger(%q, %#v) with %#v has try to call handler with %#v; want %#v", v.name, v.arg, codec, actual, v.expect)
+					}
+				case <-time.After(3 * time.Second):
+					t.Fatalf("Trigger(%q, %#v) with %#v has try to call handler but hasn't been called within 3 seconds", v.name, v.arg, codec)
+				}
+			}
+			for _, args := range [][]interface{}{
+				nil,
+				{"arg"},
+				{arg, arg},
+			} {
+				if err := e.Trigger("typed", args...); err == nil {
+					t.Errorf("Trigger(%q, %#v...) => nil; want error", "typed", args)
+				}
+			}
+		}()
+	}
+
+	e := event.New()
+	e.RegisterQueue(queueName, &fakeQueue{c: make(chan string), done: make(chan struct{})})
+	for _, handler := range []interface{}{
+		"handler",
+		func(v testTypedArg) {},
+		func(v testTypedArg) string { return "" },
+		func(v1, v2 testTypedArg) error { return nil },
+		func(v interface{}) error { return nil },
+	} {
+		if err := e.AddTypedHandler("invalid", queueName, handler); err == nil {
+			t.Errorf("AddTypedHandler(%q, %q, %T) => nil; want error", "invalid", queueName, handler)
+		}
+	}
+	if err := e.AddTypedHandler("conflict", queueName, func(v testTypedArg) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddTypedHandler("conflict", queueName, func(meta event.Meta, v *testTypedArg) error { return nil }); err == nil {
+		t.Errorf("AddTypedHandler(%q, %q, handler) with the different type => nil; want error", "conflict", queueName)
+	}
+}
+
+func TestEvent_Metrics(t *testing.T) {
+	e := event.New()
+	e.RegisterQueue(queueName, &fakeQueue{c: make(chan string), done: make(chan struct{})})
+	e.ErrorHandler = func(err interface{}) {}
+	e.LatencyBuckets = []time.Duration{time.Millisecond, time.Hour}
+	var slow []string
+	e.SlowThreshold = 10 * time.Millisecond
+	e.SlowHandler = func(name, queueName string, elapsed time.Duration) {
+		slow = append(slow, fmt.Sprintf("%s:%s", name, queueName))
+	}
+	called := make(chan struct{}, 2)
+	handlerName := "testMetrics"
+	for _, err := range []error{nil, fmt.Errorf("testError")} {
+		err := err
+		if err := e.AddHandler(handlerName, queueName, func(args ...interface{}) error {
+			defer func() { called <- struct{}{} }()
+			if err != nil {
+				time.Sleep(20 * time.Millisecond)
+			}
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.Start()
+	if err := e.Trigger(handlerName); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-called:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("handler hasn't been called within 3 seconds")
+		}
+	}
+	e.Stop()
+	m := e.Metrics()[queueName]
+	var actual interface{} = []interface{}{m.Enqueued, m.Processed, m.Failed, m.Depth, m.Latency.Count, m.Latency.Counts[2]}
+	var expected interface{} = []interface{}{uint64(1), uint64(1), uint64(1), -1, uint64(2), uint64(0)}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Metrics()[%q] => %#v; want %#v", queueName, actual, expected)
+	}
+	actual = slow
+	expected = []string{handlerName + ":" + queueName}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("SlowHandler has been called with %#v; want %#v", actual, expected)
+	}
+}
+
+func TestEvent_StopDeadline(t *testing.T) {
+	e := event.New()
+	q := &fakeAckQueue{
+		fakeQueue: &fakeQueue{c: make(chan string), done: make(chan struct{})},
+		acked:     make(chan string, 1),
+	}
+	e.RegisterQueue(queueName, q)
+	handlerName := "testStopDeadline"
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	if err := e.AddHandler(handlerName, queueName, func(args ...interface{}) error {
+		close(started)
+		// ignore Done to simulate a stuck handler.
+		<-release
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	e.Start()
+	if err := e.Trigger(handlerName, "arg"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("handler hasn't been called within 3 seconds")
+	}
+	abandoned := e.StopDeadline(time.Now().Add(50 * time.Millisecond))
+	var actual interface{} = abandoned
+	var expected interface{} = []*event.Abandoned{{Name: handlerName, Queue: queueName, Args: []interface{}{"arg"}}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("StopDeadline(deadline) => %#v; want %#v", actual, expected)
+	}
+	select {
+	case actual = <-q.acked:
+		expected = "nack"
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("lease of the abandoned payload => %#v; want %#v", actual, expected)
+		}
+	default:
+		t.Errorf("lease of the abandoned payload hasn't been nacked")
+	}
+	select {
+	case <-e.Done():
+	default:
+		t.Errorf("Done() hasn't been closed after StopDeadline")
+	}
+	if err := e.Trigger(handlerName, "arg"); err != event.ErrStopped {
+		t.Errorf("Trigger(%q) after StopDeadline => %#v; want %#v", handlerName, err, event.ErrStopped)
+	}
+}
+
+func TestEvent_SetPriority(t *testing.T) {
+	e := event.New()
+	// the payloads are processed one by one.
+	q := &memory.EventQueue{Concurrency: 1}
+	q.New(1)
+	e.RegisterQueue("memory", q)
+	called := make(chan string, 2)
+	for _, name := range []string{"low", "high"} {
+		name := name
+		if err := e.AddHandler(name, "memory", func(args ...interface{}) error {
+			called <- name
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.SetPriority("high", event.PriorityHigh)
+	e.SetPriority("low", event.PriorityLow)
+	for _, name := range []string{"low", "high"} {
+		if err := e.Trigger(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for e.Metrics()["memory"].Enqueued < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	e.Start()
+	defer e.Stop()
+	var actual []string
+	for i := 0; i < 2; i++ {
+		select {
+		case name := <-called:
+			actual = append(actual, name)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("handler hasn't been called within 3 seconds")
+		}
+	}
+	expected := []string{"high", "low"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("handlers have been called in order of %#v; want %#v", actual, expected)
+	}
+}
+
+func TestEvent_TriggerUnique(t *testing.T) {
+	e := event.New()
+	// the payloads are processed one by one.
+	q := &memory.EventQueue{Concurrency: 1}
+	q.New(10)
+	e.RegisterQueue("memory", q)
+	called := make(chan []interface{}, 10)
+	if err := e.AddHandler("unique", "memory", func(args ...interface{}) error {
+		called <- args
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		unique event.Unique
+		name   string
+		arg    string
+		expect error
+	}{
+		{event.Unique{Key: "a"}, "unique", "a1", nil},
+		{event.Unique{Key: "a"}, "unique", "a2", event.ErrDuplicate},
+		{event.Unique{Key: "a", Policy: event.UniqueReplace}, "unique", "a3", nil},
+		{event.Unique{Key: "b"}, "unique", "b1", nil},
+		{event.Unique{}, "unique", "c1", fmt.Errorf("kocha: event: key of the unique event `unique' is empty")},
+	} {
+		err := e.TriggerUnique(v.unique, v.name, v.arg)
+		if !reflect.DeepEqual(err, v.expect) {
+			t.Errorf("TriggerUnique(%#v, %#v, %#v) => %#v; want %#v", v.unique, v.name, v.arg, err, v.expect)
+		}
+	}
+	e2 := event.New()
+	e2.RegisterQueue(queueName, &fakeQueue{c: make(chan string), done: make(chan struct{})})
+	if err := e2.AddHandler("fake", queueName, func(args ...interface{}) error {
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	err := e2.TriggerUnique(event.Unique{Key: "a"}, "fake")
+	expectErr := fmt.Errorf("kocha: event: queue `fakeQueue' doesn't support the unique events")
+	if !reflect.DeepEqual(err, expectErr) {
+		t.Errorf("TriggerUnique(%#v, %#v) => %#v; want %#v", event.Unique{Key: "a"}, "fake", err, expectErr)
+	}
+	e.Start()
+	defer e.Stop()
+	var actual [][]interface{}
+	for i := 0; i < 2; i++ {
+		select {
+		case args := <-called:
+			actual = append(actual, args)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("handler hasn't been called within 3 seconds")
+		}
+	}
+	expected := [][]interface{}{{"a3"}, {"b1"}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("handler has been called with %#v; want %#v", actual, expected)
+	}
+}
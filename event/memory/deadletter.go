@@ -0,0 +1,47 @@
+package memory
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/naoina/kocha/event"
+)
+
+// DeadLetterQueue implements the event.DeadLetterQueue interface.
+// Note that DeadLetterQueue isn't persistent as well as EventQueue.
+type DeadLetterQueue struct {
+	letters []*event.DeadLetter
+	seq     uint64
+	mu      sync.Mutex
+}
+
+// Add adds the letter to the queue.
+func (q *DeadLetterQueue) Add(letter *event.DeadLetter) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seq++
+	letter.ID = strconv.FormatUint(q.seq, 10)
+	q.letters = append(q.letters, letter)
+	return nil
+}
+
+// List returns the letters in the order added.
+func (q *DeadLetterQueue) List() ([]*event.DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]*event.DeadLetter(nil), q.letters...), nil
+}
+
+// Remove removes the letter of id from the queue.
+func (q *DeadLetterQueue) Remove(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, letter := range q.letters {
+		if letter.ID == id {
+			q.letters = append(q.letters[:i], q.letters[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("kocha: event: dead letter `%s' isn't found", id)
+}
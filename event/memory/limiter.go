@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/naoina/kocha/util"
+)
+
+// DefaultRatePeriod is the default period of EventQueue.RateLimit.
+const DefaultRatePeriod = 1 * time.Second
+
+// limiter limits the concurrency and the rate of the delivery of the queue.
+// The rate is limited by the token bucket that allows the burst up to rate.
+type limiter struct {
+	sem    chan struct{} // semaphore of the concurrency. nil if unlimited.
+	rate   int
+	period time.Duration
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+func newLimiter(concurrency, rate int, period time.Duration) *limiter {
+	l := &limiter{rate: rate, period: period}
+	if concurrency > 0 {
+		l.sem = make(chan struct{}, concurrency)
+	}
+	if l.period <= 0 {
+		l.period = DefaultRatePeriod
+	}
+	l.tokens = float64(rate)
+	return l
+}
+
+// take takes a token of the rate limit.
+// It returns the duration to wait for the next token if there is no token.
+func (l *limiter) take() time.Duration {
+	if l.rate < 1 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := util.Now()
+	if !l.last.IsZero() {
+		l.tokens += float64(now.Sub(l.last)) / float64(l.period) * float64(l.rate)
+		if l.tokens > float64(l.rate) {
+			l.tokens = float64(l.rate)
+		}
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / float64(l.rate) * float64(l.period))
+}
+
+// release releases the slot of the concurrency.
+func (l *limiter) release() {
+	if l.sem != nil {
+		<-l.sem
+	}
+}
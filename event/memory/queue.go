@@ -1,8 +1,16 @@
 package memory
 
-import "github.com/naoina/kocha/event"
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
-// EventQueue implements the Queue interface.
+	"github.com/naoina/kocha/event"
+)
+
+// EventQueue implements the AckQueue, DelayQueue, DepthQueue, PriorityQueue
+// and UniqueQueue interfaces.
 // This doesn't require the external storages such as Redis.
 // Note that EventQueue isn't persistent, this means that queued data may be
 // lost by crash, shutdown or status of not running.
@@ -11,46 +19,203 @@ import "github.com/naoina/kocha/event"
 // Also queue won't be shared between different servers but will be shared
 // between other workers in same server.
 type EventQueue struct {
-	c    chan string
-	done chan struct{}
-	exit chan struct{}
+	// Concurrency is the maximum number of the leased data that haven't been
+	// acknowledged yet. In other words, it is the maximum number of the
+	// payloads that are processed by the workers at the same time.
+	// If 0, it is unlimited.
+	Concurrency int
+
+	// RateLimit is the maximum number of the data that is delivered per
+	// RatePeriod. If 0, it is unlimited.
+	RateLimit int
+
+	// RatePeriod is the period of RateLimit.
+	// If 0, DefaultRatePeriod will be used.
+	RatePeriod time.Duration
+
+	c     chan *item // data of the normal priority.
+	high  chan *item // data of the high priority.
+	low   chan *item // data of the low priority.
+	done  chan struct{}
+	exit  chan struct{}
+	sched *scheduler
+	limit *limiter
+	locks *locks
+	over  *overflow
+	once  sync.Once
+
+	// pending is the number of the data that have been enqueued but haven't
+	// been received yet, including the data that are waiting for the room of
+	// the channels.
+	pending *int64
+}
+
+// item is an item of the queue.
+type item struct {
+	data string
+	key  string // key of the unique data.
 }
 
 // New returns a new EventQueue.
 func (q *EventQueue) New(n int) event.Queue {
-	if q.c == nil {
-		q.c = make(chan string, n)
-	}
-	if q.done == nil {
-		q.done = make(chan struct{})
-	}
-	if q.exit == nil {
-		q.exit = make(chan struct{})
-	}
+	q.init(n)
 	return &EventQueue{
-		c:    q.c,
-		done: q.done,
-		exit: q.exit,
+		c:     q.c,
+		high:  q.high,
+		low:   q.low,
+		done:  q.done,
+		exit:  q.exit,
+		sched: q.sched,
+		limit: q.limit,
+		locks: q.locks,
+		over:  q.over,
+
+		pending: q.pending,
 	}
 }
 
+// init initializes the queue with the capacity n.
+// It is called by New, and also by the methods that are called before New
+// such as Enqueue. In that case, the capacity will be 1.
+func (q *EventQueue) init(n int) {
+	q.once.Do(func() {
+		if n < 1 {
+			n = 1
+		}
+		if q.c == nil {
+			q.c = make(chan *item, n)
+		}
+		if q.high == nil {
+			q.high = make(chan *item, n)
+		}
+		if q.low == nil {
+			q.low = make(chan *item, n)
+		}
+		if q.done == nil {
+			q.done = make(chan struct{})
+		}
+		if q.exit == nil {
+			q.exit = make(chan struct{})
+		}
+		if q.pending == nil {
+			q.pending = new(int64)
+		}
+		if q.sched == nil {
+			q.sched = newScheduler(func(it *item) {
+				q.send(q.c, it)
+			})
+		}
+		if q.limit == nil {
+			q.limit = newLimiter(q.Concurrency, q.RateLimit, q.RatePeriod)
+		}
+		if q.locks == nil {
+			q.locks = newLocks()
+		}
+		if q.over == nil {
+			q.over = &overflow{}
+		}
+	})
+}
+
 // Enqueue adds data to queue.
 func (q *EventQueue) Enqueue(data string) error {
-	q.c <- data
+	q.init(0)
+	q.send(q.c, &item{data: data})
+	return nil
+}
+
+// EnqueueUnique adds data to queue unless the data of u.Key exists.
+// The data is enqueued with the normal priority.
+// It doesn't block even if the queue is full. In that case, the data will be
+// held until the queue has room.
+func (q *EventQueue) EnqueueUnique(u event.Unique, data string) error {
+	q.init(0)
+	it, err := q.locks.add(u, data)
+	if err != nil || it == nil {
+		return err
+	}
+	atomic.AddInt64(q.pending, 1)
+	q.over.send(q.c, it)
+	return nil
+}
+
+// EnqueuePriority adds data to queue with priority.
+// The priority that is greater than event.PriorityNormal is treated as
+// event.PriorityHigh, and less than it is treated as event.PriorityLow.
+func (q *EventQueue) EnqueuePriority(data string, priority event.Priority) error {
+	q.init(0)
+	it := &item{data: data}
+	switch {
+	case priority > event.PriorityNormal:
+		q.send(q.high, it)
+	case priority < event.PriorityNormal:
+		q.send(q.low, it)
+	default:
+		q.send(q.c, it)
+	}
+	return nil
+}
+
+// EnqueueAt adds data to queue at t.
+// The scheduled data is held in the heap until the time comes.
+func (q *EventQueue) EnqueueAt(id string, data string, t time.Time) error {
+	q.init(0)
+	q.sched.add(id, data, t)
 	return nil
 }
 
+// Cancel removes the scheduled data of id.
+func (q *EventQueue) Cancel(id string) error {
+	q.init(0)
+	return q.sched.cancel(id)
+}
+
+// Depth returns the number of the data in the queue, including the data
+// that are waiting for the room of the queue.
+// The scheduled data that the time hasn't come isn't included.
+func (q *EventQueue) Depth() (int, error) {
+	q.init(0)
+	return int(atomic.LoadInt64(q.pending)), nil
+}
+
 // Dequeue returns the data that fetch from queue.
+// The data of the higher priority will be returned first.
 func (q *EventQueue) Dequeue() (data string, err error) {
-	select {
-	case data = <-q.c:
-		return data, nil
-	case <-q.done:
-		defer func() {
+	if err := q.wait(); err != nil {
+		return "", err
+	}
+	it, _, err := q.receive()
+	if err != nil {
+		return "", err
+	}
+	// the data is considered to be processed.
+	q.locks.release(it)
+	return it.data, nil
+}
+
+// Lease returns the lease of the data that fetch from queue.
+// The nacked data will be added to the queue again unless the queue is full.
+// If Concurrency is specified, it blocks until the number of the unacknowledged
+// leases falls below Concurrency.
+func (q *EventQueue) Lease() (event.Lease, error) {
+	if q.limit.sem != nil {
+		select {
+		case q.limit.sem <- struct{}{}:
+		case <-q.done:
 			q.exit <- struct{}{}
-		}()
-		return "", event.ErrDone
+			return nil, event.ErrDone
+		}
 	}
+	if err := q.wait(); err != nil {
+		q.limit.release()
+		return nil, err
+	}
+	it, c, err := q.receive()
+	if err != nil {
+		q.limit.release()
+		return nil, err
+	}
+	return &lease{q: q, it: it, c: c}, nil
 }
 
 // Stop wait for Dequeue to complete then will stop a queue.
@@ -58,3 +223,95 @@ func (q *EventQueue) Stop() {
 	q.done <- struct{}{}
 	<-q.exit
 }
+
+// wait waits for the rate limit.
+func (q *EventQueue) wait() error {
+	for {
+		d := q.limit.take()
+		if d <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-q.done:
+			timer.Stop()
+			q.exit <- struct{}{}
+			return event.ErrDone
+		}
+	}
+}
+
+// send sends it to c and counts it as pending until it is received.
+func (q *EventQueue) send(c chan *item, it *item) {
+	atomic.AddInt64(q.pending, 1)
+	c <- it
+}
+
+// receive receives the item from the channel of the highest priority that has
+// the item. It returns the item and the channel.
+func (q *EventQueue) receive() (it *item, c chan *item, err error) {
+	defer func() {
+		if it != nil {
+			atomic.AddInt64(q.pending, -1)
+			q.locks.run(it)
+		}
+		if c == q.c {
+			q.over.flush(q.c)
+		}
+	}()
+	for _, c := range []chan *item{q.high, q.c, q.low} {
+		select {
+		case it = <-c:
+			return it, c, nil
+		default:
+		}
+	}
+	select {
+	case it = <-q.high:
+		return it, q.high, nil
+	case it = <-q.c:
+		return it, q.c, nil
+	case it = <-q.low:
+		return it, q.low, nil
+	case <-q.done:
+		defer func() {
+			q.exit <- struct{}{}
+		}()
+		return nil, nil, event.ErrDone
+	}
+}
+
+// lease implements the event.Lease interface.
+type lease struct {
+	q    *EventQueue
+	it   *item
+	c    chan *item // channel that the item has been received from.
+	once sync.Once
+}
+
+func (l *lease) Data() string {
+	return l.it.data
+}
+
+func (l *lease) Ack() error {
+	l.once.Do(func() {
+		l.q.limit.release()
+		l.q.locks.release(l.it)
+	})
+	return nil
+}
+
+func (l *lease) Nack() error {
+	l.once.Do(l.q.limit.release)
+	l.q.locks.pend(l.it)
+	atomic.AddInt64(l.q.pending, 1)
+	select {
+	case l.c <- l.it:
+		return nil
+	default:
+		atomic.AddInt64(l.q.pending, -1)
+		l.q.locks.release(l.it)
+		return fmt.Errorf("kocha: event: memory: queue is full, nacked data has been dropped: %v", l.it.data)
+	}
+}
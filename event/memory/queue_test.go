@@ -1,10 +1,15 @@
 package memory
 
 import (
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/naoina/kocha/event"
+	"github.com/naoina/kocha/util"
 )
 
 func TestEventQueue(t *testing.T) {
@@ -32,3 +37,328 @@ func TestEventQueue(t *testing.T) {
 		t.Errorf("event.Trigger(%q) has try to call handler but hasn't been called within 3 seconds", handlerName)
 	}
 }
+
+func TestEventQueue_EnqueueAt(t *testing.T) {
+	q := (&EventQueue{}).New(10).(*EventQueue)
+	now := time.Now()
+	for _, v := range []struct {
+		id    string
+		after time.Duration
+	}{
+		{"3", 150 * time.Millisecond},
+		{"1", 50 * time.Millisecond},
+		{"canceled", 75 * time.Millisecond},
+		{"2", 100 * time.Millisecond},
+	} {
+		if err := q.EnqueueAt(v.id, v.id, now.Add(v.after)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Cancel("canceled"); err != nil {
+		t.Errorf("Cancel(%q) => %#v; want nil", "canceled", err)
+	}
+	if err := q.Cancel("unknown"); err != event.ErrNotScheduled {
+		t.Errorf("Cancel(%q) => %#v; want %#v", "unknown", err, event.ErrNotScheduled)
+	}
+	var actual []string
+	for i := 0; i < 3; i++ {
+		data, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, data)
+	}
+	expected := []string{"1", "2", "3"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Dequeue() => %#v; want %#v", actual, expected)
+	}
+	if elapsed := time.Since(now); elapsed < 150*time.Millisecond {
+		t.Errorf("scheduled data has been delivered after %v; want after %v", elapsed, 150*time.Millisecond)
+	}
+}
+
+func TestEventQueue_EnqueueAt_beforeNew(t *testing.T) {
+	q := &EventQueue{}
+	if err := q.EnqueueAt("1", "1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.EnqueueAt("2", "2", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Cancel("2"); err != nil {
+		t.Errorf("Cancel(%q) => %#v; want nil", "2", err)
+	}
+	actual, err := q.New(1).Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "1"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Dequeue() => %#v; want %#v", actual, expected)
+	}
+}
+
+func TestEventQueue_Depth(t *testing.T) {
+	q := (&EventQueue{}).New(10).(*EventQueue)
+	for _, data := range []string{"1", "2"} {
+		if err := q.Enqueue(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.EnqueueAt("3", "3", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Cancel("3")
+	actual, err := q.Depth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := 2
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Depth() => %#v; want %#v", actual, expected)
+	}
+}
+
+func TestEventQueue_Depth_full(t *testing.T) {
+	q := (&EventQueue{}).New(1).(*EventQueue)
+	// the senders are blocked because the queue is full.
+	for i := 0; i < 5; i++ {
+		go q.Enqueue(strconv.Itoa(i))
+	}
+	for start := time.Now(); time.Since(start) < 3*time.Second; time.Sleep(10 * time.Millisecond) {
+		if n, _ := q.Depth(); n == 5 {
+			break
+		}
+	}
+	actual, err := q.Depth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := 5
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Depth() => %#v; want %#v", actual, expected)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := q.Dequeue(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	actual, err = q.Depth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = 0
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Depth() after Dequeue => %#v; want %#v", actual, expected)
+	}
+}
+
+func TestEventQueue_EnqueuePriority(t *testing.T) {
+	q := (&EventQueue{}).New(10).(*EventQueue)
+	for _, v := range []struct {
+		data     string
+		priority event.Priority
+	}{
+		{"low", event.PriorityLow},
+		{"normal1", event.PriorityNormal},
+		{"high1", event.PriorityHigh},
+		{"normal2", event.PriorityNormal},
+		{"high2", event.PriorityHigh + 1},
+	} {
+		if err := q.EnqueuePriority(v.data, v.priority); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var actual []string
+	for i := 0; i < 5; i++ {
+		data, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, data)
+	}
+	expected := []string{"high1", "high2", "normal1", "normal2", "low"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Dequeue() => %#v; want %#v", actual, expected)
+	}
+}
+
+func TestEventQueue_Concurrency(t *testing.T) {
+	q := (&EventQueue{Concurrency: 1}).New(10).(*EventQueue)
+	for _, data := range []string{"1", "2"} {
+		if err := q.Enqueue(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := q.Lease()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leased := make(chan event.Lease)
+	go func() {
+		l, err := q.Lease()
+		if err != nil {
+			t.Error(err)
+		}
+		leased <- l
+	}()
+	select {
+	case <-leased:
+		t.Fatalf("Lease() with Concurrency 1 has returned before Ack")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := l.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case l := <-leased:
+		var actual interface{} = l.Data()
+		var expected interface{} = "2"
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Lease().Data() => %#v; want %#v", actual, expected)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Lease() hasn't returned within 3 seconds after Ack")
+	}
+}
+
+func TestEventQueue_RateLimit(t *testing.T) {
+	q := (&EventQueue{RateLimit: 2, RatePeriod: 200 * time.Millisecond}).New(10).(*EventQueue)
+	for _, data := range []string{"1", "2", "3"} {
+		if err := q.Enqueue(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := q.Dequeue(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("3 data with RateLimit 2 per %v have been dequeued in %v; want %v or more", 200*time.Millisecond, elapsed, 100*time.Millisecond)
+	}
+}
+
+func TestEventQueue_EnqueueUnique(t *testing.T) {
+	now := time.Now()
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	q := (&EventQueue{}).New(10).(*EventQueue)
+	for _, v := range []struct {
+		unique event.Unique
+		data   string
+		expect error
+	}{
+		{event.Unique{Key: "a", Window: time.Minute}, "a1", nil},
+		{event.Unique{Key: "a"}, "a2", event.ErrDuplicate},
+		{event.Unique{Key: "a", Policy: event.UniqueReplace, Window: time.Minute}, "a3", nil},
+		{event.Unique{Key: "b"}, "b1", nil},
+	} {
+		if err := q.EnqueueUnique(v.unique, v.data); err != v.expect {
+			t.Errorf("EnqueueUnique(%#v, %q) => %#v; want %#v", v.unique, v.data, err, v.expect)
+		}
+	}
+	l, err := q.Lease()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual interface{} = l.Data()
+	var expected interface{} = "a3"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Lease().Data() => %#v; want %#v", actual, expected)
+	}
+
+	// running within the window.
+	for _, v := range []struct {
+		elapsed time.Duration
+		unique  event.Unique
+		expect  error
+	}{
+		{30 * time.Second, event.Unique{Key: "a", Policy: event.UniqueExtend, Window: time.Minute}, event.ErrDuplicate},
+		{45 * time.Second, event.Unique{Key: "a"}, event.ErrDuplicate},
+		{30 * time.Second, event.Unique{Key: "a"}, nil},
+	} {
+		now = now.Add(v.elapsed)
+		if err := q.EnqueueUnique(v.unique, "a4"); err != v.expect {
+			t.Errorf("EnqueueUnique(%#v, %q) after %v => %#v; want %#v", v.unique, "a4", v.elapsed, err, v.expect)
+		}
+	}
+	if err := l.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.EnqueueUnique(event.Unique{Key: "a"}, "a5"); err != event.ErrDuplicate {
+		t.Errorf("EnqueueUnique after Ack of the old data => %#v; want %#v", err, event.ErrDuplicate)
+	}
+	actual, _ = q.Depth()
+	expected = 2
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Depth() => %#v; want %#v", actual, expected)
+	}
+}
+
+func TestEventQueue_EnqueueUnique_beforeNew(t *testing.T) {
+	q := &EventQueue{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, key := range []string{"a", "b", "c"} {
+			if err := q.EnqueueUnique(event.Unique{Key: key}, key); err != nil {
+				t.Errorf("EnqueueUnique(%#v, %q) => %#v; want nil", event.Unique{Key: key}, key, err)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("EnqueueUnique to the full queue has been blocked for 3 seconds")
+	}
+	dq := q.New(1)
+	var actual []string
+	for i := 0; i < 3; i++ {
+		data, err := dq.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, data)
+	}
+	sort.Strings(actual)
+	expected := []string{"a", "b", "c"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Dequeue() => %#v; want %#v", actual, expected)
+	}
+}
+
+func TestEventQueue_EnqueueUnique_full(t *testing.T) {
+	q := (&EventQueue{}).New(1).(*EventQueue)
+	const n = 100
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < n; i++ {
+		key := strconv.Itoa(i)
+		if err := q.EnqueueUnique(event.Unique{Key: key}, key); err != nil {
+			t.Errorf("EnqueueUnique(%#v, %q) => %#v; want nil", event.Unique{Key: key}, key, err)
+		}
+	}
+	if actual := runtime.NumGoroutine(); actual >= goroutines+n/2 {
+		t.Errorf("EnqueueUnique to the full queue %v times; number of goroutines => %v; want less than %v", n, actual, goroutines+n/2)
+	}
+	var actual interface{}
+	actual, err := q.Depth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected interface{} = n
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Depth() => %#v; want %#v", actual, expected)
+	}
+	for i := 0; i < n; i++ {
+		actual, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := strconv.Itoa(i)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Dequeue() => %#v; want %#v", actual, expected)
+		}
+	}
+}
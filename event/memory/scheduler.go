@@ -0,0 +1,117 @@
+package memory
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/naoina/kocha/event"
+	"github.com/naoina/kocha/util"
+)
+
+// scheduler holds the scheduled data in the heap ordered by the time, and
+// sends them to the queue when the time comes.
+type scheduler struct {
+	send  func(it *item)
+	items scheduledItems
+	ids   map[string]*scheduledItem
+	timer *time.Timer
+	mu    sync.Mutex
+}
+
+func newScheduler(send func(it *item)) *scheduler {
+	return &scheduler{
+		send: send,
+		ids:  make(map[string]*scheduledItem),
+	}
+}
+
+// add adds data that will be sent at t.
+func (s *scheduler) add(id, data string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := &scheduledItem{id: id, data: data, t: t}
+	heap.Push(&s.items, item)
+	s.ids[id] = item
+	s.reset()
+}
+
+// cancel removes the data of id.
+func (s *scheduler) cancel(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, exists := s.ids[id]
+	if !exists {
+		return event.ErrNotScheduled
+	}
+	heap.Remove(&s.items, item.index)
+	delete(s.ids, id)
+	s.reset()
+	return nil
+}
+
+// reset resets the timer to the time of the earliest data.
+// It must be called with the lock.
+func (s *scheduler) reset() {
+	if len(s.items) == 0 {
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		return
+	}
+	d := s.items[0].t.Sub(util.Now())
+	if s.timer == nil {
+		s.timer = time.AfterFunc(d, s.fire)
+		return
+	}
+	s.timer.Reset(d)
+}
+
+// fire sends the data that the time has come.
+func (s *scheduler) fire() {
+	s.mu.Lock()
+	var due []string
+	now := util.Now()
+	for len(s.items) > 0 && !s.items[0].t.After(now) {
+		item := heap.Pop(&s.items).(*scheduledItem)
+		delete(s.ids, item.id)
+		due = append(due, item.data)
+	}
+	s.reset()
+	s.mu.Unlock()
+	for _, data := range due {
+		s.send(&item{data: data})
+	}
+}
+
+type scheduledItem struct {
+	id    string
+	data  string
+	t     time.Time
+	index int
+}
+
+// scheduledItems implements the heap.Interface.
+type scheduledItems []*scheduledItem
+
+func (items scheduledItems) Len() int           { return len(items) }
+func (items scheduledItems) Less(i, j int) bool { return items[i].t.Before(items[j].t) }
+
+func (items scheduledItems) Swap(i, j int) {
+	items[i], items[j] = items[j], items[i]
+	items[i].index = i
+	items[j].index = j
+}
+
+func (items *scheduledItems) Push(x interface{}) {
+	item := x.(*scheduledItem)
+	item.index = len(*items)
+	*items = append(*items, item)
+}
+
+func (items *scheduledItems) Pop() interface{} {
+	old := *items
+	item := old[len(old)-1]
+	*items = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,137 @@
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/naoina/kocha/event"
+	"github.com/naoina/kocha/util"
+)
+
+// locks holds the existence of the unique data by key.
+type locks struct {
+	m  map[string]*lock
+	mu sync.Mutex
+}
+
+// lock represents the existence of the unique data.
+type lock struct {
+	it      *item
+	running bool
+	until   time.Time // zero if the running data exists until it is acknowledged.
+}
+
+func newLocks() *locks {
+	return &locks{m: make(map[string]*lock)}
+}
+
+// add returns a new item of data unless the data of u.Key exists.
+// It returns nil item if the existing data has been replaced.
+func (l *locks) add(u event.Unique, data string) (*item, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := util.Now()
+	if lk, exist := l.m[u.Key]; exist && lk.exists(now) {
+		switch u.Policy {
+		case event.UniqueReplace:
+			if !lk.running {
+				lk.it.data = data
+				lk.until = until(now, u.Window)
+				return nil, nil
+			}
+		case event.UniqueExtend:
+			lk.until = until(now, u.Window)
+			return nil, event.ErrDuplicate
+		default:
+			return nil, event.ErrDuplicate
+		}
+	}
+	it := &item{data: data, key: u.Key}
+	l.m[u.Key] = &lock{it: it, until: until(now, u.Window)}
+	return it, nil
+}
+
+// run marks the data of it as running.
+func (l *locks) run(it *item) {
+	l.setRunning(it, true)
+}
+
+// pend marks the data of it as pending again.
+func (l *locks) pend(it *item) {
+	l.setRunning(it, false)
+}
+
+func (l *locks) setRunning(it *item, running bool) {
+	if it.key == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lk, exist := l.m[it.key]; exist && lk.it == it {
+		lk.running = running
+	}
+}
+
+// release removes the existence of the data of it.
+func (l *locks) release(it *item) {
+	if it.key == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lk, exist := l.m[it.key]; exist && lk.it == it {
+		delete(l.m, it.key)
+	}
+}
+
+// overflow holds the unique data that couldn't be sent to the full channel.
+// The held data will be sent in order when the channel has room.
+type overflow struct {
+	items []*item
+	mu    sync.Mutex
+}
+
+// send sends it to c without blocking. If c is full, or the older data is
+// held, it holds it.
+func (o *overflow) send(c chan *item, it *item) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.items) == 0 {
+		select {
+		case c <- it:
+			return
+		default:
+		}
+	}
+	o.items = append(o.items, it)
+}
+
+// flush sends the held data to c while c has room.
+// It must be called after the data is received from c.
+func (o *overflow) flush(c chan *item) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for len(o.items) > 0 {
+		select {
+		case c <- o.items[0]:
+			o.items[0] = nil
+			o.items = o.items[1:]
+		default:
+			return
+		}
+	}
+}
+
+// exists reports whether the data exists at now.
+func (lk *lock) exists(now time.Time) bool {
+	return !lk.running || lk.until.IsZero() || now.Before(lk.until)
+}
+
+// until returns the end of window from now.
+// It returns zero if window is 0.
+func until(now time.Time, window time.Duration) time.Time {
+	if window <= 0 {
+		return time.Time{}
+	}
+	return now.Add(window)
+}
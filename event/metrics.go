@@ -0,0 +1,159 @@
+package event
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBuckets is the default upper bounds of the buckets of the
+// latency histograms of the handlers.
+var DefaultLatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// DepthQueue is the interface that is implemented by the queue that can
+// report the number of the data waiting in the queue.
+type DepthQueue interface {
+	Queue
+
+	// Depth returns the number of the data that is waiting to be dequeued.
+	Depth() (int, error)
+}
+
+// QueueMetrics represents the metrics of a queue.
+type QueueMetrics struct {
+	// Enqueued is the number of the payloads that have been enqueued.
+	Enqueued uint64 `json:"enqueued"`
+
+	// Processed is the number of the handler calls that have succeeded.
+	Processed uint64 `json:"processed"`
+
+	// Failed is the number of the handler calls that have failed after all
+	// attempts.
+	Failed uint64 `json:"failed"`
+
+	// Depth is the number of the data that is waiting in the queue.
+	// If the queue doesn't implement DepthQueue or it returns an error, Depth
+	// is -1.
+	Depth int `json:"depth"`
+
+	// Latency is the histogram of the latencies of each attempt of the
+	// handlers.
+	Latency Histogram `json:"latency"`
+}
+
+// Histogram represents a histogram of the durations.
+type Histogram struct {
+	// Buckets is the upper bounds of the buckets in ascending order.
+	Buckets []time.Duration `json:"buckets"`
+
+	// Counts is the number of the observations of each bucket. The last
+	// element is the number of the observations that exceed all buckets, so
+	// the length of Counts is len(Buckets)+1. The counts aren't cumulative.
+	Counts []uint64 `json:"counts"`
+
+	// Count is the total number of the observations.
+	Count uint64 `json:"count"`
+
+	// Sum is the sum of the observations.
+	Sum time.Duration `json:"sum"`
+}
+
+func newHistogram(buckets []time.Duration) Histogram {
+	return Histogram{
+		Buckets: buckets,
+		Counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	i := sort.Search(len(h.Buckets), func(i int) bool { return d <= h.Buckets[i] })
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+}
+
+func (h *Histogram) clone() Histogram {
+	c := *h
+	c.Buckets = append([]time.Duration(nil), h.Buckets...)
+	c.Counts = append([]uint64(nil), h.Counts...)
+	return c
+}
+
+// queueMetrics is the counters of a queue.
+type queueMetrics struct {
+	enqueued  uint64
+	processed uint64
+	failed    uint64
+	latency   Histogram
+	mu        sync.Mutex // lock for latency.
+}
+
+// Metrics returns the snapshot of the metrics of each queue by the queue
+// name.
+func (e *Event) Metrics() map[string]QueueMetrics {
+	metrics := make(map[string]QueueMetrics, len(e.queues))
+	for name, queue := range e.queues {
+		m := e.queueMetrics(name)
+		m.mu.Lock()
+		latency := m.latency.clone()
+		m.mu.Unlock()
+		depth := -1
+		if q, ok := queue.(DepthQueue); ok {
+			if n, err := q.Depth(); err == nil {
+				depth = n
+			}
+		}
+		metrics[name] = QueueMetrics{
+			Enqueued:  atomic.LoadUint64(&m.enqueued),
+			Processed: atomic.LoadUint64(&m.processed),
+			Failed:    atomic.LoadUint64(&m.failed),
+			Depth:     depth,
+			Latency:   latency,
+		}
+	}
+	return metrics
+}
+
+// queueMetrics returns the metrics of the queue of name.
+func (e *Event) queueMetrics(name string) *queueMetrics {
+	e.metricsMu.Lock()
+	defer e.metricsMu.Unlock()
+	if e.metrics == nil {
+		e.metrics = make(map[string]*queueMetrics)
+	}
+	m, exist := e.metrics[name]
+	if !exist {
+		buckets := e.LatencyBuckets
+		if buckets == nil {
+			buckets = DefaultLatencyBuckets
+		}
+		m = &queueMetrics{latency: newHistogram(buckets)}
+		e.metrics[name] = m
+	}
+	return m
+}
+
+// observe records the latency of the handler of the event, and calls
+// SlowHandler if the latency exceeds SlowThreshold.
+func (e *Event) observe(queueName, name string, d time.Duration) {
+	m := e.queueMetrics(queueName)
+	m.mu.Lock()
+	m.latency.observe(d)
+	m.mu.Unlock()
+	if e.SlowThreshold > 0 && d >= e.SlowThreshold && e.SlowHandler != nil {
+		e.SlowHandler(name, queueName, d)
+	}
+}
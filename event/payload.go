@@ -5,6 +5,17 @@ import "encoding/json"
 type payload struct {
 	Name string        `json:"name"`
 	Args []interface{} `json:"args"`
+	Meta Meta          `json:"meta,omitempty"`
+
+	// Type is the type name of the typed argument that is encoded in Data by
+	// the codec of Codec. If Type is empty, Args is used.
+	Type  string `json:"type,omitempty"`
+	Codec string `json:"codec,omitempty"`
+	Data  []byte `json:"data,omitempty"`
+
+	// Handler is the 1-based index of the handler to be called.
+	// If Handler is 0, all handlers of the event will be called.
+	Handler int `json:"handler,omitempty"`
 }
 
 func (p *payload) encode(dest *string) error {
@@ -0,0 +1,50 @@
+package event
+
+// Priority represents a priority level of the event within a queue.
+type Priority int
+
+// The priority levels.
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// SetPriority is shorthand of the DefaultEvent.SetPriority.
+func SetPriority(name string, priority Priority) {
+	DefaultEvent.SetPriority(name, priority)
+}
+
+// PriorityQueue is the interface that is implemented by the queue that
+// supports the priority levels.
+// If the queue doesn't implement PriorityQueue, the priority will be ignored.
+type PriorityQueue interface {
+	Queue
+
+	// EnqueuePriority adds data to the queue with priority.
+	// The data of the higher priority must be dequeued before the data of
+	// the lower priority.
+	EnqueuePriority(data string, priority Priority) error
+}
+
+// SetPriority sets the priority of the event of name.
+// The payloads of the event will be enqueued with priority to the queues
+// that implement PriorityQueue. The default priority is PriorityNormal.
+// It must be called before Start calls.
+func (e *Event) SetPriority(name string, priority Priority) {
+	if e.priorities == nil {
+		e.priorities = make(map[string]Priority)
+	}
+	e.priorities[name] = priority
+}
+
+// enqueueData adds data of the event of name to queue with the priority of
+// the event.
+func (e *Event) enqueueData(queue Queue, name string, data string) error {
+	if p := e.priorities[name]; p != PriorityNormal {
+		if q, ok := queue.(PriorityQueue); ok {
+			return q.EnqueuePriority(data, p)
+		}
+	}
+	return queue.Enqueue(data)
+}
@@ -0,0 +1,70 @@
+package event
+
+import (
+	"math/rand"
+	"time"
+)
+
+// DefaultRetryBackoff is the default wait before the first retry.
+const DefaultRetryBackoff = 1 * time.Second
+
+// RetryPolicy represents the retry policy of the handler.
+//
+// The failed handler will be retried after the wait of Backoff, and the wait
+// doubles on each retry up to MaxBackoff. When the attempts are exhausted, the
+// last error will be passed to ErrorHandler, and the payload will be added to
+// DeadLetterQueue if it is set.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the first.
+	// If MaxAttempts is less than 2, the handler won't be retried.
+	MaxAttempts int
+
+	// Backoff is the wait before the first retry.
+	// If Backoff is 0, DefaultRetryBackoff will be used.
+	Backoff time.Duration
+
+	// MaxBackoff is the maximum wait before a retry.
+	// If MaxBackoff is 0, the wait won't be limited.
+	MaxBackoff time.Duration
+
+	// Jitter is the ratio of randomization of the wait in the range [0, 1].
+	// For example, 0.2 means that the wait will be randomly reduced by up to
+	// 20% in order to avoid that many retries happen at the same time.
+	Jitter float64
+}
+
+// maxAttempts returns the maximum number of attempts.
+func (p *RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// backoff returns the wait before the retry after the attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.Backoff
+	if d <= 0 {
+		d = DefaultRetryBackoff
+	}
+	for i := 1; i < attempt; i++ {
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+		if d > d<<1 { // overflow.
+			break
+		}
+		d <<= 1
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		d -= time.Duration(float64(d) * jitter * rand.Float64())
+	}
+	return d
+}
@@ -0,0 +1,177 @@
+package event
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/naoina/kocha/util"
+)
+
+// ErrNotScheduled is returned by Cancel if the scheduled event not exists.
+var ErrNotScheduled = errors.New("scheduled event not exist")
+
+// TriggerAt is shorthand of the DefaultEvent.TriggerAt.
+func TriggerAt(t time.Time, name string, args ...interface{}) (id string, err error) {
+	return DefaultEvent.TriggerAt(t, name, args...)
+}
+
+// TriggerAtMeta is shorthand of the DefaultEvent.TriggerAtMeta.
+func TriggerAtMeta(t time.Time, name string, meta Meta, args ...interface{}) (id string, err error) {
+	return DefaultEvent.TriggerAtMeta(t, name, meta, args...)
+}
+
+// TriggerAfter is shorthand of the DefaultEvent.TriggerAfter.
+func TriggerAfter(d time.Duration, name string, args ...interface{}) (id string, err error) {
+	return DefaultEvent.TriggerAfter(d, name, args...)
+}
+
+// TriggerAfterMeta is shorthand of the DefaultEvent.TriggerAfterMeta.
+func TriggerAfterMeta(d time.Duration, name string, meta Meta, args ...interface{}) (id string, err error) {
+	return DefaultEvent.TriggerAfterMeta(d, name, meta, args...)
+}
+
+// Cancel is shorthand of the DefaultEvent.Cancel.
+func Cancel(id string) error {
+	return DefaultEvent.Cancel(id)
+}
+
+// DelayQueue is the interface that is implemented by the queue that supports
+// the delayed delivery.
+// If the queue doesn't implement DelayQueue, the scheduled events will be
+// held by the timers in memory until the time comes, so they will be lost by
+// the shutdown.
+type DelayQueue interface {
+	Queue
+
+	// EnqueueAt adds data to the queue that will be delivered at t.
+	// The id is the identifier of the scheduled data to cancel it.
+	EnqueueAt(id string, data string, t time.Time) error
+
+	// Cancel removes the scheduled data of id that hasn't been delivered yet.
+	// If the data not exists, it returns ErrNotScheduled.
+	Cancel(id string) error
+}
+
+// TriggerAt emits the event at t.
+// It returns the ID of the scheduled event that can be used to Cancel.
+// If t is past, the event will be emitted immediately.
+func (e *Event) TriggerAt(t time.Time, name string, args ...interface{}) (id string, err error) {
+	return e.TriggerAtMeta(t, name, nil, args...)
+}
+
+// TriggerAtMeta is similar to TriggerAt, but it emits the event with meta.
+// The meta will be passed to handlers added by AddMetaHandler.
+func (e *Event) TriggerAtMeta(t time.Time, name string, meta Meta, args ...interface{}) (id string, err error) {
+	if e.stopped() {
+		return "", ErrStopped
+	}
+	hq, exist := e.handlerQueues[name]
+	if !exist {
+		return "", fmt.Errorf("kocha: event: handler `%s' isn't added", name)
+	}
+	id = hex.EncodeToString(util.GenerateRandomKey(16))
+	pld, err := e.newPayload(name, meta, args)
+	if err != nil {
+		return "", err
+	}
+	var data string
+	if err := pld.encode(&data); err != nil {
+		return "", err
+	}
+	for queueName := range hq {
+		queue := e.queues[queueName]
+		if q, ok := queue.(DelayQueue); ok {
+			if err := q.EnqueueAt(id, data, t); err != nil {
+				return "", err
+			}
+			atomic.AddUint64(&e.queueMetrics(queueName).enqueued, 1)
+			continue
+		}
+		e.schedule(id, queueName, name, data, t)
+	}
+	return id, nil
+}
+
+// TriggerAfter emits the event after d.
+// It returns the ID of the scheduled event that can be used to Cancel.
+func (e *Event) TriggerAfter(d time.Duration, name string, args ...interface{}) (id string, err error) {
+	return e.TriggerAt(util.Now().Add(d), name, args...)
+}
+
+// TriggerAfterMeta is similar to TriggerAfter, but it emits the event with
+// meta. The meta will be passed to handlers added by AddMetaHandler.
+func (e *Event) TriggerAfterMeta(d time.Duration, name string, meta Meta, args ...interface{}) (id string, err error) {
+	return e.TriggerAtMeta(util.Now().Add(d), name, meta, args...)
+}
+
+// Cancel cancels the event of id that has been scheduled by TriggerAt or
+// TriggerAfter.
+// If the event has already been emitted or not exists, it returns
+// ErrNotScheduled.
+func (e *Event) Cancel(id string) error {
+	e.timersMu.Lock()
+	timers := e.timers[id]
+	delete(e.timers, id)
+	e.timersMu.Unlock()
+	var canceled bool
+	for _, timer := range timers {
+		if timer.Stop() {
+			canceled = true
+		}
+	}
+	for _, queue := range e.queues {
+		q, ok := queue.(DelayQueue)
+		if !ok {
+			continue
+		}
+		switch err := q.Cancel(id); err {
+		case nil:
+			canceled = true
+		case ErrNotScheduled:
+		default:
+			return err
+		}
+	}
+	if !canceled {
+		return ErrNotScheduled
+	}
+	return nil
+}
+
+// schedule enqueues data of the event of name to the queue at t by the timer
+// in memory.
+func (e *Event) schedule(id string, queueName string, name string, data string, t time.Time) {
+	queue := e.queues[queueName]
+	e.timersMu.Lock()
+	defer e.timersMu.Unlock()
+	if e.timers == nil {
+		e.timers = make(map[string][]*time.Timer)
+	}
+	e.timers[id] = append(e.timers[id], time.AfterFunc(t.Sub(util.Now()), func() {
+		e.timersMu.Lock()
+		delete(e.timers, id)
+		e.timersMu.Unlock()
+		if err := e.enqueueData(queue, name, data); err != nil {
+			if e.ErrorHandler != nil {
+				e.ErrorHandler(err)
+			}
+			return
+		}
+		atomic.AddUint64(&e.queueMetrics(queueName).enqueued, 1)
+	}))
+}
+
+// stopTimers stops the timers of the scheduled events.
+func (e *Event) stopTimers() {
+	e.timersMu.Lock()
+	defer e.timersMu.Unlock()
+	for id, timers := range e.timers {
+		for _, timer := range timers {
+			timer.Stop()
+		}
+		delete(e.timers, id)
+	}
+}
@@ -0,0 +1,174 @@
+package event
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/naoina/kocha/util"
+)
+
+// ErrStopped is returned by Trigger if the event is stopping.
+var ErrStopped = errors.New("event is stopped")
+
+// Done is shorthand of the DefaultEvent.Done.
+func Done() <-chan struct{} {
+	return DefaultEvent.Done()
+}
+
+// StopDeadline is shorthand of the DefaultEvent.StopDeadline.
+func StopDeadline(deadline time.Time) []*Abandoned {
+	return DefaultEvent.StopDeadline(deadline)
+}
+
+// Abandoned represents a payload that the handlers haven't completed by the
+// deadline of StopDeadline.
+type Abandoned struct {
+	Name  string        // event name.
+	Queue string        // queue name.
+	Args  []interface{} // arguments of the event.
+	Meta  Meta          // metadata of the event.
+
+	// Err is the error of the nack of the lease.
+	// If Err is nil, the payload has been handed back to the queue and will be
+	// delivered again if the queue implements AckQueue. Otherwise, the payload
+	// has been lost.
+	Err error
+}
+
+// inflight represents a payload that is being processed by the handlers.
+type inflight struct {
+	queueName string
+	pld       payload
+	lease     Lease
+}
+
+// Done returns a channel that is closed when Stop or StopDeadline is called.
+// The long-running handlers should watch it to return promptly, because the
+// handlers that haven't completed by the deadline will be abandoned.
+func (e *Event) Done() <-chan struct{} {
+	e.doneMu.Lock()
+	defer e.doneMu.Unlock()
+	if e.done == nil {
+		e.done = make(chan struct{})
+	}
+	return e.done
+}
+
+// StopDeadline is similar to Stop, but it waits for the workers to complete
+// until deadline. If deadline is zero, it waits for them forever.
+//
+// After StopDeadline is called, Trigger returns ErrStopped and the channel
+// returned by Done is closed. The payloads that the handlers haven't
+// completed by deadline are nacked to be delivered again, and they will be
+// returned as abandoned. The handlers of the abandoned payloads keep running
+// in the background, but their results will be ignored.
+func (e *Event) StopDeadline(deadline time.Time) (abandoned []*Abandoned) {
+	atomic.StoreUint32(&e.stopping, 1)
+	e.doneMu.Lock()
+	if e.done == nil {
+		e.done = make(chan struct{})
+	}
+	select {
+	case <-e.done:
+	default:
+		close(e.done)
+	}
+	e.doneMu.Unlock()
+	e.stopTimers()
+	defer func() {
+		e.workers = nil
+	}()
+	enqueued := waitUntil(&e.wg.enqueue, deadline)
+	for _, worker := range e.workers {
+		worker.stop()
+	}
+	if !enqueued || !waitUntil(&e.wg.dequeue, deadline) {
+		return e.abandon()
+	}
+	return nil
+}
+
+// stopped reports whether the event is stopping.
+func (e *Event) stopped() bool {
+	return atomic.LoadUint32(&e.stopping) == 1
+}
+
+// restart resets the stopping state for Start.
+func (e *Event) restart() {
+	e.doneMu.Lock()
+	defer e.doneMu.Unlock()
+	if e.done != nil {
+		select {
+		case <-e.done:
+			e.done = nil
+		default:
+		}
+	}
+	atomic.StoreUint32(&e.stopping, 0)
+}
+
+// begin registers the payload that starts to be processed.
+func (e *Event) begin(queueName string, pld payload, lease Lease) *inflight {
+	f := &inflight{queueName: queueName, pld: pld, lease: lease}
+	e.inflightMu.Lock()
+	defer e.inflightMu.Unlock()
+	if e.inflight == nil {
+		e.inflight = make(map[*inflight]struct{})
+	}
+	e.inflight[f] = struct{}{}
+	return f
+}
+
+// end unregisters f. It reports false if f has already been abandoned.
+func (e *Event) end(f *inflight) bool {
+	e.inflightMu.Lock()
+	defer e.inflightMu.Unlock()
+	if _, exist := e.inflight[f]; !exist {
+		return false
+	}
+	delete(e.inflight, f)
+	return true
+}
+
+// abandon nacks the leases of the payloads in progress and returns them.
+func (e *Event) abandon() []*Abandoned {
+	e.inflightMu.Lock()
+	inflights := e.inflight
+	e.inflight = nil
+	e.inflightMu.Unlock()
+	abandoned := make([]*Abandoned, 0, len(inflights))
+	for f := range inflights {
+		abandoned = append(abandoned, &Abandoned{
+			Name:  f.pld.Name,
+			Queue: f.queueName,
+			Args:  f.pld.Args,
+			Meta:  f.pld.Meta,
+			Err:   f.lease.Nack(),
+		})
+	}
+	return abandoned
+}
+
+// waitUntil waits for wg until deadline. It reports whether wg has completed.
+// If deadline is zero, it waits for wg forever.
+func waitUntil(wg *sync.WaitGroup, deadline time.Time) bool {
+	if deadline.IsZero() {
+		wg.Wait()
+		return true
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(deadline.Sub(util.Now()))
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
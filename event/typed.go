@@ -0,0 +1,134 @@
+package event
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// RegisterType is shorthand of the DefaultEvent.RegisterType.
+func RegisterType(name string, v interface{}) error {
+	return DefaultEvent.RegisterType(name, v)
+}
+
+// AddTypedHandler is shorthand of the DefaultEvent.AddTypedHandler.
+func AddTypedHandler(name string, queueName string, handler interface{}) error {
+	return DefaultEvent.AddTypedHandler(name, queueName, handler)
+}
+
+var (
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+	metaType  = reflect.TypeOf(Meta(nil))
+)
+
+// RegisterType registers the type of v as the argument type of the event of
+// name.
+// The argument of the typed event is encoded by Codec with its type name, and
+// it will be decoded into the value of the registered type before calling the
+// handlers, so that the handlers receive the argument as the same type. The
+// typed event must be emitted with just one argument of the type or the
+// pointer to it.
+func (e *Event) RegisterType(name string, v interface{}) error {
+	if v == nil {
+		return fmt.Errorf("kocha: event: type of event `%s' is nil", name)
+	}
+	return e.registerType(name, reflect.TypeOf(v))
+}
+
+func (e *Event) registerType(name string, t reflect.Type) error {
+	if t.Kind() == reflect.Interface {
+		return fmt.Errorf("kocha: event: type of event `%s' must be a concrete type, but %v", name, t)
+	}
+	if registered, exist := e.types[name]; exist {
+		if registered != t {
+			return fmt.Errorf("kocha: event: type of event `%s' is already registered as %v", name, registered)
+		}
+		return nil
+	}
+	if e.types == nil {
+		e.types = make(map[string]reflect.Type)
+	}
+	e.types[name] = t
+	return nil
+}
+
+// AddTypedHandler adds the handler of the typed event.
+// The handler must be a function such as func(v T) error or
+// func(meta Meta, v T) error, and T will be registered as the argument type of
+// the event by RegisterType.
+func (e *Event) AddTypedHandler(name string, queueName string, handler interface{}) error {
+	hv := reflect.ValueOf(handler)
+	ht := hv.Type()
+	if ht.Kind() != reflect.Func || ht.NumOut() != 1 || ht.Out(0) != errorType ||
+		!(ht.NumIn() == 1 || ht.NumIn() == 2 && ht.In(0) == metaType) {
+		return fmt.Errorf("kocha: event: handler of event `%s' must be func(T) error or func(event.Meta, T) error, but %v", name, ht)
+	}
+	t := ht.In(ht.NumIn() - 1)
+	if err := e.registerType(name, t); err != nil {
+		return err
+	}
+	return e.AddRetryHandler(name, queueName, RetryPolicy{}, func(attempt int, meta Meta, args ...interface{}) error {
+		in := make([]reflect.Value, 0, 2)
+		if ht.NumIn() == 2 {
+			in = append(in, reflect.ValueOf(meta))
+		}
+		v := reflect.Zero(t)
+		if len(args) > 0 && args[0] != nil {
+			v = reflect.ValueOf(args[0])
+		}
+		out := hv.Call(append(in, v))
+		if err := out[0].Interface(); err != nil {
+			return err.(error)
+		}
+		return nil
+	})
+}
+
+// newPayload returns the payload of the event.
+// If the type of the event is registered, the argument will be encoded by
+// Codec.
+func (e *Event) newPayload(name string, meta Meta, args []interface{}) (payload, error) {
+	pld := payload{Name: name, Args: args, Meta: meta}
+	t, typed := e.types[name]
+	if !typed {
+		return pld, nil
+	}
+	if len(args) != 1 || args[0] == nil || indirectType(reflect.TypeOf(args[0])) != indirectType(t) {
+		return pld, fmt.Errorf("kocha: event: event `%s' requires an argument of %v", name, t)
+	}
+	c := e.codec()
+	data, err := c.Marshal(args[0])
+	if err != nil {
+		return pld, err
+	}
+	pld.Args = nil
+	pld.Type, pld.Codec, pld.Data = t.String(), c.Name(), data
+	return pld, nil
+}
+
+// decodeArgs decodes the typed argument of pld into pld.Args.
+func (e *Event) decodeArgs(pld *payload) error {
+	if pld.Type == "" {
+		return nil
+	}
+	t, typed := e.types[pld.Name]
+	if !typed || t.String() != pld.Type {
+		return fmt.Errorf("kocha: event: type of event `%s' is %v, but payload has %s", pld.Name, t, pld.Type)
+	}
+	c, err := e.codecByName(pld.Codec)
+	if err != nil {
+		return err
+	}
+	v := reflect.New(t)
+	if err := c.Unmarshal(pld.Data, v.Interface()); err != nil {
+		return err
+	}
+	pld.Args = []interface{}{v.Elem().Interface()}
+	return nil
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,119 @@
+package event
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDuplicate is returned by TriggerUnique if the event of the same key is
+// already pending or running, and it hasn't been enqueued again.
+var ErrDuplicate = errors.New("duplicate unique event")
+
+// TriggerUnique is shorthand of the DefaultEvent.TriggerUnique.
+func TriggerUnique(u Unique, name string, args ...interface{}) error {
+	return DefaultEvent.TriggerUnique(u, name, args...)
+}
+
+// TriggerUniqueMeta is shorthand of the DefaultEvent.TriggerUniqueMeta.
+func TriggerUniqueMeta(u Unique, name string, meta Meta, args ...interface{}) error {
+	return DefaultEvent.TriggerUniqueMeta(u, name, meta, args...)
+}
+
+// UniquePolicy represents the behavior of TriggerUnique when the event of the
+// same key already exists.
+type UniquePolicy int
+
+// The policies of the unique events.
+const (
+	// UniqueSkip doesn't enqueue the new event.
+	UniqueSkip UniquePolicy = iota
+
+	// UniqueReplace replaces the arguments of the pending event with the new
+	// ones. If the event is already running, the new event will be enqueued.
+	UniqueReplace
+
+	// UniqueExtend doesn't enqueue the new event, but extends the window of
+	// the existing event to Window from now.
+	UniqueExtend
+)
+
+// Unique represents the uniqueness of the event.
+//
+// The event of Key is considered to exist while it is pending, and while it is
+// running until Window from the time it has been triggered. If Window is 0,
+// it exists until the handlers complete. The existence is released when the
+// lease of the event is acknowledged.
+type Unique struct {
+	Key    string        // key of the uniqueness within the event name.
+	Policy UniquePolicy  // behavior for the duplicate event.
+	Window time.Duration // maximum duration to consider the running event exists.
+}
+
+// UniqueQueue is the interface that is implemented by the queue that supports
+// the unique events.
+type UniqueQueue interface {
+	Queue
+
+	// EnqueueUnique adds data to the queue unless the data of u.Key exists.
+	// If the data isn't enqueued in accordance with u.Policy, it returns
+	// ErrDuplicate. It is called by the caller of TriggerUnique, so it must
+	// not block until the queue has room for the data.
+	EnqueueUnique(u Unique, data string) error
+}
+
+// TriggerUnique is similar to Trigger, but it doesn't emit the event if the
+// event of u.Key already exists in the queues. All queues of the handlers of
+// the event must implement UniqueQueue.
+// If the event hasn't been enqueued to any queue because of the duplication,
+// it returns ErrDuplicate.
+func (e *Event) TriggerUnique(u Unique, name string, args ...interface{}) error {
+	return e.TriggerUniqueMeta(u, name, nil, args...)
+}
+
+// TriggerUniqueMeta is similar to TriggerUnique, but it emits the event with
+// meta. The meta will be passed to handlers added by AddMetaHandler.
+func (e *Event) TriggerUniqueMeta(u Unique, name string, meta Meta, args ...interface{}) error {
+	if e.stopped() {
+		return ErrStopped
+	}
+	hq, exist := e.handlerQueues[name]
+	if !exist {
+		return fmt.Errorf("kocha: event: handler `%s' isn't added", name)
+	}
+	if u.Key == "" {
+		return fmt.Errorf("kocha: event: key of the unique event `%s' is empty", name)
+	}
+	for queueName := range hq {
+		if _, ok := e.queues[queueName].(UniqueQueue); !ok {
+			return fmt.Errorf("kocha: event: queue `%s' doesn't support the unique events", queueName)
+		}
+	}
+	pld, err := e.newPayload(name, meta, args)
+	if err != nil {
+		return err
+	}
+	var data string
+	if err := pld.encode(&data); err != nil {
+		return err
+	}
+	u.Key = name + ":" + u.Key
+	e.wg.enqueue.Add(1)
+	defer e.wg.enqueue.Done()
+	var duplicated bool
+	for queueName := range hq {
+		switch err := e.queues[queueName].(UniqueQueue).EnqueueUnique(u, data); err {
+		case nil:
+			atomic.AddUint64(&e.queueMetrics(queueName).enqueued, 1)
+		case ErrDuplicate:
+			duplicated = true
+		default:
+			return err
+		}
+	}
+	if duplicated {
+		return ErrDuplicate
+	}
+	return nil
+}
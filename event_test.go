@@ -0,0 +1,142 @@
+package kocha
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/naoina/kocha/event"
+	"github.com/naoina/kocha/event/memory"
+)
+
+type testEventError struct {
+	msg string
+}
+
+func (e *testEventError) Error() string {
+	return e.msg
+}
+
+func TestEvent_ErrorHandler(t *testing.T) {
+	errCh := make(chan interface{}, 1)
+	e, err := (&Event{
+		HandlerMap: EventHandlerMap{
+			&memory.EventQueue{}: {
+				"testEvent": func(app *Application, args ...interface{}) error {
+					return &testEventError{msg: "test error"}
+				},
+			},
+		},
+		WorkersPerQueue: 1,
+		ErrorHandler: func(err interface{}) {
+			errCh <- err
+		},
+	}).build(&Application{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.start()
+	defer e.stop()
+	for _, v := range []struct {
+		trigger func() error
+		expect  interface{}
+	}{
+		{func() error {
+			return e.Trigger("testEvent")
+		}, &testEventError{msg: "test error"}},
+		{func() error {
+			return e.TriggerContext(&Context{RequestID: "abc"}, "testEvent")
+		}, &EventError{Name: "testEvent", RequestID: "abc", Attempt: 1, Err: &testEventError{msg: "test error"}}},
+		{func() error {
+			_, err := e.TriggerAtContext(&Context{RequestID: "at"}, time.Now(), "testEvent")
+			return err
+		}, &EventError{Name: "testEvent", RequestID: "at", Attempt: 1, Err: &testEventError{msg: "test error"}}},
+		{func() error {
+			_, err := e.TriggerAfterContext(&Context{RequestID: "after"}, 0, "testEvent")
+			return err
+		}, &EventError{Name: "testEvent", RequestID: "after", Attempt: 1, Err: &testEventError{msg: "test error"}}},
+		{func() error {
+			return e.TriggerUniqueContext(&Context{RequestID: "unique"}, event.Unique{Key: "a"}, "testEvent")
+		}, &EventError{Name: "testEvent", RequestID: "unique", Attempt: 1, Err: &testEventError{msg: "test error"}}},
+		{func() error {
+			_, err := e.TriggerAt(time.Now(), "testEvent")
+			return err
+		}, &testEventError{msg: "test error"}},
+	} {
+		if err := v.trigger(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case actual := <-errCh:
+			if !reflect.DeepEqual(actual, v.expect) {
+				t.Errorf("ErrorHandler called with %#v; want %#v", actual, v.expect)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("ErrorHandler hasn't been called within 3 seconds")
+		}
+	}
+	actual := (&EventError{Err: fmt.Errorf("original")}).Unwrap()
+	expected := fmt.Errorf("original")
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("EventError.Unwrap() => %#v; want %#v", actual, expected)
+	}
+}
+
+func TestEvent_RetryHandlerMap(t *testing.T) {
+	queue := &memory.EventQueue{}
+	attempts := make(chan int, 3)
+	called := make(chan struct{}, 1)
+	e, err := (&Event{
+		HandlerMap: EventHandlerMap{
+			queue: {
+				"testEvent": func(app *Application, args ...interface{}) error {
+					called <- struct{}{}
+					return nil
+				},
+			},
+		},
+		RetryHandlerMap: EventRetryHandlerMap{
+			queue: {
+				"testEvent": func(app *Application, attempt int, args ...interface{}) error {
+					attempts <- attempt
+					if attempt < 3 {
+						return &testEventError{msg: "test error"}
+					}
+					return nil
+				},
+			},
+		},
+		RetryPolicies: map[string]event.RetryPolicy{
+			"testEvent": {MaxAttempts: 3, Backoff: time.Millisecond},
+		},
+		WorkersPerQueue: 1,
+		ErrorHandler:    func(err interface{}) {},
+	}).build(&Application{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.start()
+	defer e.stop()
+	if err := e.Trigger("testEvent"); err != nil {
+		t.Fatal(err)
+	}
+	var actual []int
+	for len(actual) < 3 {
+		select {
+		case attempt := <-attempts:
+			actual = append(actual, attempt)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("handler of RetryHandlerMap hasn't been called 3 times within 3 seconds; called with %#v", actual)
+		}
+	}
+	expected := []int{1, 2, 3}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("handler of RetryHandlerMap called with attempts %#v; want %#v", actual, expected)
+	}
+	select {
+	case <-called:
+	case <-time.After(3 * time.Second):
+		t.Errorf("handler of HandlerMap hasn't been called within 3 seconds")
+	}
+}
@@ -1,35 +1,121 @@
 package kocha
 
+import "sort"
+
+// The keys of flash messages for typical levels.
+const (
+	FlashSuccess = "success"
+	FlashInfo    = "info"
+	FlashWarning = "warning"
+	FlashError   = "error"
+)
+
 // Flash represents a container of flash messages.
 // Flash is for the one-time messaging between requests. It useful for
 // implementing the Post/Redirect/Get pattern.
+//
+// A key of Flash usually is a level of messages such as FlashSuccess, and
+// each key can hold multiple messages.
 type Flash map[string]FlashData
 
 // Get gets a value associated with the given key.
 // If there is the no value associated with the key, Get returns "".
+// If multiple messages are associated with the key, Get returns the first one.
 func (f Flash) Get(key string) string {
-	if f == nil {
-		return ""
-	}
-	data, exists := f[key]
-	if !exists {
+	msgs := f.Messages(key)
+	if len(msgs) == 0 {
 		return ""
 	}
-	data.Loaded = true
-	f[key] = data
-	return data.Data
+	return msgs[0].Text
 }
 
 // Set sets the value associated with key.
-// It replaces the existing value associated with key.
+// It replaces the existing values associated with key.
 func (f Flash) Set(key, value string) {
 	if f == nil {
 		return
 	}
-	data := f[key]
-	data.Loaded = false
-	data.Data = value
+	f[key] = FlashData{
+		Messages: []FlashMessage{{Text: value}},
+	}
+}
+
+// Add adds the value to key.
+// It appends to any existing values associated with key.
+func (f Flash) Add(key, value string) {
+	f.AddMessage(key, FlashMessage{Text: value})
+}
+
+// AddMessage adds the message to key.
+// It is similar to Add, but msg can have the structured data.
+func (f Flash) AddMessage(key string, msg FlashMessage) {
+	f.add(key, msg, false)
+}
+
+// Now adds the value to key for the current request only.
+// The value won't be saved to the session, so it's useful for messages that
+// should be rendered without redirect.
+func (f Flash) Now(key, value string) {
+	f.NowMessage(key, FlashMessage{Text: value})
+}
+
+// NowMessage adds the message to key for the current request only.
+// It is similar to Now, but msg can have the structured data.
+func (f Flash) NowMessage(key string, msg FlashMessage) {
+	f.add(key, msg, true)
+}
+
+// Messages returns the messages associated with the given key.
+// The returned messages will be deleted at the end of the current request
+// unless Keep is called.
+func (f Flash) Messages(key string) []FlashMessage {
+	if f == nil {
+		return nil
+	}
+	data, exists := f[key]
+	if !exists {
+		return nil
+	}
+	data.Loaded = true
+	data.loaded = len(data.Messages)
 	f[key] = data
+	return data.Messages
+}
+
+// All returns the all pending messages that sorted by key.
+// The returned messages will be deleted at the end of the current request
+// unless Keep is called.
+func (f Flash) All() []FlashEntry {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var entries []FlashEntry
+	for _, k := range keys {
+		for _, msg := range f.Messages(k) {
+			entries = append(entries, FlashEntry{Key: k, FlashMessage: msg})
+		}
+	}
+	return entries
+}
+
+// Keep keeps the messages associated with the given keys until the next
+// request even if they have been loaded.
+// If no keys are given, Keep keeps all messages.
+// Messages added by Now won't be kept.
+func (f Flash) Keep(keys ...string) {
+	if len(keys) == 0 {
+		for k := range f {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range keys {
+		if data, exists := f[k]; exists {
+			data.kept = true
+			f[k] = data
+		}
+	}
 }
 
 // Len returns a length of the dataset.
@@ -37,17 +123,55 @@ func (f Flash) Len() int {
 	return len(f)
 }
 
-// deleteLoaded delete the loaded data.
+func (f Flash) add(key string, msg FlashMessage, now bool) {
+	if f == nil {
+		return
+	}
+	msg.now = now
+	data := f[key]
+	data.Loaded = false
+	data.Messages = append(data.Messages, msg)
+	f[key] = data
+}
+
+// deleteLoaded delete the loaded messages and the messages for the current
+// request only. The kept messages will be restored to not loaded.
 func (f Flash) deleteLoaded() {
 	for k, v := range f {
-		if v.Loaded {
+		msgs := v.Messages[:0]
+		for i, msg := range v.Messages {
+			if msg.now || (i < v.loaded && !v.kept) {
+				continue
+			}
+			msgs = append(msgs, msg)
+		}
+		if len(msgs) == 0 {
 			delete(f, k)
+			continue
 		}
+		f[k] = FlashData{Messages: msgs}
 	}
 }
 
 // FlashData represents a data of flash messages.
 type FlashData struct {
-	Data   string // flash message.
-	Loaded bool   // whether the message was loaded.
+	Messages []FlashMessage // flash messages.
+	Loaded   bool           // whether all messages were loaded.
+
+	loaded int // number of the messages that were loaded.
+	kept   bool
+}
+
+// FlashMessage represents a flash message.
+type FlashMessage struct {
+	Text string            // message text.
+	Data map[string]string // structured data of the message (optional).
+
+	now bool
+}
+
+// FlashEntry represents a flash message with its key.
+type FlashEntry struct {
+	Key string // key of the message such as FlashSuccess.
+	FlashMessage
 }
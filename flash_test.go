@@ -78,3 +78,74 @@ func TestFlash_Set_withNil(t *testing.T) {
 		t.Errorf(`Flash.Set(%#v, %#v); Flash.Len() => %#v; want %#v`, key, value, actual, expected)
 	}
 }
+
+func TestFlash_Add(t *testing.T) {
+	f := kocha.Flash{}
+	f.Add(kocha.FlashError, "first")
+	f.Add(kocha.FlashError, "second")
+	f.AddMessage(kocha.FlashError, kocha.FlashMessage{Text: "third", Data: map[string]string{"field": "name"}})
+	var actual interface{} = f.Len()
+	var expected interface{} = 1
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`Flash.Add(...); Flash.Len() => %#v; want %#v`, actual, expected)
+	}
+
+	actual = f.Get(kocha.FlashError)
+	expected = "first"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`Flash.Add(...); Flash.Get(%#v) => %#v; want %#v`, kocha.FlashError, actual, expected)
+	}
+
+	actual = f.Messages(kocha.FlashError)
+	expected = []kocha.FlashMessage{
+		{Text: "first"},
+		{Text: "second"},
+		{Text: "third", Data: map[string]string{"field": "name"}},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`Flash.Add(...); Flash.Messages(%#v) => %#v; want %#v`, kocha.FlashError, actual, expected)
+	}
+
+	f.Set(kocha.FlashError, "replaced")
+	actual = f.Messages(kocha.FlashError)
+	expected = []kocha.FlashMessage{{Text: "replaced"}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`Flash.Set(...); Flash.Messages(%#v) => %#v; want %#v`, kocha.FlashError, actual, expected)
+	}
+}
+
+func TestFlash_Add_withNil(t *testing.T) {
+	f := kocha.Flash(nil)
+	f.Add(kocha.FlashInfo, "test_value")
+	f.Now(kocha.FlashInfo, "test_value")
+	f.Keep()
+	actual := f.Len()
+	expected := 0
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`Flash.Add(...); Flash.Len() => %#v; want %#v`, actual, expected)
+	}
+}
+
+func TestFlash_All(t *testing.T) {
+	f := kocha.Flash{}
+	f.Add(kocha.FlashWarning, "warn1")
+	f.Add(kocha.FlashInfo, "info1")
+	f.Now(kocha.FlashWarning, "warn2")
+	actual := f.All()
+	expected := []kocha.FlashEntry{
+		{Key: kocha.FlashInfo, FlashMessage: kocha.FlashMessage{Text: "info1"}},
+		{Key: kocha.FlashWarning, FlashMessage: kocha.FlashMessage{Text: "warn1"}},
+		{Key: kocha.FlashWarning, FlashMessage: f[kocha.FlashWarning].Messages[1]},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`Flash.All() => %#v; want %#v`, actual, expected)
+	}
+	if actual[2].Text != "warn2" {
+		t.Errorf(`Flash.All()[2].Text => %#v; want %#v`, actual[2].Text, "warn2")
+	}
+	for k, v := range f {
+		if !v.Loaded {
+			t.Errorf(`Flash.All(); Flash[%#v].Loaded => %#v; want %#v`, k, v.Loaded, true)
+		}
+	}
+}
@@ -3,10 +3,11 @@ package kocha
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"reflect"
-	"runtime"
+	"strings"
 	"sync"
 
 	"github.com/joho/godotenv"
@@ -63,8 +64,11 @@ func Run(config *Config) error {
 		Addr:    config.Addr,
 		Handler: app,
 	}
+	defer app.flushLogger()
 	app.Event.start()
 	defer app.Event.stop()
+	app.Scheduler.start()
+	defer app.Scheduler.stop()
 	return server.ListenAndServe()
 }
 
@@ -86,11 +90,15 @@ type Application struct {
 	// Event is an interface of the event system.
 	Event *Event
 
+	// Scheduler is the scheduler of the periodic jobs.
+	Scheduler *Scheduler
+
 	// ResourceSet is set of resource of an application.
 	ResourceSet ResourceSet
 
-	failedUnits map[string]struct{}
-	mu          sync.RWMutex
+	failedUnits    map[string]struct{}
+	trustedProxies trustedProxies
+	mu             sync.RWMutex
 }
 
 // New returns a new Application that configured by config.
@@ -108,6 +116,9 @@ func New(config *Config) (*Application, error) {
 	if err := app.validateMiddlewares(); err != nil {
 		return nil, err
 	}
+	if err := app.buildTrustedProxies(); err != nil {
+		return nil, err
+	}
 	if err := app.buildResourceSet(); err != nil {
 		return nil, err
 	}
@@ -123,6 +134,9 @@ func New(config *Config) (*Application, error) {
 	if err := app.buildEvent(); err != nil {
 		return nil, err
 	}
+	if err := app.buildScheduler(); err != nil {
+		return nil, err
+	}
 	return app, nil
 }
 
@@ -130,18 +144,19 @@ func New(config *Config) (*Application, error) {
 func (app *Application) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	c := newContext()
 	c.Layout = app.Config.DefaultLayout
-	c.Request = newRequest(r)
+	c.Request = newRequest(r, app.trustedProxies)
 	c.Response = newResponse()
 	c.App = app
+	c.Logger = app.Logger
 	c.Errors = make(map[string][]*ParamError)
 	defer c.reuse()
 	defer func() {
 		if err := c.Response.writeTo(w); err != nil {
-			app.Logger.Error(err)
+			app.logger(c).Error(err)
 		}
 	}()
 	if err := app.wrapMiddlewares(c)(); err != nil {
-		app.Logger.Error(err)
+		app.logger(c).Error(err)
 		c.Response.reset()
 		http.Error(c.Response, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
@@ -156,7 +171,7 @@ func (app *Application) Invoke(unit Unit, newFunc func(), defaultFunc func()) {
 	defer func() {
 		if err := recover(); err != nil {
 			if err != ErrInvokeDefault {
-				app.logStackAndError(err)
+				logStackAndError(app.Logger, err)
 				app.mu.Lock()
 				app.failedUnits[name] = struct{}{}
 				app.mu.Unlock()
@@ -180,6 +195,11 @@ func (app *Application) buildRouter() (err error) {
 	return err
 }
 
+func (app *Application) buildTrustedProxies() (err error) {
+	app.trustedProxies, err = parseTrustedProxies(app.Config.TrustedProxies)
+	return err
+}
+
 func (app *Application) buildResourceSet() error {
 	app.ResourceSet = app.Config.ResourceSet
 	return nil
@@ -194,21 +214,108 @@ func (app *Application) buildLogger() error {
 	if app.Config.Logger == nil {
 		app.Config.Logger = &LoggerConfig{}
 	}
-	if app.Config.Logger.Writer == nil {
-		app.Config.Logger.Writer = os.Stdout
+	config := app.Config.Logger
+	switch {
+	case len(config.Sinks) > 0:
+		sinks := make([]log.Sink, len(config.Sinks))
+		for i, sink := range config.Sinks {
+			if sink.Writer == nil {
+				sink.Writer = os.Stdout
+			}
+			if sink.Formatter == nil {
+				sink.Formatter = &log.LTSVFormatter{}
+			}
+			sinks[i] = sink
+		}
+		app.Logger = log.NewMulti(sinks, config.Hooks...)
+	default:
+		if config.Writer == nil {
+			config.Writer = os.Stdout
+		}
+		if config.Formatter == nil {
+			config.Formatter = &log.LTSVFormatter{}
+		}
+		if len(config.Hooks) > 0 {
+			app.Logger = log.NewMulti([]log.Sink{{Writer: config.Writer, Formatter: config.Formatter}}, config.Hooks...)
+			app.Logger.SetLevel(config.Level)
+		} else {
+			app.Logger = log.New(config.Writer, config.Formatter, config.Level)
+		}
+	}
+	log.SetReportCaller(app.Logger, config.ReportCaller)
+	log.SetSampler(app.Logger, config.Sampler)
+	return app.setLogLevels()
+}
+
+// setLogLevels sets the levels of the loggers from LoggerConfig.Levels and the
+// environment variables. See LoggerConfig.Levels for details.
+func (app *Application) setLogLevels() error {
+	levels := make(map[string]log.Level, len(app.Config.Logger.Levels))
+	for name, level := range app.Config.Logger.Levels {
+		levels[name] = level
 	}
-	if app.Config.Logger.Formatter == nil {
-		app.Config.Logger.Formatter = &log.LTSVFormatter{}
+	if env := Getenv("KOCHA_LOG_LEVEL", ""); env != "" {
+		level, err := log.ParseLevel(env)
+		if err != nil {
+			return fmt.Errorf("kocha: KOCHA_LOG_LEVEL: %v", err)
+		}
+		levels[""] = level
+	}
+	for _, pair := range strings.Split(Getenv("KOCHA_LOG_LEVELS", ""), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("kocha: KOCHA_LOG_LEVELS: invalid format: %v", pair)
+		}
+		level, err := log.ParseLevel(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return fmt.Errorf("kocha: KOCHA_LOG_LEVELS: %v", err)
+		}
+		levels[strings.TrimSpace(kv[0])] = level
+	}
+	for name, level := range levels {
+		app.namedLogger(name).SetLevel(level)
 	}
-	app.Logger = log.New(app.Config.Logger.Writer, app.Config.Logger.Formatter, app.Config.Logger.Level)
 	return nil
 }
 
+// namedLogger returns the child logger of app.Logger by name.
+// If name is empty, it returns app.Logger.
+func (app *Application) namedLogger(name string) log.Logger {
+	if name == "" {
+		return app.Logger
+	}
+	return log.Named(app.Logger, name)
+}
+
+// flushLogger flushes the buffered logs if the writers of the logger
+// implement log.Flusher.
+func (app *Application) flushLogger() {
+	writers := []io.Writer{app.Config.Logger.Writer}
+	for _, sink := range app.Config.Logger.Sinks {
+		writers = append(writers, sink.Writer)
+	}
+	for _, w := range writers {
+		if f, ok := w.(log.Flusher); ok {
+			if err := f.Flush(); err != nil {
+				fmt.Fprintf(os.Stderr, "kocha: failed to flush the logger: %v\n", err)
+			}
+		}
+	}
+}
+
 func (app *Application) buildEvent() (err error) {
 	app.Event, err = app.Config.Event.build(app)
 	return err
 }
 
+func (app *Application) buildScheduler() (err error) {
+	app.Scheduler, err = app.Config.Scheduler.build(app)
+	return err
+}
+
 func (app *Application) validateMiddlewares() error {
 	for _, m := range app.Config.Middlewares {
 		if v, ok := m.(Validator); ok {
@@ -231,10 +338,19 @@ func (app *Application) wrapMiddlewares(c *Context) func() error {
 	return wrapped
 }
 
-func (app *Application) logStackAndError(err interface{}) {
-	buf := make([]byte, 4096)
-	n := runtime.Stack(buf, false)
-	app.Logger.Errorf("%v\n%s", err, buf[:n])
+// logger returns the logger for the request of c.
+// It returns app.Logger if c doesn't have the logger.
+func (app *Application) logger(c *Context) log.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return app.Logger
+}
+
+// logStackAndError outputs err with the stack trace as the structured fields.
+func logStackAndError(logger log.Logger, err interface{}) {
+	serr := log.NewStackError(err)
+	log.WithError(logger, serr).Error(serr)
 }
 
 // Config represents a application-scope configuration.
@@ -248,8 +364,16 @@ type Config struct {
 	Middlewares       []Middleware  // middlewares.
 	Logger            *LoggerConfig // logger config.
 	Event             *Event        // event config.
+	Scheduler         *Scheduler    // scheduler config.
 	MaxClientBodySize int64         // maximum size of request body, DefaultMaxClientBodySize if 0
 
+	// TrustedProxies is the CIDRs or IP addresses of the trusted proxies.
+	// The forwarded headers such as Forwarded, X-Forwarded-For and
+	// X-Forwarded-Proto are used to determine Request.RemoteAddr and
+	// Request.Scheme only if the request came through these proxies.
+	// If empty, the forwarded headers are always ignored.
+	TrustedProxies []string
+
 	ResourceSet ResourceSet
 }
 
@@ -2,6 +2,7 @@ package kocha_test
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -111,6 +112,15 @@ func TestNew(t *testing.T) {
 		}
 	}()
 
+	func() {
+		config := newConfig()
+		config.TrustedProxies = []string{"10.0.0.0/8", "invalid"}
+		_, err := kocha.New(config)
+		if err == nil {
+			t.Errorf(`New(config) with TrustedProxies %#v => %#v; want error`, config.TrustedProxies, err)
+		}
+	}()
+
 	// test for event.
 	func() {
 		config := newConfig()
@@ -178,6 +188,44 @@ func TestNew_buildLogger(t *testing.T) {
 			t.Errorf(`New(...).Config.Logger => %#v; want %#v`, actual, expected)
 		}
 	}()
+
+	func() {
+		os.Setenv("KOCHA_LOG_LEVEL", "warn")
+		os.Setenv("KOCHA_LOG_LEVELS", "kocha.session=debug, myapp = error")
+		defer os.Unsetenv("KOCHA_LOG_LEVEL")
+		defer os.Unsetenv("KOCHA_LOG_LEVELS")
+		config := newConfig()
+		config.Logger.Levels = map[string]log.Level{
+			"":      log.INFO,
+			"kocha": log.ERROR,
+		}
+		app, err := kocha.New(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := []log.Level{
+			app.Logger.Level(),
+			log.Named(app.Logger, "kocha").Level(),
+			log.Named(app.Logger, "kocha.session").Level(),
+			log.Named(app.Logger, "myapp").Level(),
+			log.Named(app.Logger, "other").Level(),
+		}
+		expected := []log.Level{log.WARN, log.ERROR, log.DEBUG, log.ERROR, log.WARN}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf(`New(...) with KOCHA_LOG_LEVEL and KOCHA_LOG_LEVELS; levels of ["", "kocha", "kocha.session", "myapp", "other"] => %v; want %v`, actual, expected)
+		}
+	}()
+
+	func() {
+		os.Setenv("KOCHA_LOG_LEVELS", "kocha.session")
+		defer os.Unsetenv("KOCHA_LOG_LEVELS")
+		_, err := kocha.New(newConfig())
+		var actual interface{} = err
+		var expected interface{} = fmt.Errorf("kocha: KOCHA_LOG_LEVELS: invalid format: kocha.session")
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf(`New(...) with KOCHA_LOG_LEVELS=kocha.session => %#v; want %#v`, actual, expected)
+		}
+	}()
 }
 
 func TestApplication_ServeHTTP(t *testing.T) {
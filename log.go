@@ -8,7 +8,38 @@ import (
 
 // LoggerConfig represents the configuration of the logger.
 type LoggerConfig struct {
-	Writer    io.Writer     // output destination for the logger.
+	// Writer is the output destination for the logger.
+	// If Writer implements log.Flusher such as log.AsyncWriter, it will be
+	// flushed at the shutdown of Run.
+	Writer io.Writer
+
 	Formatter log.Formatter // formatter for log entry.
 	Level     log.Level     // log level.
+
+	// Sinks is the multiple output destinations for the logger.
+	// Each sink has its own Formatter and Level.
+	// If Sinks is specified, Writer, Formatter and Level are ignored.
+	Sinks []log.Sink
+
+	// Levels is the levels of the named loggers such as "kocha.session".
+	// The empty name represents the root logger.
+	//
+	// Also the levels can be overridden by the environment variables.
+	// KOCHA_LOG_LEVEL is the level of the root logger such as "debug", and
+	// KOCHA_LOG_LEVELS is the comma-separated levels of the named loggers such
+	// as "kocha.session=debug,myapp.db=warn".
+	Levels map[string]log.Level
+
+	// ReportCaller specifies whether to report the caller of the log
+	// functions as the structured fields.
+	ReportCaller bool
+
+	// Hooks is the hooks that are invoked for the log entries at or above the
+	// level of each hook, such as forwarding errors to an alerting system.
+	Hooks []log.Hook
+
+	// Sampler is the sampler to suppress the flood of the log entries such as
+	// the same error on every request while a dependency fails.
+	// See log.BasicSampler.
+	Sampler log.Sampler
 }
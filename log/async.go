@@ -0,0 +1,156 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultAsyncBufferSize is the default number of the buffered writes of
+// AsyncWriter.
+const DefaultAsyncBufferSize = 1024
+
+// OverflowPolicy represents the behavior of AsyncWriter when its buffer is
+// full.
+type OverflowPolicy int
+
+// The overflow policies.
+const (
+	// OverflowBlock blocks Write until the buffer has space.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDrop drops the data of Write. The number of dropped writes can be
+	// retrieved by AsyncWriter.Dropped.
+	OverflowDrop
+)
+
+// Flusher is the interface that wraps the Flush method.
+//
+// Flush writes any buffered data to the underlying writer.
+type Flusher interface {
+	Flush() error
+}
+
+// AsyncWriter is an io.WriteCloser that writes to the underlying writer in
+// background.
+//
+// Write copies the data into the bounded buffer and returns immediately, so
+// a slow writer such as a disk won't block the callers. When the buffer is
+// full, the data will be handled according to the OverflowPolicy.
+type AsyncWriter struct {
+	w       io.Writer
+	policy  OverflowPolicy
+	ch      chan asyncWrite
+	done    chan struct{}
+	dropped uint64
+	mu      sync.RWMutex
+	closed  bool
+}
+
+// asyncWrite represents a write request of AsyncWriter.
+// If flushed isn't nil, it is a flush request.
+type asyncWrite struct {
+	p       []byte
+	flushed chan error
+}
+
+// NewAsyncWriter returns a new AsyncWriter that writes to w.
+// The size is the number of the buffered writes. If size is less than 1,
+// DefaultAsyncBufferSize will be used.
+func NewAsyncWriter(w io.Writer, size int, policy OverflowPolicy) *AsyncWriter {
+	if size < 1 {
+		size = DefaultAsyncBufferSize
+	}
+	aw := &AsyncWriter{
+		w:      w,
+		policy: policy,
+		ch:     make(chan asyncWrite, size),
+		done:   make(chan struct{}),
+	}
+	go aw.run()
+	return aw
+}
+
+// Write writes p to the buffer.
+// Write always returns len(p) and nil error except after Close even if p is
+// dropped. The errors of the underlying writer will be output to stderr.
+func (w *AsyncWriter) Write(p []byte) (n int, err error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return 0, errClosed
+	}
+	req := asyncWrite{p: append([]byte(nil), p...)}
+	switch w.policy {
+	case OverflowDrop:
+		select {
+		case w.ch <- req:
+		default:
+			atomic.AddUint64(&w.dropped, 1)
+		}
+	default:
+		w.ch <- req
+	}
+	return len(p), nil
+}
+
+// Flush waits for the buffered data to be written to the underlying writer.
+// Also Flush calls Flush of the underlying writer if it implements Flusher.
+func (w *AsyncWriter) Flush() error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return nil
+	}
+	return w.flush()
+}
+
+// Close flushes the buffered data and stops the background writing.
+// Also Close calls Close of the underlying writer if it implements io.Closer.
+func (w *AsyncWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	err := w.flush()
+	w.closed = true
+	close(w.ch)
+	<-w.done
+	if c, ok := w.w.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Dropped returns the number of dropped writes.
+func (w *AsyncWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+func (w *AsyncWriter) flush() error {
+	flushed := make(chan error, 1)
+	w.ch <- asyncWrite{flushed: flushed}
+	return <-flushed
+}
+
+func (w *AsyncWriter) run() {
+	defer close(w.done)
+	for req := range w.ch {
+		if req.flushed != nil {
+			var err error
+			if f, ok := w.w.(Flusher); ok {
+				err = f.Flush()
+			}
+			req.flushed <- err
+			continue
+		}
+		if _, err := w.w.Write(req.p); err != nil {
+			fmt.Fprintf(os.Stderr, "kocha: log: failed to write log: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package log_test
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/naoina/kocha/log"
+)
+
+type blockingWriter struct {
+	buf     bytes.Buffer
+	mu      sync.Mutex
+	unblock chan struct{}
+	closed  bool
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *blockingWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func (w *blockingWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestAsyncWriter(t *testing.T) {
+	w := &blockingWriter{unblock: make(chan struct{})}
+	close(w.unblock)
+	aw := log.NewAsyncWriter(w, 0, log.OverflowBlock)
+	for _, s := range []string{"first\n", "second\n"} {
+		n, err := aw.Write([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(s) {
+			t.Errorf(`AsyncWriter.Write(%#v) => %#v; want %#v`, s, n, len(s))
+		}
+	}
+	if err := aw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var actual interface{} = w.String()
+	var expect interface{} = "first\nsecond\n"
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`AsyncWriter.Flush(); written => %#v; want %#v`, actual, expect)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	actual = w.closed
+	expect = true
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`AsyncWriter.Close(); underlying writer closed => %#v; want %#v`, actual, expect)
+	}
+	if _, err := aw.Write([]byte("closed")); err == nil {
+		t.Errorf(`AsyncWriter.Write(p) after Close => %#v; want error`, err)
+	}
+}
+
+func TestAsyncWriter_withDrop(t *testing.T) {
+	w := &blockingWriter{unblock: make(chan struct{})}
+	aw := log.NewAsyncWriter(w, 1, log.OverflowDrop)
+	// the first write will be taken by the background writer and blocked,
+	// the second write will be buffered, and others will be dropped.
+	if _, err := aw.Write([]byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	for aw.Dropped() == 0 {
+		if _, err := aw.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(w.unblock)
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var actual interface{} = len(w.String()) <= 2
+	var expect interface{} = true
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`AsyncWriter with OverflowDrop; written => %#v; want at most 2 bytes`, w.String())
+	}
+}
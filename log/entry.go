@@ -2,8 +2,10 @@ package log
 
 import (
 	"fmt"
-	"io"
 	"os"
+	"reflect"
+	"runtime"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,6 +18,37 @@ type Entry struct {
 	Time    time.Time // time of the log event.
 	Message string    // log message (optional).
 	Fields  Fields    // extra fields of the log entry (optional).
+	Caller  *Caller   // caller of the log function (optional).
+}
+
+// Caller represents a location of the caller of the log function.
+type Caller struct {
+	File     string // file name.
+	Line     int    // line number.
+	Function string // function name.
+}
+
+// String returns the file name and line number of the caller.
+func (c *Caller) String() string {
+	return fmt.Sprintf("%s:%d", c.File, c.Line)
+}
+
+// logPackagePrefix is the prefix of the function names in this package.
+var logPackagePrefix = reflect.TypeOf(logger{}).PkgPath() + "."
+
+// callerOf returns the caller outside of this package.
+func callerOf() *Caller {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	for _, pc := range pcs[:n] {
+		fn := runtime.FuncForPC(pc - 1)
+		if fn == nil || strings.HasPrefix(fn.Name(), logPackagePrefix) {
+			continue
+		}
+		file, line := fn.FileLine(pc - 1)
+		return &Caller{File: file, Line: line, Function: fn.Name()}
+	}
+	return nil
 }
 
 // entryLogger implements the Logger interface.
@@ -26,10 +59,14 @@ type entryLogger struct {
 }
 
 func newEntryLogger(logger *logger) *entryLogger {
-	return &entryLogger{
+	l := &entryLogger{
 		logger: logger,
 		entry:  &Entry{},
 	}
+	if logger.name != "" {
+		l.entry.Fields = Fields{"logger": logger.name}
+	}
+	return l
 }
 
 func (l *entryLogger) Debug(v ...interface{}) {
@@ -150,30 +187,86 @@ func (l *entryLogger) Println(v ...interface{}) {
 }
 
 func (l *entryLogger) Output(level Level, message string) {
+	for _, entry := range l.output(level, message) {
+		l.logger.fire(entry)
+	}
+}
+
+// output writes the entry to the sinks with the lock, and returns the
+// entries that have been written in order to fire the hooks.
+func (l *entryLogger) output(level Level, message string) (written []*Entry) {
 	l.logger.mu.Lock()
 	defer l.logger.mu.Unlock()
 	l.entry.Level = level
 	l.entry.Time = util.Now()
 	l.entry.Message = message
-	l.logger.buf.Reset()
-	format := Formatter.Format
-	if int(level) < len(l.logger.formatFuncs) {
-		format = l.logger.formatFuncs[level]
-	}
-	if err := format(l.logger.formatter, &l.logger.buf, l.entry); err != nil {
-		fmt.Fprintf(os.Stderr, "kocha: log: %v\n", err)
+	l.entry.Caller = nil
+	if l.logger.sampler != nil {
+		ok, summaries := l.logger.sampler.Sample(l.entry)
+		for _, entry := range summaries {
+			l.logger.write(entry)
+		}
+		written = append(written, summaries...)
+		if !ok {
+			return written
+		}
 	}
-	l.logger.buf.WriteByte('\n')
-	if _, err := io.Copy(l.logger.out, &l.logger.buf); err != nil {
-		fmt.Fprintf(os.Stderr, "kocha: log: failed to write log: %v\n", err)
+	if l.logger.isReportCaller() {
+		l.entry.Caller = callerOf()
 	}
+	l.logger.write(l.entry)
+	// l.entry will be reused by the next output.
+	entry := *l.entry
+	return append(written, &entry)
 }
 
+// With returns a new Logger that has the fields of l and fields.
+// If the same key exists, fields takes precedence.
 func (l *entryLogger) With(fields Fields) Logger {
 	l.mu.Lock()
-	l.entry.Fields = fields
+	defer l.mu.Unlock()
+	merged := make(Fields, len(l.entry.Fields)+len(fields))
+	for k, v := range l.entry.Fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	el := newEntryLogger(l.logger)
+	el.entry.Fields = merged
+	return el
+}
+
+func (l *entryLogger) WithError(err error) Logger {
+	return l.With(errorFields(err))
+}
+
+// Named returns the child logger that has the fields of l.
+func (l *entryLogger) Named(name string) Logger {
+	l.mu.Lock()
+	fields := make(Fields, len(l.entry.Fields))
+	for k, v := range l.entry.Fields {
+		fields[k] = v
+	}
 	l.mu.Unlock()
-	return l
+	delete(fields, "logger")
+	return newEntryLogger(l.logger.Named(name).(*logger)).With(fields)
+}
+
+// Lookup returns the existing child logger that has the fields of l.
+func (l *entryLogger) Lookup(name string) (Logger, bool) {
+	if _, exists := l.logger.Lookup(name); !exists {
+		return nil, false
+	}
+	return l.Named(name), true
+}
+
+func (l *entryLogger) SetReportCaller(report bool) {
+	l.logger.SetReportCaller(report)
+}
+
+func (l *entryLogger) SetSampler(s Sampler) {
+	l.logger.SetSampler(s)
 }
 
 func (l *entryLogger) Level() Level {
@@ -0,0 +1,67 @@
+package log
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// StackError represents an error with the stack trace such as a recovered
+// panic.
+type StackError struct {
+	Value interface{} // error value such as an error or a recovered value.
+	Stack []byte      // stack trace of the goroutine.
+}
+
+// NewStackError returns a new StackError with the stack trace of the current
+// goroutine. The stack trace won't be truncated.
+func NewStackError(v interface{}) *StackError {
+	buf := make([]byte, 4096)
+	for {
+		n := runtime.Stack(buf, false)
+		if n < len(buf) {
+			return &StackError{Value: v, Stack: buf[:n]}
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+}
+
+func (e *StackError) Error() string {
+	return fmt.Sprint(e.Value)
+}
+
+// ErrorLogger is the interface that is implemented by the Logger that
+// supports the fields of the error.
+type ErrorLogger interface {
+	// WithError returns a new Logger with the fields of err.
+	// The fields are "error" that is the message of err, "error_type" that is
+	// the type of err, and "stack" if err is *StackError.
+	WithError(err error) Logger
+}
+
+// WithError returns a new Logger with the fields of err.
+// If logger implements ErrorLogger, it returns logger.WithError(err).
+// Otherwise, it returns the Logger that the fields have been added by With.
+func WithError(logger Logger, err error) Logger {
+	if l, ok := logger.(ErrorLogger); ok {
+		return l.WithError(err)
+	}
+	return logger.With(errorFields(err))
+}
+
+// errorFields returns the fields of err.
+func errorFields(err error) Fields {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*StackError); ok {
+		return Fields{
+			"error":      e.Error(),
+			"error_type": fmt.Sprintf("%T", e.Value),
+			"stack":      string(e.Stack),
+		}
+	}
+	return Fields{
+		"error":      err.Error(),
+		"error_type": fmt.Sprintf("%T", err),
+	}
+}
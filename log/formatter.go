@@ -2,9 +2,14 @@ package log
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Formatter is an interface that formatter for a log entry.
@@ -38,11 +43,211 @@ func (f *LTSVFormatter) Format(w io.Writer, entry *Entry) error {
 		fmt.Fprintf(&buf, "\ttime:%v", entry.Time.Format(time.RFC3339Nano))
 	}
 	if entry.Message != "" {
-		fmt.Fprintf(&buf, "\tmessage:%v", entry.Message)
+		fmt.Fprintf(&buf, "\tmessage:%v", ltsvEscaper.Replace(entry.Message))
+	}
+	if entry.Caller != nil {
+		fmt.Fprintf(&buf, "\tcaller:%v\tfunction:%v", ltsvEscaper.Replace(entry.Caller.String()), ltsvEscaper.Replace(entry.Caller.Function))
+	}
+	for _, k := range entry.Fields.OrderedKeys() {
+		fmt.Fprintf(&buf, "\t%v:%v", ltsvEscaper.Replace(k), ltsvEscaper.Replace(fmt.Sprint(entry.Fields.Get(k))))
+	}
+	_, err := io.Copy(w, &buf)
+	return err
+}
+
+// ltsvEscaper escapes the separators of LTSV in the labels and values.
+// A backslash is also escaped in order to be unambiguous.
+var ltsvEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"\t", `\t`,
+	"\n", `\n`,
+	"\r", `\r`,
+)
+
+// The default keys of the log entry that are used by JSONFormatter and
+// LogfmtFormatter.
+const (
+	DefaultLevelKey    = "level"
+	DefaultTimeKey     = "time"
+	DefaultMessageKey  = "message"
+	DefaultCallerKey   = "caller"
+	DefaultFunctionKey = "function"
+)
+
+// JSONFormatter is the formatter of JSON Lines.
+// See http://jsonlines.org/ for more details.
+// The fields that conflict with the keys of level, time, message, caller and
+// function will be output with "fields." prefix.
+type JSONFormatter struct {
+	// TimeFormat is the layout of the time. Default is time.RFC3339Nano.
+	TimeFormat string
+
+	// LevelKey, TimeKey, MessageKey, CallerKey and FunctionKey are the keys of
+	// the level, the time, the message, the caller's file:line and the
+	// caller's function. Default are DefaultLevelKey, DefaultTimeKey,
+	// DefaultMessageKey, DefaultCallerKey and DefaultFunctionKey.
+	LevelKey    string
+	TimeKey     string
+	MessageKey  string
+	CallerKey   string
+	FunctionKey string
+}
+
+// Format formats an entry to a JSON object.
+func (f *JSONFormatter) Format(w io.Writer, entry *Entry) error {
+	keys := newEntryKeys(f.LevelKey, f.TimeKey, f.MessageKey, f.CallerKey, f.FunctionKey)
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	writeJSONPair(&buf, keys.level, entry.Level.String())
+	if !entry.Time.IsZero() {
+		buf.WriteByte(',')
+		writeJSONPair(&buf, keys.time, entry.Time.Format(orDefault(f.TimeFormat, time.RFC3339Nano)))
+	}
+	if entry.Message != "" {
+		buf.WriteByte(',')
+		writeJSONPair(&buf, keys.message, entry.Message)
+	}
+	if entry.Caller != nil {
+		buf.WriteByte(',')
+		writeJSONPair(&buf, keys.caller, entry.Caller.String())
+		buf.WriteByte(',')
+		writeJSONPair(&buf, keys.function, entry.Caller.Function)
 	}
 	for _, k := range entry.Fields.OrderedKeys() {
-		fmt.Fprintf(&buf, "\t%v:%v", k, entry.Fields.Get(k))
+		buf.WriteByte(',')
+		writeJSONPair(&buf, keys.field(k), jsonValue(entry.Fields.Get(k)))
 	}
+	buf.WriteByte('}')
 	_, err := io.Copy(w, &buf)
 	return err
 }
+
+func writeJSONPair(buf *bytes.Buffer, key string, value interface{}) {
+	k, _ := json.Marshal(key)
+	buf.Write(k)
+	buf.WriteByte(':')
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(v)
+}
+
+// jsonValue returns the value that can be encoded to JSON meaningfully.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Marshaler:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+// LogfmtFormatter is the formatter of logfmt.
+// See https://brandur.org/logfmt for more details.
+// The fields that conflict with the keys of level, time, message, caller and
+// function will be output with "fields." prefix.
+type LogfmtFormatter struct {
+	// TimeFormat is the layout of the time. Default is time.RFC3339Nano.
+	TimeFormat string
+
+	// LevelKey, TimeKey, MessageKey, CallerKey and FunctionKey are the keys of
+	// the level, the time, the message, the caller's file:line and the
+	// caller's function. Default are DefaultLevelKey, DefaultTimeKey,
+	// DefaultMessageKey, DefaultCallerKey and DefaultFunctionKey.
+	LevelKey    string
+	TimeKey     string
+	MessageKey  string
+	CallerKey   string
+	FunctionKey string
+}
+
+// Format formats an entry to logfmt format.
+func (f *LogfmtFormatter) Format(w io.Writer, entry *Entry) error {
+	keys := newEntryKeys(f.LevelKey, f.TimeKey, f.MessageKey, f.CallerKey, f.FunctionKey)
+	var buf bytes.Buffer
+	writeLogfmtPair(&buf, keys.level, entry.Level.String())
+	if !entry.Time.IsZero() {
+		buf.WriteByte(' ')
+		writeLogfmtPair(&buf, keys.time, entry.Time.Format(orDefault(f.TimeFormat, time.RFC3339Nano)))
+	}
+	if entry.Message != "" {
+		buf.WriteByte(' ')
+		writeLogfmtPair(&buf, keys.message, entry.Message)
+	}
+	if entry.Caller != nil {
+		buf.WriteByte(' ')
+		writeLogfmtPair(&buf, keys.caller, entry.Caller.String())
+		buf.WriteByte(' ')
+		writeLogfmtPair(&buf, keys.function, entry.Caller.Function)
+	}
+	for _, k := range entry.Fields.OrderedKeys() {
+		buf.WriteByte(' ')
+		writeLogfmtPair(&buf, keys.field(k), fmt.Sprint(entry.Fields.Get(k)))
+	}
+	_, err := io.Copy(w, &buf)
+	return err
+}
+
+func writeLogfmtPair(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError || !unicode.IsPrint(r) {
+			return '_'
+		}
+		return r
+	}, key))
+	buf.WriteByte('=')
+	if needsLogfmtQuote(value) {
+		buf.WriteString(strconv.Quote(value))
+		return
+	}
+	buf.WriteString(value)
+}
+
+func needsLogfmtQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || r == utf8.RuneError || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// entryKeys represents the keys of the log entry.
+type entryKeys struct {
+	level, time, message, caller, function string
+}
+
+func newEntryKeys(level, time, message, caller, function string) entryKeys {
+	return entryKeys{
+		level:    orDefault(level, DefaultLevelKey),
+		time:     orDefault(time, DefaultTimeKey),
+		message:  orDefault(message, DefaultMessageKey),
+		caller:   orDefault(caller, DefaultCallerKey),
+		function: orDefault(function, DefaultFunctionKey),
+	}
+}
+
+// field returns the key for the field.
+// If key conflicts with the keys of the entry, it returns key with "fields."
+// prefix.
+func (k entryKeys) field(key string) string {
+	switch key {
+	case k.level, k.time, k.message, k.caller, k.function:
+		return "fields." + key
+	}
+	return key
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
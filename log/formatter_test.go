@@ -2,6 +2,7 @@ package log_test
 
 import (
 	"bytes"
+	"fmt"
 	"reflect"
 	"testing"
 	"time"
@@ -76,6 +77,18 @@ func TestLTSVFormatter_Format(t *testing.T) {
 			Level: log.FATAL,
 		}, "level:FATAL"},
 		{&log.Entry{}, "level:NONE"},
+		{&log.Entry{
+			Level:   log.ERROR,
+			Message: "panic\n\tstack\\trace",
+			Fields: log.Fields{
+				"multi\tline": "a\r\nb",
+			},
+		}, "level:ERROR\tmessage:panic\\n\\tstack\\\\trace\tmulti\\tline:a\\r\\nb"},
+		{&log.Entry{
+			Level:   log.ERROR,
+			Message: "with caller",
+			Caller:  &log.Caller{File: "/path/to/file.go", Line: 12, Function: "main.main"},
+		}, "level:ERROR\tmessage:with caller\tcaller:/path/to/file.go:12\tfunction:main.main"},
 	} {
 		var buf bytes.Buffer
 		formatter := &log.LTSVFormatter{}
@@ -89,3 +102,105 @@ func TestLTSVFormatter_Format(t *testing.T) {
 		}
 	}
 }
+
+func TestJSONFormatter_Format(t *testing.T) {
+	now := time.Date(2015, 3, 4, 5, 6, 7, 8, time.UTC)
+	for _, v := range []struct {
+		formatter *log.JSONFormatter
+		entry     *log.Entry
+		expect    string
+	}{
+		{&log.JSONFormatter{}, &log.Entry{
+			Level:   log.DEBUG,
+			Time:    now,
+			Message: "test_json_log1",
+			Fields: log.Fields{
+				"first":  1,
+				"second": "2",
+				"third":  []string{"san"},
+				"error":  fmt.Errorf("fail\n\"quoted\""),
+			},
+		}, `{"level":"DEBUG","time":"2015-03-04T05:06:07.000000008Z","message":"test_json_log1","error":"fail\n\"quoted\"","first":1,"second":"2","third":["san"]}`},
+		{&log.JSONFormatter{
+			TimeFormat: time.RFC3339,
+			LevelKey:   "severity",
+			TimeKey:    "@timestamp",
+			MessageKey: "msg",
+		}, &log.Entry{
+			Level:   log.INFO,
+			Time:    now,
+			Message: "test_json_log2",
+			Fields: log.Fields{
+				"msg":   "conflict",
+				"level": "not conflict",
+			},
+		}, `{"severity":"INFO","@timestamp":"2015-03-04T05:06:07Z","msg":"test_json_log2","level":"not conflict","fields.msg":"conflict"}`},
+		{&log.JSONFormatter{}, &log.Entry{}, `{"level":"NONE"}`},
+		{&log.JSONFormatter{CallerKey: "src"}, &log.Entry{
+			Level:   log.ERROR,
+			Message: "with caller",
+			Caller:  &log.Caller{File: "/path/to/file.go", Line: 12, Function: "main.main"},
+		}, `{"level":"ERROR","message":"with caller","src":"/path/to/file.go:12","function":"main.main"}`},
+	} {
+		var buf bytes.Buffer
+		if err := v.formatter.Format(&buf, v.entry); err != nil {
+			t.Errorf(`JSONFormatter.Format(&buf, %#v) => %#v; want %#v`, v.entry, err, nil)
+		}
+		actual := buf.String()
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`%#v.Format(&buf, %#v); buf => %#v; want %#v`, v.formatter, v.entry, actual, expect)
+		}
+	}
+}
+
+func TestLogfmtFormatter_Format(t *testing.T) {
+	now := time.Date(2015, 3, 4, 5, 6, 7, 8, time.UTC)
+	for _, v := range []struct {
+		formatter *log.LogfmtFormatter
+		entry     *log.Entry
+		expect    string
+	}{
+		{&log.LogfmtFormatter{}, &log.Entry{
+			Level:   log.DEBUG,
+			Time:    now,
+			Message: "test logfmt log1",
+			Fields: log.Fields{
+				"first":     1,
+				"second":    "a=b",
+				"third":     "",
+				"bad key":   "line1\nline2",
+				"backslash": `C:\path`,
+			},
+		}, `level=DEBUG time=2015-03-04T05:06:07.000000008Z message="test logfmt log1" backslash="C:\\path" bad_key="line1\nline2" first=1 second="a=b" third=""`},
+		{&log.LogfmtFormatter{
+			TimeFormat: time.RFC3339,
+			LevelKey:   "lvl",
+			TimeKey:    "ts",
+			MessageKey: "msg",
+		}, &log.Entry{
+			Level:   log.INFO,
+			Time:    now,
+			Message: "test_logfmt_log2",
+			Fields: log.Fields{
+				"ts": "conflict",
+			},
+		}, `lvl=INFO ts=2015-03-04T05:06:07Z msg=test_logfmt_log2 fields.ts=conflict`},
+		{&log.LogfmtFormatter{}, &log.Entry{}, `level=NONE`},
+		{&log.LogfmtFormatter{}, &log.Entry{
+			Level:   log.ERROR,
+			Message: "with caller",
+			Caller:  &log.Caller{File: "/path/to/file.go", Line: 12, Function: "main.main"},
+		}, `level=ERROR message="with caller" caller=/path/to/file.go:12 function=main.main`},
+	} {
+		var buf bytes.Buffer
+		if err := v.formatter.Format(&buf, v.entry); err != nil {
+			t.Errorf(`LogfmtFormatter.Format(&buf, %#v) => %#v; want %#v`, v.entry, err, nil)
+		}
+		actual := buf.String()
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`%#v.Format(&buf, %#v); buf => %#v; want %#v`, v.formatter, v.entry, actual, expect)
+		}
+	}
+}
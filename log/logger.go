@@ -2,9 +2,11 @@ package log
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"os"
 	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 
@@ -131,36 +133,209 @@ type Logger interface {
 	SetLevel(level Level)
 }
 
+// NamedLogger is the interface that is implemented by the Logger that has
+// the named child loggers.
+type NamedLogger interface {
+	// Named returns the child logger that has the given name.
+	// The name of the child logger will be joined to the name of the parent
+	// with ".", e.g. "kocha.session", and it will be output as the "logger"
+	// field. The same name always returns the same child logger.
+	// A child logger inherits the level of the parent which is determined by
+	// the name until SetLevel of the child logger is called.
+	Named(name string) Logger
+
+	// Lookup returns the child logger that has the given name if it has
+	// already been created by Named. Unlike Named, it never creates the
+	// child logger.
+	Lookup(name string) (logger Logger, ok bool)
+}
+
+// Named returns the child logger of logger that has the given name.
+// If logger doesn't implement NamedLogger, it returns the Logger that has the
+// name as the "logger" field.
+func Named(logger Logger, name string) Logger {
+	if l, ok := logger.(NamedLogger); ok {
+		return l.Named(name)
+	}
+	return logger.With(Fields{"logger": name})
+}
+
+// Lookup returns the child logger of logger that has the given name if it
+// exists. If logger doesn't implement NamedLogger, ok is always false.
+func Lookup(logger Logger, name string) (child Logger, ok bool) {
+	if l, isNamed := logger.(NamedLogger); isNamed {
+		return l.Lookup(name)
+	}
+	return nil, false
+}
+
+// CallerReporter is the interface that is implemented by the Logger that can
+// report the caller of the log functions.
+type CallerReporter interface {
+	// SetReportCaller sets whether to report the caller of the log functions.
+	// If report is true, Entry.Caller of each entry will be set.
+	SetReportCaller(report bool)
+}
+
+// SetReportCaller sets whether to report the caller of the log functions if
+// logger implements CallerReporter. Otherwise, it does nothing.
+func SetReportCaller(logger Logger, report bool) {
+	if r, ok := logger.(CallerReporter); ok {
+		r.SetReportCaller(report)
+	}
+}
+
 // New creates a new Logger.
 func New(out io.Writer, formatter Formatter, level Level) Logger {
-	l := &logger{
-		out:         out,
-		formatter:   formatter,
+	return newRootLogger([]*sink{newSink(Sink{Writer: out, Formatter: formatter})}, nil, level)
+}
+
+// NewMulti creates a new Logger that outputs to the multiple sinks.
+// Each entry will be output to the sinks whose level is less than or equal to
+// the level of the entry, and then hooks whose level is less than or equal to
+// the level of the entry will be invoked.
+// The initial level of the logger is the lowest level in sinks and hooks.
+func NewMulti(sinks []Sink, hooks ...Hook) Logger {
+	var snks []*sink
+	level := PANIC
+	for _, s := range sinks {
+		snks = append(snks, newSink(s))
+		if s.Level < level {
+			level = s.Level
+		}
+	}
+	for _, h := range hooks {
+		if h.Level() < level {
+			level = h.Level()
+		}
+	}
+	return newRootLogger(snks, hooks, level)
+}
+
+// Sink represents an output destination of the logger.
+type Sink struct {
+	Writer    io.Writer // output destination.
+	Formatter Formatter // formatter for log entry.
+	Level     Level     // minimum log level of the sink.
+}
+
+// Hook is the interface that is invoked for the log entries.
+type Hook interface {
+	// Level returns the minimum log level of the entries that the hook will
+	// be invoked for.
+	Level() Level
+
+	// Fire is called for the log entry after the output.
+	// The entry must not be modified.
+	// Fire is called without the lock of the logger, so that the hook can
+	// log by the logger, and it may be called concurrently.
+	Fire(entry *Entry) error
+}
+
+// sink is an output destination of the logger.
+type sink struct {
+	out         io.Writer
+	formatter   Formatter
+	formatFuncs [7]formatFunc
+	level       Level
+	buf         bytes.Buffer
+}
+
+func newSink(s Sink) *sink {
+	snk := &sink{
+		out:         s.Writer,
+		formatter:   s.Formatter,
 		formatFuncs: plainFormats,
-		level:       level,
+		level:       s.Level,
 	}
-	if w, ok := out.(*os.File); ok && isatty.IsTerminal(w.Fd()) {
+	if w, ok := s.Writer.(*os.File); ok && isatty.IsTerminal(w.Fd()) {
 		switch w {
 		case os.Stdout:
-			l.out = colorable.NewColorableStdout()
-			l.formatFuncs = coloredFormats
+			snk.out = colorable.NewColorableStdout()
+			snk.formatFuncs = coloredFormats
 		case os.Stderr:
-			l.out = colorable.NewColorableStderr()
-			l.formatFuncs = coloredFormats
+			snk.out = colorable.NewColorableStderr()
+			snk.formatFuncs = coloredFormats
+		}
+	}
+	return snk
+}
+
+// write writes the entry to the sink.
+// It must be called with the lock of the logger.
+func (s *sink) write(entry *Entry) {
+	if entry.Level != NONE && entry.Level < s.level {
+		return
+	}
+	s.buf.Reset()
+	format := Formatter.Format
+	if int(entry.Level) < len(s.formatFuncs) {
+		format = s.formatFuncs[entry.Level]
+	}
+	if err := format(s.formatter, &s.buf, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "kocha: log: %v\n", err)
+	}
+	s.buf.WriteByte('\n')
+	if _, err := io.Copy(s.out, &s.buf); err != nil {
+		fmt.Fprintf(os.Stderr, "kocha: log: failed to write log: %v\n", err)
+	}
+}
+
+// output is the output destinations that are shared by the root logger and
+// its children.
+type output struct {
+	sinks        []*sink
+	hooks        []Hook
+	reportCaller uint32
+	sampler      Sampler
+	mu           sync.Mutex
+	root         *logger
+	children     map[string]*logger
+	childrenMu   sync.RWMutex
+}
+
+// write writes the entry to the sinks.
+// It must be called with the lock.
+func (o *output) write(entry *Entry) {
+	for _, s := range o.sinks {
+		s.write(entry)
+	}
+}
+
+// fire fires the hooks for the entry.
+// It must be called without the lock, because the hooks may be slow, and may
+// log by the logger.
+func (o *output) fire(entry *Entry) {
+	for _, h := range o.hooks {
+		if entry.Level != NONE && entry.Level >= h.Level() {
+			if err := h.Fire(entry); err != nil {
+				fmt.Fprintf(os.Stderr, "kocha: log: hook error: %v\n", err)
+			}
 		}
 	}
-	return l
 }
 
 // logger implements the Logger interface.
 type logger struct {
-	out         io.Writer
-	formatter   Formatter
-	formatFuncs [7]formatFunc
-	level       Level
-	fields      Fields
-	buf         bytes.Buffer
-	mu          sync.Mutex
+	*output
+	name     string
+	parent   *logger
+	level    Level
+	levelSet uint32
+}
+
+func newRootLogger(sinks []*sink, hooks []Hook, level Level) *logger {
+	l := &logger{
+		output: &output{
+			sinks:    sinks,
+			hooks:    hooks,
+			children: make(map[string]*logger),
+		},
+		level:    level,
+		levelSet: 1,
+	}
+	l.root = l
+	return l
 }
 
 func (l *logger) Debug(v ...interface{}) {
@@ -279,12 +454,85 @@ func (l *logger) With(fields Fields) Logger {
 	return newEntryLogger(l).With(fields)
 }
 
+func (l *logger) WithError(err error) Logger {
+	return newEntryLogger(l).WithError(err)
+}
+
+func (l *logger) SetReportCaller(report bool) {
+	var v uint32
+	if report {
+		v = 1
+	}
+	atomic.StoreUint32(&l.reportCaller, v)
+}
+
+func (l *logger) SetSampler(s Sampler) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sampler = s
+}
+
+func (l *logger) isReportCaller() bool {
+	return atomic.LoadUint32(&l.reportCaller) == 1
+}
+
 func (l *logger) Level() Level {
+	if l.parent != nil && atomic.LoadUint32(&l.levelSet) == 0 {
+		return l.parent.Level()
+	}
 	return Level(atomic.LoadUint32((*uint32)(&l.level)))
 }
 
 func (l *logger) SetLevel(level Level) {
 	atomic.StoreUint32((*uint32)(&l.level), uint32(level))
+	atomic.StoreUint32(&l.levelSet, 1)
+}
+
+func (l *logger) Named(name string) Logger {
+	if l.name != "" {
+		name = l.name + "." + name
+	}
+	return l.root.child(name)
+}
+
+func (l *logger) Lookup(name string) (Logger, bool) {
+	if l.name != "" {
+		name = l.name + "." + name
+	}
+	l.childrenMu.RLock()
+	defer l.childrenMu.RUnlock()
+	if c, exists := l.children[name]; exists {
+		return c, true
+	}
+	return nil, false
+}
+
+// child returns the child logger of the full name.
+// The parent of the child logger is determined by the name, e.g. the parent
+// of "kocha.session" is "kocha".
+func (l *logger) child(name string) *logger {
+	l.childrenMu.RLock()
+	c, exists := l.children[name]
+	l.childrenMu.RUnlock()
+	if exists {
+		return c
+	}
+	parent := l.root
+	if i := strings.LastIndex(name, "."); i > 0 {
+		parent = l.child(name[:i])
+	}
+	l.childrenMu.Lock()
+	defer l.childrenMu.Unlock()
+	if c, exists := l.children[name]; exists {
+		return c
+	}
+	c = &logger{
+		output: l.output,
+		name:   name,
+		parent: parent,
+	}
+	l.children[name] = c
+	return c
 }
 
 // Level represents a log level.
@@ -301,6 +549,16 @@ const (
 	PANIC
 )
 
+// ParseLevel parses the level name such as "DEBUG" or "warn".
+func ParseLevel(name string) (Level, error) {
+	for level := NONE; level <= PANIC; level++ {
+		if strings.EqualFold(level.String(), name) {
+			return level, nil
+		}
+	}
+	return NONE, fmt.Errorf("kocha: log: unknown level: %v", name)
+}
+
 type formatFunc func(f Formatter, w io.Writer, entry *Entry) error
 
 func makeFormat(esc string) formatFunc {
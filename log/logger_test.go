@@ -2,8 +2,11 @@ package log_test
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -1375,6 +1378,273 @@ func TestLogger_SetLevel(t *testing.T) {
 	}
 }
 
+func TestLogger_With_chained(t *testing.T) {
+	now := time.Now()
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	var buf bytes.Buffer
+	logger := log.New(&buf, &log.LTSVFormatter{}, log.INFO).With(log.Fields{"first": 1, "second": 2})
+	logger.With(log.Fields{"second": "overridden", "third": 3}).Info("child")
+	logger.Info("parent")
+	actual := buf.String()
+	expected := "level:INFO\ttime:" + now.Format(time.RFC3339Nano) + "\tmessage:child\tfirst:1\tsecond:overridden\tthird:3\n" +
+		"level:INFO\ttime:" + now.Format(time.RFC3339Nano) + "\tmessage:parent\tfirst:1\tsecond:2\n"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`logger.With(fields).With(fields).Info("child"); logger.Info("parent") prints %#v; want %#v`, actual, expected)
+	}
+}
+
+type testHook struct {
+	level   log.Level
+	entries []string
+}
+
+func (h *testHook) Level() log.Level {
+	return h.level
+}
+
+func (h *testHook) Fire(entry *log.Entry) error {
+	h.entries = append(h.entries, fmt.Sprintf("%v:%v:%v", entry.Level, entry.Message, entry.Fields))
+	return nil
+}
+
+func TestNewMulti(t *testing.T) {
+	now := time.Now()
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	var debugBuf, warnBuf bytes.Buffer
+	hook := &testHook{level: log.ERROR}
+	logger := log.NewMulti([]log.Sink{
+		{Writer: &debugBuf, Formatter: &log.RawFormatter{}, Level: log.DEBUG},
+		{Writer: &warnBuf, Formatter: &log.LTSVFormatter{}, Level: log.WARN},
+	}, hook)
+	var actual interface{} = logger.Level()
+	var expect interface{} = log.DEBUG
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`log.NewMulti(sinks, hook).Level() => %#v; want %#v`, actual, expect)
+	}
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.With(log.Fields{"key": "value"}).Warn("warn")
+	logger.Error("error")
+	logger.Print("print")
+	actual = debugBuf.String()
+	expect = "debug\ninfo\nwarn\nerror\nprint\n"
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`log.NewMulti(sinks, hook); DEBUG sink => %#v; want %#v`, actual, expect)
+	}
+	actual = warnBuf.String()
+	expect = "level:WARN\ttime:" + now.Format(time.RFC3339Nano) + "\tmessage:warn\tkey:value\n" +
+		"level:ERROR\ttime:" + now.Format(time.RFC3339Nano) + "\tmessage:error\n" +
+		"level:NONE\ttime:" + now.Format(time.RFC3339Nano) + "\tmessage:print\n"
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`log.NewMulti(sinks, hook); WARN sink => %#v; want %#v`, actual, expect)
+	}
+	actual = hook.entries
+	expect = []string{"ERROR:error:map[]"}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`log.NewMulti(sinks, hook); hook entries => %#v; want %#v`, actual, expect)
+	}
+
+	debugBuf.Reset()
+	logger.SetLevel(log.INFO)
+	logger.Debug("debug")
+	actual = debugBuf.String()
+	expect = ""
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`log.NewMulti(sinks, hook).SetLevel(%v); Debug("debug"); DEBUG sink => %#v; want %#v`, log.INFO, actual, expect)
+	}
+}
+
+type loggingHook struct {
+	logger log.Logger
+}
+
+func (h *loggingHook) Level() log.Level {
+	return log.ERROR
+}
+
+func (h *loggingHook) Fire(entry *log.Entry) error {
+	h.logger.Info("hook: ", entry.Message)
+	return nil
+}
+
+func TestNewMulti_hookLogs(t *testing.T) {
+	var buf bytes.Buffer
+	hook := &loggingHook{}
+	logger := log.NewMulti([]log.Sink{{Writer: &buf, Formatter: &log.RawFormatter{}, Level: log.DEBUG}}, hook)
+	hook.logger = logger
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		logger.Error("error")
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Error with the hook that logs by the logger hasn't returned within 3 seconds")
+	}
+	var actual interface{} = buf.String()
+	var expect interface{} = "error\nhook: error\n"
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`log.NewMulti(sinks, hook).Error("error") => %#v; want %#v`, actual, expect)
+	}
+}
+
+// wrappedLogger is the Logger that doesn't implement the optional interfaces.
+type wrappedLogger struct {
+	log.Logger
+}
+
+func TestWithError(t *testing.T) {
+	now := time.Now()
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	var buf bytes.Buffer
+	logger := log.New(&buf, &log.LTSVFormatter{}, log.INFO)
+	for _, l := range []log.Logger{logger, wrappedLogger{logger}} {
+		buf.Reset()
+		log.WithError(l, fmt.Errorf("test error")).Error("failed")
+		actual := buf.String()
+		expected := "level:ERROR\ttime:" + now.Format(time.RFC3339Nano) + "\tmessage:failed\terror:test error\terror_type:*errors.errorString\n"
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf(`log.WithError(%T, err).Error("failed") prints %#v; want %#v`, l, actual, expected)
+		}
+	}
+
+	buf.Reset()
+	serr := &log.StackError{Value: "panic value", Stack: []byte("goroutine 1 [running]:\nmain.main()")}
+	log.WithError(logger, serr).Error(serr)
+	actual := buf.String()
+	expected := "level:ERROR\ttime:" + now.Format(time.RFC3339Nano) + "\tmessage:panic value\terror:panic value\terror_type:string\tstack:goroutine 1 [running]:\\nmain.main()\n"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`log.WithError(logger, %#v).Error(err) prints %#v; want %#v`, serr, actual, expected)
+	}
+}
+
+func TestNewStackError(t *testing.T) {
+	serr := log.NewStackError("test")
+	var actual interface{} = serr.Error()
+	var expected interface{} = "test"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`log.NewStackError("test").Error() => %#v; want %#v`, actual, expected)
+	}
+	actual = strings.Contains(string(serr.Stack), "TestNewStackError")
+	expected = true
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`log.NewStackError("test").Stack => %#v; want to contain the caller`, string(serr.Stack))
+	}
+}
+
+func TestSetReportCaller(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, &log.JSONFormatter{}, log.INFO)
+	log.SetReportCaller(logger, true)
+	_, file, line, _ := runtime.Caller(0)
+	logger.With(log.Fields{"key": "value"}).Info("with caller")
+	actual := buf.String()
+	expected := fmt.Sprintf(`"message":"with caller","caller":"%s:%d","function":"github.com/naoina/kocha/log_test.TestSetReportCaller","key":"value"}`, file, line+1)
+	if !strings.Contains(actual, expected) {
+		t.Errorf(`log.SetReportCaller(logger, true); logger.Info("with caller") prints %#v; want to contain %#v`, actual, expected)
+	}
+
+	buf.Reset()
+	log.SetReportCaller(logger, false)
+	logger.Info("without caller")
+	actual = buf.String()
+	if strings.Contains(actual, `"caller"`) {
+		t.Errorf(`log.SetReportCaller(logger, false); logger.Info("without caller") prints %#v; want not to contain caller`, actual)
+	}
+}
+
+func TestNamed(t *testing.T) {
+	now := time.Now()
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	var buf bytes.Buffer
+	logger := log.New(&buf, &log.LTSVFormatter{}, log.INFO)
+	session := log.Named(log.Named(logger, "kocha"), "session")
+	if session != log.Named(logger, "kocha.session") {
+		t.Errorf(`log.Named(log.Named(logger, "kocha"), "session") => %#v; want same as log.Named(logger, "kocha.session")`, session)
+	}
+	session.Info("info")
+	log.Named(logger.With(log.Fields{"request_id": "abc", "logger": "overridden"}), "kocha.session").Info("with fields")
+	actual := buf.String()
+	expected := "level:INFO\ttime:" + now.Format(time.RFC3339Nano) + "\tmessage:info\tlogger:kocha.session\n" +
+		"level:INFO\ttime:" + now.Format(time.RFC3339Nano) + "\tmessage:with fields\tlogger:kocha.session\trequest_id:abc\n"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`log.Named(logger, "kocha.session").Info("info") prints %#v; want %#v`, actual, expected)
+	}
+
+	buf.Reset()
+	log.Named(wrappedLogger{logger}, "kocha.session").Info("wrapped")
+	actual = buf.String()
+	expected = "level:INFO\ttime:" + now.Format(time.RFC3339Nano) + "\tmessage:wrapped\tlogger:kocha.session\n"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`log.Named(wrappedLogger{logger}, "kocha.session").Info("wrapped") prints %#v; want %#v`, actual, expected)
+	}
+
+	for _, v := range []struct {
+		set    func()
+		expect [3]log.Level
+	}{
+		{func() {}, [3]log.Level{log.INFO, log.INFO, log.INFO}},
+		{func() { log.Named(logger, "kocha").SetLevel(log.DEBUG) }, [3]log.Level{log.INFO, log.DEBUG, log.DEBUG}},
+		{func() { session.SetLevel(log.ERROR) }, [3]log.Level{log.INFO, log.DEBUG, log.ERROR}},
+		{func() { logger.SetLevel(log.WARN) }, [3]log.Level{log.WARN, log.DEBUG, log.ERROR}},
+	} {
+		v.set()
+		actual := [3]log.Level{logger.Level(), log.Named(logger, "kocha").Level(), session.Level()}
+		if !reflect.DeepEqual(actual, v.expect) {
+			t.Errorf(`[root, kocha, kocha.session] levels => %v; want %v`, actual, v.expect)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	logger := log.New(ioutil.Discard, &log.LTSVFormatter{}, log.INFO)
+	kocha := log.Named(logger, "kocha")
+	for _, v := range []struct {
+		logger log.Logger
+		name   string
+		expect log.Logger
+	}{
+		{logger, "kocha", kocha},
+		{logger, "kocha.session", nil},
+		{logger, "other", nil},
+		{wrappedLogger{logger}, "kocha", nil},
+	} {
+		actual, ok := log.Lookup(v.logger, v.name)
+		if actual != v.expect || ok != (v.expect != nil) {
+			t.Errorf(`log.Lookup(%T, %#v) => %#v, %#v; want %#v, %#v`, v.logger, v.name, actual, ok, v.expect, v.expect != nil)
+		}
+	}
+	session := log.Named(kocha, "session")
+	if actual, ok := log.Lookup(kocha, "session"); actual != session || !ok {
+		t.Errorf(`log.Lookup(kocha, "session") => %#v, %#v; want %#v, true`, actual, ok, session)
+	}
+}
+func TestParseLevel(t *testing.T) {
+	for _, v := range []struct {
+		name   string
+		expect log.Level
+		err    error
+	}{
+		{"DEBUG", log.DEBUG, nil},
+		{"warn", log.WARN, nil},
+		{"Panic", log.PANIC, nil},
+		{"unknown", log.NONE, fmt.Errorf("kocha: log: unknown level: unknown")},
+	} {
+		actual, err := log.ParseLevel(v.name)
+		if !reflect.DeepEqual(err, v.err) {
+			t.Errorf(`log.ParseLevel(%#v) => _, %#v; want _, %#v`, v.name, err, v.err)
+		}
+		if !reflect.DeepEqual(actual, v.expect) {
+			t.Errorf(`log.ParseLevel(%#v) => %#v, _; want %#v, _`, v.name, actual, v.expect)
+		}
+	}
+}
+
 func TestLevel_String(t *testing.T) {
 	for _, v := range []struct {
 		level          log.Level
@@ -0,0 +1,289 @@
+package log
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/naoina/kocha/util"
+)
+
+// rotatedTimeFormat is the time format of suffix of the rotated files.
+const rotatedTimeFormat = "20060102-150405.000000000"
+
+// RotatingFileWriter is an io.WriteCloser that writes to the file and
+// rotates it by size and/or time.
+//
+// The rotated file will be renamed to Path with the suffix of the rotated
+// time such as "app.log.20150304-050607.000000000", and optionally compressed
+// to gzip.
+// The file will be opened at the first Write.
+type RotatingFileWriter struct {
+	// Path is the path of the log file.
+	Path string
+
+	// MaxSize is the maximum size of the file in bytes.
+	// If the size of the file will exceed MaxSize by Write, the file will be
+	// rotated before writing. If MaxSize is 0, the file won't be rotated by
+	// size.
+	MaxSize int64
+
+	// Interval is the interval of rotation.
+	// The file will be rotated at the boundary of Interval such as every hour
+	// or every 24 hours since the zero time in UTC. If Interval is 0, the file
+	// won't be rotated by time.
+	Interval time.Duration
+
+	// MaxBackups is the maximum number of the rotated files to retain.
+	// The oldest files will be removed when exceeded. If MaxBackups is 0, all
+	// rotated files will be retained.
+	MaxBackups int
+
+	// Compress specifies whether to compress the rotated files by gzip.
+	Compress bool
+
+	// ReopenSignals is the signals to reopen the file.
+	// This is useful for the external rotation tools such as logrotate.
+	// Typically, syscall.SIGHUP is used.
+	ReopenSignals []os.Signal
+
+	file     *os.File
+	size     int64
+	next     time.Time
+	err      error
+	closed   bool
+	once     sync.Once
+	mu       sync.Mutex
+	bgMu     sync.Mutex // for the compression and the removal of backups.
+	wg       sync.WaitGroup
+	signalCh chan os.Signal
+}
+
+// Write writes p to the file.
+// If the file should be rotated, Write rotates it before writing.
+func (w *RotatingFileWriter) Write(p []byte) (n int, err error) {
+	w.once.Do(w.init)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.checkState(); err != nil {
+		return 0, err
+	}
+	if w.shouldRotate(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err = w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Reopen closes the file and opens it again.
+// The file will be created if it doesn't exist, e.g. it has been moved by an
+// external rotation tool.
+func (w *RotatingFileWriter) Reopen() error {
+	w.once.Do(w.init)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errClosed
+	}
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.file = nil
+	}
+	w.err = w.open()
+	return w.err
+}
+
+// Rotate rotates the file immediately.
+func (w *RotatingFileWriter) Rotate() error {
+	w.once.Do(w.init)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.checkState(); err != nil {
+		return err
+	}
+	return w.rotate()
+}
+
+// Close closes the file.
+// Close waits for the compression of the rotated files to complete.
+func (w *RotatingFileWriter) Close() error {
+	w.once.Do(func() {}) // prevent to open the file after Close.
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.signalCh != nil {
+		signal.Stop(w.signalCh)
+		close(w.signalCh)
+		w.signalCh = nil
+	}
+	w.wg.Wait()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// errClosed is returned when the writer has been closed.
+var errClosed = errors.New("kocha: log: writer is closed")
+
+func (w *RotatingFileWriter) checkState() error {
+	if w.closed {
+		return errClosed
+	}
+	return w.err
+}
+
+func (w *RotatingFileWriter) init() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err = w.open(); w.err != nil {
+		return
+	}
+	if len(w.ReopenSignals) > 0 {
+		w.signalCh = make(chan os.Signal, 1)
+		signal.Notify(w.signalCh, w.ReopenSignals...)
+		go func(ch chan os.Signal) {
+			for range ch {
+				if err := w.Reopen(); err != nil {
+					fmt.Fprintf(os.Stderr, "kocha: log: failed to reopen %v: %v\n", w.Path, err)
+				}
+			}
+		}(w.signalCh)
+	}
+}
+
+func (w *RotatingFileWriter) open() error {
+	f, err := os.OpenFile(w.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.size = f, info.Size()
+	if w.Interval > 0 {
+		w.next = util.Now().Truncate(w.Interval).Add(w.Interval)
+	}
+	return nil
+}
+
+func (w *RotatingFileWriter) shouldRotate(n int64) bool {
+	if w.MaxSize > 0 && w.size > 0 && w.size+n > w.MaxSize {
+		return true
+	}
+	return w.Interval > 0 && !util.Now().Before(w.next)
+}
+
+func (w *RotatingFileWriter) rotate() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.file = nil
+	}
+	rotated := w.Path + "." + util.Now().Format(rotatedTimeFormat)
+	if err := os.Rename(w.Path, rotated); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if w.err = w.open(); w.err != nil {
+		return w.err
+	}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.bgMu.Lock()
+		defer w.bgMu.Unlock()
+		if w.Compress {
+			if err := compressFile(rotated); err != nil {
+				fmt.Fprintf(os.Stderr, "kocha: log: failed to compress %v: %v\n", rotated, err)
+			}
+		}
+		if err := w.removeOldBackups(); err != nil {
+			fmt.Fprintf(os.Stderr, "kocha: log: failed to remove old log files: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// backups returns the rotated files in order from newest to oldest.
+func (w *RotatingFileWriter) backups() ([]string, error) {
+	matches, err := filepath.Glob(w.Path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, path := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(path, w.Path+"."), ".gz")
+		if _, err := time.Parse(rotatedTimeFormat, suffix); err == nil {
+			backups = append(backups, path)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
+
+func (w *RotatingFileWriter) removeOldBackups() error {
+	if w.MaxBackups < 1 {
+		return nil
+	}
+	backups, err := w.backups()
+	if err != nil {
+		return err
+	}
+	for i := w.MaxBackups; i < len(backups); i++ {
+		if err := os.Remove(backups[i]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// compressFile compresses the file of path to path + ".gz" and removes the
+// original file.
+func compressFile(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path + ".gz")
+		}
+	}()
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,155 @@
+package log_test
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/naoina/kocha/log"
+	"github.com/naoina/kocha/util"
+)
+
+func readRotatedFiles(t *testing.T, dir string) map[string]string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(map[string]string)
+	for _, info := range files {
+		path := filepath.Join(dir, info.Name())
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var content []byte
+		if filepath.Ext(path) == ".gz" {
+			zr, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err = ioutil.ReadAll(zr)
+		} else {
+			content, err = ioutil.ReadAll(f)
+		}
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		result[info.Name()] = string(content)
+	}
+	return result
+}
+
+func TestRotatingFileWriter_bySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestRotatingFileWriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Date(2015, 3, 4, 5, 6, 7, 0, time.UTC)
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	w := &log.RotatingFileWriter{
+		Path:       filepath.Join(dir, "app.log"),
+		MaxSize:    10,
+		MaxBackups: 2,
+	}
+	for _, s := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Second)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	actual := readRotatedFiles(t, dir)
+	expect := map[string]string{
+		"app.log":                           "fourth\n",
+		"app.log.20150304-050610.000000000": "third\n",
+		"app.log.20150304-050609.000000000": "second\n",
+	}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`RotatingFileWriter{MaxSize: 10, MaxBackups: 2}; files => %#v; want %#v`, actual, expect)
+	}
+	if _, err := w.Write([]byte("closed")); err == nil {
+		t.Errorf(`RotatingFileWriter.Write(p) after Close => %#v; want error`, err)
+	}
+}
+
+func TestRotatingFileWriter_byInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestRotatingFileWriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Date(2015, 3, 4, 5, 59, 0, 0, time.UTC)
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	w := &log.RotatingFileWriter{
+		Path:     filepath.Join(dir, "app.log"),
+		Interval: time.Hour,
+		Compress: true,
+	}
+	for _, v := range []struct {
+		s   string
+		now time.Time
+	}{
+		{"first\n", now},
+		{"second\n", now.Add(30 * time.Second)},
+		{"third\n", now.Add(time.Minute)},
+		{"fourth\n", now.Add(2 * time.Hour)},
+	} {
+		now = v.now
+		if _, err := w.Write([]byte(v.s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	actual := readRotatedFiles(t, dir)
+	expect := map[string]string{
+		"app.log":                              "fourth\n",
+		"app.log.20150304-075900.000000000.gz": "third\n",
+		"app.log.20150304-060000.000000000.gz": "first\nsecond\n",
+	}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`RotatingFileWriter{Interval: time.Hour, Compress: true}; files => %#v; want %#v`, actual, expect)
+	}
+}
+
+func TestRotatingFileWriter_Reopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestRotatingFileWriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	w := &log.RotatingFileWriter{Path: path}
+	defer w.Close()
+	if _, err := w.Write([]byte("before\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path, path+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("after\n")); err != nil {
+		t.Fatal(err)
+	}
+	actual := readRotatedFiles(t, dir)
+	expect := map[string]string{
+		"app.log":       "after\n",
+		"app.log.moved": "before\n",
+	}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`RotatingFileWriter.Reopen(); files => %#v; want %#v`, actual, expect)
+	}
+}
@@ -0,0 +1,155 @@
+package log
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/naoina/kocha/util"
+)
+
+// DefaultSampleInterval is the default interval of BasicSampler.
+const DefaultSampleInterval = 1 * time.Second
+
+// SuppressedMessage is the message of the summary entry of the suppressed
+// entries.
+const SuppressedMessage = "kocha: log: suppressed repeated messages"
+
+// Sampler is the interface that decides whether to output the log entries.
+type Sampler interface {
+	// Sample reports whether the entry should be output.
+	// Also Sample returns the summary entries of the previously suppressed
+	// entries. They will be output before the entry regardless of ok.
+	// The entry must not be modified.
+	Sample(entry *Entry) (ok bool, summaries []*Entry)
+}
+
+// SamplingLogger is the interface that is implemented by the Logger that
+// supports the Sampler.
+type SamplingLogger interface {
+	// SetSampler sets the sampler to decide whether to output the entries.
+	// The sampler is shared by the logger and its children. If s is nil, all
+	// entries will be output.
+	SetSampler(s Sampler)
+}
+
+// SetSampler sets the sampler of logger if logger implements SamplingLogger.
+// Otherwise, it does nothing.
+func SetSampler(logger Logger, s Sampler) {
+	if l, ok := logger.(SamplingLogger); ok {
+		l.SetSampler(s)
+	}
+}
+
+// BasicSampler is a Sampler that limits the identical messages and samples the
+// low level entries.
+//
+// The entries that have the same level and message are identical even if
+// their fields are different, e.g. the same error from the different
+// requests. When the identical entries exceed MaxRepeats within Interval, the
+// rest are suppressed, and the summary entry with SuppressedMessage that has
+// the "repeated_message" and "suppressed" fields will be output at the first
+// entry after the Interval.
+type BasicSampler struct {
+	// Interval is the interval to count the identical entries.
+	// If Interval is 0, DefaultSampleInterval will be used.
+	Interval time.Duration
+
+	// MaxRepeats is the maximum number of the identical entries per Interval.
+	// If MaxRepeats is 0, the identical entries won't be suppressed.
+	MaxRepeats int
+
+	// InfoRatio is the ratio of the INFO and DEBUG entries to output such as
+	// 0.1 for access logs. WARN and above are always output.
+	// If InfoRatio is 0 or greater than or equal to 1, all entries will be
+	// output.
+	InfoRatio float64
+
+	window  time.Time
+	counts  map[sampleKey]int
+	sampled uint64
+	mu      sync.Mutex
+}
+
+// sampleKey is the key of the identical entries.
+type sampleKey struct {
+	level   Level
+	message string
+}
+
+// Sample implements the Sampler interface.
+func (s *BasicSampler) Sample(entry *Entry) (ok bool, summaries []*Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := util.Now()
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultSampleInterval
+	}
+	if window := now.Truncate(interval); !window.Equal(s.window) {
+		summaries = s.summaries(now)
+		s.window = window
+		s.counts = nil
+	}
+	if !s.sampleRatio(entry) {
+		return false, summaries
+	}
+	if s.MaxRepeats <= 0 {
+		return true, summaries
+	}
+	if s.counts == nil {
+		s.counts = make(map[sampleKey]int)
+	}
+	key := sampleKey{level: entry.Level, message: entry.Message}
+	s.counts[key]++
+	return s.counts[key] <= s.MaxRepeats, summaries
+}
+
+// sampleRatio reports whether the entry should be output according to
+// InfoRatio. It outputs the first entry and every entry that the accumulated
+// ratio reaches the next integer.
+func (s *BasicSampler) sampleRatio(entry *Entry) bool {
+	if entry.Level == NONE || entry.Level >= WARN || s.InfoRatio <= 0 || s.InfoRatio >= 1 {
+		return true
+	}
+	n := float64(s.sampled)
+	s.sampled++
+	return math.Floor(n*s.InfoRatio) > math.Floor((n-1)*s.InfoRatio)
+}
+
+// summaries returns the summary entries of the suppressed entries in the
+// current window.
+func (s *BasicSampler) summaries(now time.Time) []*Entry {
+	var keys []sampleKey
+	for key, n := range s.counts {
+		if n > s.MaxRepeats {
+			keys = append(keys, key)
+		}
+	}
+	sort.Sort(sampleKeys(keys))
+	var summaries []*Entry
+	for _, key := range keys {
+		summaries = append(summaries, &Entry{
+			Level:   key.level,
+			Time:    now,
+			Message: SuppressedMessage,
+			Fields: Fields{
+				"repeated_message": key.message,
+				"suppressed":       s.counts[key] - s.MaxRepeats,
+			},
+		})
+	}
+	return summaries
+}
+
+type sampleKeys []sampleKey
+
+func (ks sampleKeys) Len() int      { return len(ks) }
+func (ks sampleKeys) Swap(i, j int) { ks[i], ks[j] = ks[j], ks[i] }
+func (ks sampleKeys) Less(i, j int) bool {
+	if ks[i].level != ks[j].level {
+		return ks[i].level < ks[j].level
+	}
+	return ks[i].message < ks[j].message
+}
@@ -0,0 +1,59 @@
+package log_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/naoina/kocha/log"
+	"github.com/naoina/kocha/util"
+)
+
+func TestBasicSampler(t *testing.T) {
+	now := time.Date(2015, 3, 4, 5, 6, 0, 0, time.UTC)
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	var buf bytes.Buffer
+	logger := log.New(&buf, &log.LogfmtFormatter{}, log.INFO)
+	log.SetSampler(logger, &log.BasicSampler{
+		Interval:   time.Minute,
+		MaxRepeats: 2,
+		InfoRatio:  0.5,
+	})
+	for i := 0; i < 4; i++ {
+		logger.With(log.Fields{"n": i}).Error("db down")
+	}
+	for i := 0; i < 4; i++ {
+		logger.With(log.Fields{"n": i}).Info("access")
+	}
+	logger.Warn("slow")
+	now = now.Add(1 * time.Minute)
+	logger.Error("recovered")
+	actual := buf.String()
+	expected := "" +
+		"level=ERROR time=2015-03-04T05:06:00Z message=\"db down\" n=0\n" +
+		"level=ERROR time=2015-03-04T05:06:00Z message=\"db down\" n=1\n" +
+		"level=INFO time=2015-03-04T05:06:00Z message=access n=0\n" +
+		"level=INFO time=2015-03-04T05:06:00Z message=access n=2\n" +
+		"level=WARN time=2015-03-04T05:06:00Z message=slow\n" +
+		"level=ERROR time=2015-03-04T05:07:00Z message=\"kocha: log: suppressed repeated messages\" repeated_message=\"db down\" suppressed=2\n" +
+		"level=ERROR time=2015-03-04T05:07:00Z message=recovered\n"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`logger with BasicSampler prints %#v; want %#v`, actual, expected)
+	}
+
+	buf.Reset()
+	log.SetSampler(logger, nil)
+	for i := 0; i < 3; i++ {
+		logger.Error("recovered")
+	}
+	actual = buf.String()
+	expected = "" +
+		"level=ERROR time=2015-03-04T05:07:00Z message=recovered\n" +
+		"level=ERROR time=2015-03-04T05:07:00Z message=recovered\n" +
+		"level=ERROR time=2015-03-04T05:07:00Z message=recovered\n"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf(`log.SetSampler(logger, nil); logger.Error("recovered") prints %#v; want %#v`, actual, expected)
+	}
+}
@@ -2,9 +2,16 @@ package kocha
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/naoina/kocha/log"
@@ -31,22 +38,22 @@ func (m *PanicRecoverMiddleware) Process(app *Application, c *Context, next func
 	defer func() {
 		defer func() {
 			if perr := recover(); perr != nil {
-				app.logStackAndError(perr)
+				logStackAndError(app.logger(c), perr)
 				err = fmt.Errorf("%v", perr)
 			}
 		}()
 		if err != nil {
-			app.Logger.Error(err)
+			log.WithError(app.logger(c), err).Error(err)
 			goto ERROR
 		} else if perr := recover(); perr != nil {
-			app.logStackAndError(perr)
+			logStackAndError(app.logger(c), perr)
 			goto ERROR
 		}
 		return
 	ERROR:
 		c.Response.reset()
 		if err = internalServerErrorController.GET(c); err != nil {
-			app.logStackAndError(err)
+			logStackAndError(app.logger(c), err)
 		}
 	}()
 	return next()
@@ -120,9 +127,9 @@ func (m *SessionMiddleware) before(app *Application, c *Context) (err error) {
 		case nil:
 			// do nothing.
 		case ErrSession:
-			app.Logger.Info(err)
+			log.Named(app.logger(c), "kocha.session").Info(err)
 		default:
-			app.Logger.Error(err)
+			log.Named(app.logger(c), "kocha.session").Error(err)
 		}
 		if c.Session == nil {
 			c.Session = make(Session)
@@ -206,7 +213,7 @@ func (m *FlashMiddleware) Process(app *Application, c *Context, next func() erro
 
 func (m *FlashMiddleware) before(app *Application, c *Context) error {
 	if c.Session == nil {
-		app.Logger.Error("kocha: FlashMiddleware hasn't been added after SessionMiddleware; it cannot be used")
+		log.Named(app.logger(c), "kocha.flash").Error("kocha: FlashMiddleware hasn't been added after SessionMiddleware; it cannot be used")
 		return nil
 	}
 	c.Flash = Flash{}
@@ -241,21 +248,785 @@ func (m *FlashMiddleware) after(app *Application, c *Context) error {
 	return nil
 }
 
-// Request logging middleware.
-type RequestLoggingMiddleware struct{}
+// CSRFMiddleware is a middleware to protect from Cross-Site Request Forgery.
+// CSRFMiddleware must be added after SessionMiddleware and FormMiddleware.
+//
+// A secret is stored into the session per client and a masked token derived
+// from the secret is set to Context.CSRFToken on each request.
+// The token must be sent by the form field or the request header on POST, PUT,
+// PATCH and DELETE requests. Otherwise, CSRFMiddleware renders 403 Forbidden.
+// The token can be embedded into templates by using "csrf_field" and
+// "csrf_meta" template functions.
+type CSRFMiddleware struct {
+	// SessionKey is the key of the session for the secret.
+	// Default is "_kocha._csrf._secret".
+	SessionKey string
 
+	// FieldName is the name of the form field for the token.
+	// Default is "_csrf_token".
+	FieldName string
+
+	// HeaderName is the name of the request header for the token.
+	// Default is "X-CSRF-Token".
+	HeaderName string
+
+	// ExemptRoutes is the route names that aren't verified.
+	ExemptRoutes []string
+}
+
+// Process implements the Middleware interface.
+func (m *CSRFMiddleware) Process(app *Application, c *Context, next func() error) error {
+	if c.Session == nil {
+		return fmt.Errorf("kocha: csrf: CSRFMiddleware hasn't been added after SessionMiddleware; it cannot be used")
+	}
+	secret, err := m.secret(c)
+	if err != nil {
+		return err
+	}
+	if c.CSRFToken, err = maskCSRFToken(secret); err != nil {
+		return err
+	}
+	if !m.isExempt(app, c) {
+		if err := m.verify(c, secret); err != nil {
+			log.Named(app.logger(c), "kocha.csrf").Warn(err)
+			return c.RenderError(http.StatusForbidden, nil, nil)
+		}
+	}
+	return next()
+}
+
+// Validate validates configuration of the CSRFMiddleware.
+func (m *CSRFMiddleware) Validate() error {
+	if m == nil {
+		return fmt.Errorf("kocha: csrf: middleware is nil")
+	}
+	if m.SessionKey == "" {
+		m.SessionKey = "_kocha._csrf._secret"
+	}
+	if m.FieldName == "" {
+		m.FieldName = "_csrf_token"
+	}
+	if m.HeaderName == "" {
+		m.HeaderName = "X-CSRF-Token"
+	}
+	return nil
+}
+
+func (m *CSRFMiddleware) secret(c *Context) ([]byte, error) {
+	if s := c.Session[m.SessionKey]; s != "" {
+		if secret, err := base64.URLEncoding.DecodeString(s); err == nil && len(secret) == csrfSecretLength {
+			return secret, nil
+		}
+	}
+	secret := make([]byte, csrfSecretLength)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, err
+	}
+	c.Session[m.SessionKey] = base64.URLEncoding.EncodeToString(secret)
+	return secret, nil
+}
+
+func (m *CSRFMiddleware) isExempt(app *Application, c *Context) bool {
+	switch c.Request.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	if len(m.ExemptRoutes) == 0 {
+		return false
+	}
+	name, _, _, _ := app.Router.dispatch(c.Request)
+	for _, route := range m.ExemptRoutes {
+		if route == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *CSRFMiddleware) verify(c *Context, secret []byte) error {
+	if c.Request.IsSSL() {
+		if err := verifyCSRFOrigin(c.Request); err != nil {
+			return err
+		}
+	}
+	token := c.Request.Header.Get(m.HeaderName)
+	if token == "" && c.Params != nil {
+		token = c.Params.Get(m.FieldName)
+	}
+	if token == "" {
+		return fmt.Errorf("kocha: csrf: token not found")
+	}
+	unmasked, err := unmaskCSRFToken(token)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(unmasked, secret) != 1 {
+		return fmt.Errorf("kocha: csrf: token mismatch")
+	}
+	return nil
+}
+
+// CORSMiddleware is a middleware to process Cross-Origin Resource Sharing.
+// See http://www.w3.org/TR/cors/ for more details.
+//
+// CORSMiddleware responds to a preflight request without calling the
+// following middlewares, so it should be set before DispatchMiddleware.
+// If you want to apply the different policies to the different routes, add
+// CORSMiddleware for each policy with Routes.
+// The responses of the routes have "Vary: Origin" unless AllowOrigins allows
+// any origins.
+type CORSMiddleware struct {
+	// AllowOrigins is the origins that are allowed to access.
+	// An origin can be an exact origin such as "https://example.com", a
+	// wildcard subdomain such as "https://*.example.com" or "*" that allows
+	// any origins.
+	AllowOrigins []string
+
+	// AllowOriginFunc is the predicate to determine whether the origin is
+	// allowed. It is used in addition to AllowOrigins.
+	AllowOriginFunc func(origin string) bool
+
+	// AllowMethods is the methods that are allowed in the cross-origin request.
+	// Default is GET, HEAD, POST, PUT, PATCH and DELETE.
+	AllowMethods []string
+
+	// AllowHeaders is the request headers that are allowed in the cross-origin
+	// request. If empty, the headers requested by the preflight request are
+	// allowed.
+	AllowHeaders []string
+
+	// ExposeHeaders is the response headers that are exposed to the client.
+	ExposeHeaders []string
+
+	// AllowCredentials indicates whether the request can include credentials
+	// such as cookies.
+	AllowCredentials bool
+
+	// MaxAge is how long the result of the preflight request can be cached.
+	// If 0, Access-Control-Max-Age header won't be sent.
+	MaxAge time.Duration
+
+	// Routes is the route names that CORSMiddleware applies.
+	// If empty, CORSMiddleware applies to all routes.
+	Routes []string
+}
+
+// Process implements the Middleware interface.
+func (m *CORSMiddleware) Process(app *Application, c *Context, next func() error) error {
+	if !m.isTarget(app, c) {
+		return next()
+	}
+	header := c.Response.Header()
+	// the response varies by the origin unless any origins are allowed.
+	// It also applies to the request without the origin, because the shared
+	// caches may reuse the response for the cross-origin requests.
+	anyOrigin := !m.AllowCredentials && m.allowsAnyOrigin()
+	if !anyOrigin {
+		header.Add("Vary", "Origin")
+	}
+	origin := c.Request.Header.Get("Origin")
+	if origin == "" && !anyOrigin {
+		return next()
+	}
+	preflight := origin != "" && c.Request.Method == "OPTIONS" && c.Request.Header.Get("Access-Control-Request-Method") != ""
+	if origin != "" && !m.isAllowedOrigin(origin) {
+		if preflight {
+			return c.RenderError(http.StatusForbidden, nil, nil)
+		}
+		return next()
+	}
+	if m.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if anyOrigin {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if !preflight {
+		if len(m.ExposeHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(m.ExposeHeaders, ", "))
+		}
+		return next()
+	}
+	method := strings.ToUpper(c.Request.Header.Get("Access-Control-Request-Method"))
+	if !m.isAllowedMethod(method) {
+		return c.RenderError(http.StatusForbidden, nil, nil)
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(m.AllowMethods, ", "))
+	if len(m.AllowHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(m.AllowHeaders, ", "))
+	} else if h := c.Request.Header.Get("Access-Control-Request-Headers"); h != "" {
+		header.Set("Access-Control-Allow-Headers", h)
+	}
+	if m.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.FormatInt(int64(m.MaxAge/time.Second), 10))
+	}
+	c.Response.StatusCode = http.StatusNoContent
+	c.Response.WriteHeader(c.Response.StatusCode)
+	return nil
+}
+
+// Validate validates configuration of the CORSMiddleware.
+func (m *CORSMiddleware) Validate() error {
+	if m == nil {
+		return fmt.Errorf("kocha: cors: middleware is nil")
+	}
+	if len(m.AllowOrigins) == 0 && m.AllowOriginFunc == nil {
+		return fmt.Errorf("kocha: cors: either AllowOrigins or AllowOriginFunc must be specified")
+	}
+	if m.AllowCredentials && m.allowsAnyOrigin() {
+		return fmt.Errorf(`kocha: cors: AllowCredentials cannot be used with the origin "*"`)
+	}
+	for _, origin := range m.AllowOrigins {
+		if origin != "*" && strings.Count(origin, "*") > 1 {
+			return fmt.Errorf("kocha: cors: invalid origin `%s'", origin)
+		}
+	}
+	if len(m.AllowMethods) == 0 {
+		m.AllowMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+	}
+	for i, method := range m.AllowMethods {
+		m.AllowMethods[i] = strings.ToUpper(method)
+	}
+	return nil
+}
+
+func (m *CORSMiddleware) isTarget(app *Application, c *Context) bool {
+	if len(m.Routes) == 0 {
+		return true
+	}
+	name, _, _, _ := app.Router.dispatch(c.Request)
+	for _, route := range m.Routes {
+		if route == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *CORSMiddleware) allowsAnyOrigin() bool {
+	for _, origin := range m.AllowOrigins {
+		if origin == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *CORSMiddleware) isAllowedOrigin(origin string) bool {
+	for _, allowed := range m.AllowOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if i := strings.IndexByte(allowed, '*'); i >= 0 {
+			prefix, suffix := allowed[:i], allowed[i+1:]
+			if len(origin) <= len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+				continue
+			}
+			if sub := origin[len(prefix) : len(origin)-len(suffix)]; !strings.ContainsAny(sub, "/:") {
+				return true
+			}
+		}
+	}
+	return m.AllowOriginFunc != nil && m.AllowOriginFunc(origin)
+}
+
+func (m *CORSMiddleware) isAllowedMethod(method string) bool {
+	for _, allowed := range m.AllowMethods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+// statusTooManyRequests is the HTTP status code 429 Too Many Requests.
+// http.StatusTooManyRequests isn't available in Go 1.5 and earlier.
+const statusTooManyRequests = 429
+
+// RateLimitMiddleware is a middleware to limit the rate of requests.
+// If the request exceeds the limit, RateLimitMiddleware renders 429 Too Many
+// Requests with Retry-After header. RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers are also sent to the client.
+type RateLimitMiddleware struct {
+	// Store is the storage of counters.
+	// Default is &MemoryRateLimitStore{}.
+	Store RateLimitStore
+
+	// Rate is the limit that applies to all routes.
+	// If zero value, the requests to the routes that aren't in RouteRates
+	// won't be limited.
+	Rate RateLimit
+
+	// RouteRates is the limits per route name.
+	// The counters of each route are independent of Rate.
+	RouteRates map[string]RateLimit
+
+	// KeyFunc returns a key to identify the client.
+	// Default is RemoteAddrRateLimitKey. See also SessionRateLimitKey.
+	KeyFunc func(c *Context) string
+}
+
+// Process implements the Middleware interface.
+func (m *RateLimitMiddleware) Process(app *Application, c *Context, next func() error) error {
+	rate, scope := m.Rate, "*"
+	if len(m.RouteRates) > 0 {
+		name, _, _, _ := app.Router.dispatch(c.Request)
+		if r, ok := m.RouteRates[name]; ok {
+			rate, scope = r, "route:"+name
+		}
+	}
+	if rate.isZero() {
+		return next()
+	}
+	result, err := m.Store.Take(scope+":"+m.KeyFunc(c), rate)
+	if err != nil {
+		log.Named(app.logger(c), "kocha.ratelimit").Error(err)
+		return next()
+	}
+	header := c.Response.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.FormatInt(durationToSeconds(result.Reset), 10))
+	if !result.Allowed {
+		header.Set("Retry-After", strconv.FormatInt(durationToSeconds(result.RetryAfter), 10))
+		return c.RenderError(statusTooManyRequests, nil, nil)
+	}
+	return next()
+}
+
+// Validate validates configuration of the RateLimitMiddleware.
+func (m *RateLimitMiddleware) Validate() error {
+	if m == nil {
+		return fmt.Errorf("kocha: ratelimit: middleware is nil")
+	}
+	if m.Rate.isZero() && len(m.RouteRates) == 0 {
+		return fmt.Errorf("kocha: ratelimit: either Rate or RouteRates must be specified")
+	}
+	if !m.Rate.isZero() {
+		if err := m.Rate.validate(); err != nil {
+			return err
+		}
+	}
+	for name, rate := range m.RouteRates {
+		if err := rate.validate(); err != nil {
+			return fmt.Errorf("%v (route `%s')", err, name)
+		}
+	}
+	if m.Store == nil {
+		m.Store = &MemoryRateLimitStore{}
+	}
+	if m.KeyFunc == nil {
+		m.KeyFunc = RemoteAddrRateLimitKey
+	}
+	if v, ok := m.Store.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// durationToSeconds returns d in seconds that rounded up.
+func durationToSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// SecurityHeadersMiddleware is a middleware to add the security related
+// headers to the response.
+//
+// The header fields of string type send the default value if empty, and don't
+// send the header if "-".
+type SecurityHeadersMiddleware struct {
+	// HSTSMaxAge is max-age of Strict-Transport-Security header.
+	// Strict-Transport-Security will be sent only for the secure connection.
+	// Default is 180 days. If -1, Strict-Transport-Security won't be sent.
+	HSTSMaxAge time.Duration
+
+	// HSTSIncludeSubdomains adds includeSubDomains to Strict-Transport-Security.
+	HSTSIncludeSubdomains bool
+
+	// HSTSPreload adds preload to Strict-Transport-Security.
+	HSTSPreload bool
+
+	// FrameOptions is the value of X-Frame-Options header.
+	// Default is "SAMEORIGIN".
+	FrameOptions string
+
+	// ContentTypeOptions is the value of X-Content-Type-Options header.
+	// Default is "nosniff".
+	ContentTypeOptions string
+
+	// ReferrerPolicy is the value of Referrer-Policy header.
+	// Default is "strict-origin-when-cross-origin".
+	ReferrerPolicy string
+
+	// ContentSecurityPolicy is the value of Content-Security-Policy header.
+	// If empty, Content-Security-Policy won't be sent.
+	// "{nonce}" in the policy will be replaced with the nonce-source such as
+	// 'nonce-...' that is generated per request. The nonce can be retrieved by
+	// Context.CSPNonce or "csp_nonce" template function.
+	// e.g. "default-src 'self'; script-src 'self' {nonce}"
+	ContentSecurityPolicy string
+
+	// CSPReportOnly sends Content-Security-Policy-Report-Only header instead
+	// of Content-Security-Policy.
+	CSPReportOnly bool
+
+	// CSPReportURI is the URI to send the violation reports.
+	// It will be added to the policy as report-uri directive.
+	// See also CSPReportController.
+	CSPReportURI string
+}
+
+// Process implements the Middleware interface.
+func (m *SecurityHeadersMiddleware) Process(app *Application, c *Context, next func() error) error {
+	header := c.Response.Header()
+	if m.HSTSMaxAge > 0 && c.Request.IsSSL() {
+		hsts := "max-age=" + strconv.FormatInt(int64(m.HSTSMaxAge/time.Second), 10)
+		if m.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if m.HSTSPreload {
+			hsts += "; preload"
+		}
+		header.Set("Strict-Transport-Security", hsts)
+	}
+	for _, h := range [][2]string{
+		{"X-Frame-Options", m.FrameOptions},
+		{"X-Content-Type-Options", m.ContentTypeOptions},
+		{"Referrer-Policy", m.ReferrerPolicy},
+	} {
+		if h[1] != "-" {
+			header.Set(h[0], h[1])
+		}
+	}
+	if m.ContentSecurityPolicy != "" {
+		nonce := util.GenerateRandomKey(16)
+		c.CSPNonce = base64.StdEncoding.EncodeToString(nonce)
+		policy := strings.Replace(m.ContentSecurityPolicy, "{nonce}", "'nonce-"+c.CSPNonce+"'", -1)
+		if m.CSPReportURI != "" {
+			policy += "; report-uri " + m.CSPReportURI
+		}
+		if m.CSPReportOnly {
+			header.Set("Content-Security-Policy-Report-Only", policy)
+		} else {
+			header.Set("Content-Security-Policy", policy)
+		}
+	}
+	return next()
+}
+
+// Validate validates configuration of the SecurityHeadersMiddleware.
+func (m *SecurityHeadersMiddleware) Validate() error {
+	if m == nil {
+		return fmt.Errorf("kocha: security headers: middleware is nil")
+	}
+	if m.HSTSMaxAge == 0 {
+		m.HSTSMaxAge = 180 * 24 * time.Hour
+	}
+	if m.FrameOptions == "" {
+		m.FrameOptions = "SAMEORIGIN"
+	}
+	if m.ContentTypeOptions == "" {
+		m.ContentTypeOptions = "nosniff"
+	}
+	if m.ReferrerPolicy == "" {
+		m.ReferrerPolicy = "strict-origin-when-cross-origin"
+	}
+	m.ContentSecurityPolicy = strings.TrimRight(strings.TrimSpace(m.ContentSecurityPolicy), ";")
+	if m.ContentSecurityPolicy == "" && m.CSPReportURI != "" {
+		return fmt.Errorf("kocha: security headers: CSPReportURI is specified, but ContentSecurityPolicy is empty")
+	}
+	return nil
+}
+
+// maxRequestIDLength is the maximum length of the request ID that is taken
+// from the request header.
+const maxRequestIDLength = 128
+
+// RequestIDMiddleware is a middleware to identify each request.
+//
+// RequestIDMiddleware takes the request ID from the request header if it is
+// valid, otherwise generates a new one. The request ID will be set to
+// Context.RequestID and the response header, and also be added to the fields
+// of Context.Logger. Therefore, RequestIDMiddleware should be set to first of
+// middlewares in order to log the request ID from the others.
+type RequestIDMiddleware struct {
+	// HeaderName is the name of header for the request ID.
+	// Default is "X-Request-Id".
+	HeaderName string
+
+	// Generator is the function to generate a new request ID.
+	// Default is the function that generates 16 random bytes in hex.
+	Generator func() (string, error)
+}
+
+// Process implements the Middleware interface.
+func (m *RequestIDMiddleware) Process(app *Application, c *Context, next func() error) error {
+	id := c.Request.Header.Get(m.HeaderName)
+	if !isValidRequestID(id) {
+		var err error
+		if id, err = m.Generator(); err != nil {
+			return err
+		}
+	}
+	c.RequestID = id
+	c.Response.Header().Set(m.HeaderName, id)
+	c.Logger = app.logger(c).With(log.Fields{requestIDKey: id})
+	return next()
+}
+
+// Validate validates the configuration of the middleware.
+func (m *RequestIDMiddleware) Validate() error {
+	if m == nil {
+		return fmt.Errorf("kocha: request id: middleware is nil")
+	}
+	if m.HeaderName == "" {
+		m.HeaderName = "X-Request-Id"
+	}
+	if m.Generator == nil {
+		m.Generator = generateRequestID
+	}
+	return nil
+}
+
+// requestIDKey is the key of the request ID for the log fields and the event
+// metadata.
+const requestIDKey = "request_id"
+
+func generateRequestID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// isValidRequestID returns whether the id can be used as the request ID.
+// The id that is taken from the client must be non-empty, and consists of
+// the limited characters in order to be safe for logging.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		switch b := id[i]; {
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		case strings.IndexByte("-_.:+/=@", b) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// AccessLogFormat represents a format of the access log.
+type AccessLogFormat int
+
+// The formats of the access log.
+const (
+	// AccessLogFields outputs the access log as the fields of the log entry.
+	AccessLogFields AccessLogFormat = iota
+
+	// AccessLogCombined outputs the access log as the message of the log entry
+	// in Apache combined log format. It's recommended to use with
+	// log.RawFormatter.
+	AccessLogCombined
+)
+
+// accessLogFields is the names of all fields of the access log.
+var accessLogFields = []string{
+	"method",
+	"uri",
+	"protocol",
+	"status",
+	"latency",
+	"size",
+	"remote_addr",
+	"user_agent",
+	"referer",
+	"route",
+	requestIDKey,
+}
+
+// RequestLoggingMiddleware is a middleware to output the access log.
+type RequestLoggingMiddleware struct {
+	// Format is the format of the access log.
+	// Default is AccessLogFields.
+	Format AccessLogFormat
+
+	// Fields is the names of fields to output when Format is AccessLogFields.
+	// The available names are "method", "uri", "protocol", "status",
+	// "latency" (in seconds), "size" (in bytes), "remote_addr", "user_agent",
+	// "referer", "route" and "request_id".
+	// If Fields is empty, all fields will be output.
+	Fields []string
+
+	// SkipPaths is the request paths that aren't logged such as the health
+	// check endpoint.
+	SkipPaths []string
+
+	// SampleSuccess is the sampling interval for the successful requests.
+	// If SampleSuccess is N, only one of every N requests that have the status
+	// code less than 400 will be logged. The other requests are always logged.
+	// If SampleSuccess is 0 or 1, all requests will be logged.
+	SampleSuccess int
+
+	fields    []string
+	skipPaths map[string]struct{}
+	count     uint64
+}
+
+// Process implements the Middleware interface.
 func (m *RequestLoggingMiddleware) Process(app *Application, c *Context, next func() error) error {
+	if _, skip := m.skipPaths[c.Request.URL.Path]; skip {
+		return next()
+	}
+	start := util.Now()
 	defer func() {
-		app.Logger.With(log.Fields{
-			"method":   c.Request.Method,
-			"uri":      c.Request.RequestURI,
-			"protocol": c.Request.Proto,
-			"status":   c.Response.StatusCode,
-		}).Info()
+		if !m.sampled(c.Response.StatusCode) {
+			return
+		}
+		logger := app.logger(c)
+		switch m.Format {
+		case AccessLogCombined:
+			logger.Info(m.combined(c, start))
+		default:
+			logger.With(m.logFields(c, util.Now().Sub(start))).Info()
+		}
 	}()
 	return next()
 }
 
+// Validate validates the configuration of the middleware.
+func (m *RequestLoggingMiddleware) Validate() error {
+	if m == nil {
+		return fmt.Errorf("kocha: request logging: middleware is nil")
+	}
+	switch m.Format {
+	case AccessLogFields, AccessLogCombined:
+	default:
+		return fmt.Errorf("kocha: request logging: unknown Format: %v", m.Format)
+	}
+	m.fields = accessLogFields
+	if len(m.Fields) > 0 {
+		m.fields = nil
+	NEXT:
+		for _, name := range m.Fields {
+			for _, field := range accessLogFields {
+				if name == field {
+					m.fields = append(m.fields, name)
+					continue NEXT
+				}
+			}
+			return fmt.Errorf("kocha: request logging: unknown field: %v", name)
+		}
+	}
+	m.skipPaths = make(map[string]struct{}, len(m.SkipPaths))
+	for _, path := range m.SkipPaths {
+		m.skipPaths[path] = struct{}{}
+	}
+	if m.SampleSuccess < 0 {
+		return fmt.Errorf("kocha: request logging: SampleSuccess must be greater than or equal to 0")
+	}
+	return nil
+}
+
+// sampled returns whether the request that responded with status should be
+// logged.
+func (m *RequestLoggingMiddleware) sampled(status int) bool {
+	if m.SampleSuccess <= 1 || status >= http.StatusBadRequest {
+		return true
+	}
+	return (atomic.AddUint64(&m.count, 1)-1)%uint64(m.SampleSuccess) == 0
+}
+
+func (m *RequestLoggingMiddleware) logFields(c *Context, latency time.Duration) log.Fields {
+	fields := make(log.Fields, len(m.fields))
+	for _, name := range m.fields {
+		switch name {
+		case "method":
+			fields[name] = c.Request.Method
+		case "uri":
+			fields[name] = c.Request.RequestURI
+		case "protocol":
+			fields[name] = c.Request.Proto
+		case "status":
+			fields[name] = c.Response.StatusCode
+		case "latency":
+			fields[name] = latency.Seconds()
+		case "size":
+			fields[name] = c.Response.size()
+		case "remote_addr":
+			fields[name] = c.Request.RemoteAddr
+		case "user_agent":
+			fields[name] = c.Request.UserAgent()
+		case "referer":
+			fields[name] = c.Request.Referer()
+		case "route":
+			fields[name] = c.Name
+		case requestIDKey:
+			if c.RequestID != "" {
+				fields[name] = c.RequestID
+			}
+		}
+	}
+	return fields
+}
+
+// combined returns the access log in Apache combined log format.
+func (m *RequestLoggingMiddleware) combined(c *Context, start time.Time) string {
+	size := "-"
+	if n := c.Response.size(); n > 0 {
+		size = strconv.Itoa(n)
+	}
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %s "%s" "%s"`,
+		orHyphen(c.Request.RemoteAddr),
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		escapeLogItem(c.Request.Method), escapeLogItem(c.Request.RequestURI), escapeLogItem(c.Request.Proto),
+		c.Response.StatusCode, size,
+		orHyphen(c.Request.Referer()), orHyphen(c.Request.UserAgent()))
+}
+
+// orHyphen returns the escaped s, or "-" if s is empty.
+func orHyphen(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return escapeLogItem(s)
+}
+
+// escapeLogItem escapes s in the same manner as Apache's ap_escape_logitem,
+// so that the client can't forge the fields or lines of the access log.
+// '"' and '\' are escaped by '\', and the control characters and the
+// non-ASCII bytes are escaped as "\xHH" except "\b", "\n", "\r", "\t" and
+// "\v".
+func escapeLogItem(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; {
+		case b == '"' || b == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(b)
+		case b == '\b':
+			buf.WriteString(`\b`)
+		case b == '\n':
+			buf.WriteString(`\n`)
+		case b == '\r':
+			buf.WriteString(`\r`)
+		case b == '\t':
+			buf.WriteString(`\t`)
+		case b == '\v':
+			buf.WriteString(`\v`)
+		case b < 0x20 || b >= 0x7f:
+			fmt.Fprintf(&buf, `\x%02x`, b)
+		default:
+			buf.WriteByte(b)
+		}
+	}
+	return buf.String()
+}
+
 // DispatchMiddleware is a middleware to dispatch handler.
 // DispatchMiddleware should be set to last of middlewares because doesn't call other middlewares after DispatchMiddleware.
 type DispatchMiddleware struct{}
@@ -2,11 +2,13 @@ package kocha_test
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"strings"
 	"testing"
@@ -59,6 +61,11 @@ func TestPanicRecoverMiddleware(t *testing.T) {
 		if !strings.Contains(actual, expect) {
 			t.Errorf(`PanicRecoverMiddleware: GET "/error"; log => %#v; want contains => %#v`, actual, expect)
 		}
+		for _, expect := range []string{"\terror:panic test\terror_type:string\tstack:goroutine ", "FixtureErrorTestCtrl"} {
+			if !strings.Contains(actual, expect) {
+				t.Errorf(`PanicRecoverMiddleware: GET "/error"; log => %#v; want contains => %#v`, actual, expect)
+			}
+		}
 	}()
 
 	func() {
@@ -637,3 +644,706 @@ func TestFlashMiddleware(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestFlashMiddleware_withNowAndKeep(t *testing.T) {
+	app := kocha.NewTestApp()
+	m := &kocha.FlashMiddleware{}
+	c := &kocha.Context{Session: make(kocha.Session)}
+	if err := m.Process(app, c, func() error {
+		c.Flash.Add(kocha.FlashSuccess, "saved")
+		c.Flash.Now(kocha.FlashInfo, "now only")
+		actual := c.Flash.Get(kocha.FlashInfo)
+		expected := "now only"
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf(`FlashMiddleware.Process(app, c, func); c.Flash.Get(%#v) => %#v; want %#v`, kocha.FlashInfo, actual, expected)
+		}
+		return nil
+	}); err != nil {
+		t.Error(err)
+	}
+
+	c.Flash = nil
+	if err := m.Process(app, c, func() error {
+		var actual interface{} = c.Flash.Get(kocha.FlashInfo)
+		var expected interface{} = ""
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf(`FlashMiddleware.Process(app, c, func) then Process(app, c, func); c.Flash.Get(%#v) => %#v; want %#v`, kocha.FlashInfo, actual, expected)
+		}
+		actual = c.Flash.Get(kocha.FlashSuccess)
+		expected = "saved"
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf(`FlashMiddleware.Process(app, c, func) then Process(app, c, func); c.Flash.Get(%#v) => %#v; want %#v`, kocha.FlashSuccess, actual, expected)
+		}
+		c.Flash.Keep()
+		return nil
+	}); err != nil {
+		t.Error(err)
+	}
+
+	c.Flash = nil
+	if err := m.Process(app, c, func() error {
+		actual := c.Flash.Get(kocha.FlashSuccess)
+		expected := "saved"
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf(`FlashMiddleware.Process(app, c, func) then Process(app, c, func); kept; c.Flash.Get(%#v) => %#v; want %#v`, kocha.FlashSuccess, actual, expected)
+		}
+		return nil
+	}); err != nil {
+		t.Error(err)
+	}
+
+	c.Flash = nil
+	if err := m.Process(app, c, func() error {
+		actual := c.Flash.Len()
+		expected := 0
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf(`FlashMiddleware.Process(app, c, func) then Process(app, c, func); after kept; c.Flash.Len() => %#v; want %#v`, actual, expected)
+		}
+		return nil
+	}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFlashMiddleware_addAfterLoaded(t *testing.T) {
+	app := kocha.NewTestApp()
+	m := &kocha.FlashMiddleware{}
+	c := &kocha.Context{Session: make(kocha.Session)}
+	if err := m.Process(app, c, func() error {
+		c.Flash.Add(kocha.FlashSuccess, "first")
+		return nil
+	}); err != nil {
+		t.Error(err)
+	}
+
+	c.Flash = nil
+	if err := m.Process(app, c, func() error {
+		c.Flash.Messages(kocha.FlashSuccess)
+		c.Flash.Add(kocha.FlashSuccess, "second")
+		return nil
+	}); err != nil {
+		t.Error(err)
+	}
+
+	c.Flash = nil
+	if err := m.Process(app, c, func() error {
+		actual := c.Flash.Messages(kocha.FlashSuccess)
+		expected := []kocha.FlashMessage{{Text: "second"}}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf(`FlashMiddleware.Process(app, c, func) then Process(app, c, func); c.Flash.Messages(%#v) => %#v; want %#v`, kocha.FlashSuccess, actual, expected)
+		}
+		return nil
+	}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	app := kocha.NewTestApp()
+	m := &kocha.CSRFMiddleware{ExemptRoutes: []string{"post_test"}}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	app.Config.Middlewares = []kocha.Middleware{m}
+	sess := make(kocha.Session)
+	process := func(method, path string, header http.Header, params url.Values) (c *kocha.Context, called bool) {
+		r, err := http.NewRequest(method, "https://example.com"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.TLS = &tls.ConnectionState{}
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		c = &kocha.Context{
+			Request:  &kocha.Request{Request: r},
+			Response: &kocha.Response{ResponseWriter: httptest.NewRecorder()},
+			Params:   &kocha.Params{Values: params},
+			Session:  sess,
+			App:      app,
+		}
+		if err := m.Process(app, c, func() error {
+			called = true
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return c, called
+	}
+
+	c, called := process("GET", "/", nil, url.Values{})
+	if !called {
+		t.Errorf(`CSRFMiddleware.Process(app, c, func) with GET; next called => %#v; want %#v`, called, true)
+	}
+	if sess["_kocha._csrf._secret"] == "" {
+		t.Errorf(`CSRFMiddleware.Process(app, c, func) with GET; secret => %#v; want not empty`, sess["_kocha._csrf._secret"])
+	}
+	token := c.CSRFToken
+	if token == "" {
+		t.Fatalf(`CSRFMiddleware.Process(app, c, func) with GET; c.CSRFToken => %#v; want not empty`, token)
+	}
+	if c, _ := process("GET", "/", nil, url.Values{}); c.CSRFToken == token {
+		t.Errorf(`CSRFMiddleware.Process(app, c, func) twice; c.CSRFToken => %#v; want not %#v`, c.CSRFToken, token)
+	}
+
+	origin := http.Header{"Origin": {"https://example.com"}}
+	for _, v := range []struct {
+		method string
+		path   string
+		header http.Header
+		params url.Values
+		expect bool
+	}{
+		{"POST", "/", origin, url.Values{}, false},
+		{"POST", "/", origin, url.Values{"_csrf_token": {"invalid"}}, false},
+		{"POST", "/", origin, url.Values{"_csrf_token": {token}}, true},
+		{"DELETE", "/", http.Header{"Origin": {"https://example.com"}, "X-Csrf-Token": {token}}, url.Values{}, true},
+		{"PUT", "/", http.Header{"Referer": {"https://example.com/user/1"}, "X-Csrf-Token": {token}}, url.Values{}, true},
+		{"PATCH", "/", http.Header{"Origin": {"https://evil.example.com"}, "X-Csrf-Token": {token}}, url.Values{}, false},
+		{"PATCH", "/", http.Header{"X-Csrf-Token": {token}}, url.Values{}, false},
+		{"POST", "/post_test", nil, url.Values{}, true},
+	} {
+		c, called := process(v.method, v.path, v.header, v.params)
+		if called != v.expect {
+			t.Errorf(`CSRFMiddleware.Process(app, c, func) with %v %v %v %v; next called => %#v; want %#v`, v.method, v.path, v.header, v.params, called, v.expect)
+		}
+		if !v.expect && c.Response.StatusCode != http.StatusForbidden {
+			t.Errorf(`CSRFMiddleware.Process(app, c, func) with %v %v %v %v; status => %#v; want %#v`, v.method, v.path, v.header, v.params, c.Response.StatusCode, http.StatusForbidden)
+		}
+	}
+}
+
+func TestCSRFMiddleware_withNilSession(t *testing.T) {
+	app := kocha.NewTestApp()
+	m := &kocha.CSRFMiddleware{}
+	c := &kocha.Context{Session: nil}
+	if err := m.Process(app, c, func() error {
+		t.Errorf(`CSRFMiddleware.Process(app, c, func) with nil session; next has been called`)
+		return nil
+	}); err == nil {
+		t.Errorf(`CSRFMiddleware.Process(app, c, func) with nil session => %#v; want error`, err)
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	app := kocha.NewTestApp()
+	process := func(m *kocha.CORSMiddleware, method, path string, header http.Header) (c *kocha.Context, called bool) {
+		r, err := http.NewRequest(method, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		c = &kocha.Context{
+			Request:  &kocha.Request{Request: r},
+			Response: &kocha.Response{ResponseWriter: httptest.NewRecorder()},
+			App:      app,
+		}
+		if err := m.Process(app, c, func() error {
+			called = true
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return c, called
+	}
+	preflight := func(origin, method string) http.Header {
+		return http.Header{
+			"Origin":                         {origin},
+			"Access-Control-Request-Method":  {method},
+			"Access-Control-Request-Headers": {"X-Test"},
+		}
+	}
+
+	m := &kocha.CORSMiddleware{
+		AllowOrigins:     []string{"https://example.com", "https://*.example.net"},
+		AllowOriginFunc:  func(origin string) bool { return origin == "http://localhost:9100" },
+		AllowCredentials: true,
+		ExposeHeaders:    []string{"X-Total-Count"},
+		MaxAge:           10 * time.Minute,
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		method string
+		header http.Header
+		called bool
+		expect map[string]string
+	}{
+		{"GET", nil, true, map[string]string{
+			"Access-Control-Allow-Origin": "",
+			"Vary":                        "Origin",
+		}},
+		{"GET", http.Header{"Origin": {"https://example.com"}}, true, map[string]string{
+			"Access-Control-Allow-Origin":      "https://example.com",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Expose-Headers":    "X-Total-Count",
+			"Vary":                             "Origin",
+		}},
+		{"GET", http.Header{"Origin": {"https://api.example.net"}}, true, map[string]string{
+			"Access-Control-Allow-Origin": "https://api.example.net",
+		}},
+		{"GET", http.Header{"Origin": {"https://example.net"}}, true, map[string]string{
+			"Access-Control-Allow-Origin": "",
+			"Vary":                        "Origin",
+		}},
+		{"GET", http.Header{"Origin": {"http://localhost:9100"}}, true, map[string]string{
+			"Access-Control-Allow-Origin": "http://localhost:9100",
+		}},
+		{"OPTIONS", preflight("https://example.com", "put"), false, map[string]string{
+			"Access-Control-Allow-Origin":  "https://example.com",
+			"Access-Control-Allow-Methods": "GET, HEAD, POST, PUT, PATCH, DELETE",
+			"Access-Control-Allow-Headers": "X-Test",
+			"Access-Control-Max-Age":       "600",
+		}},
+		{"OPTIONS", preflight("https://example.com", "TRACE"), false, map[string]string{
+			"Access-Control-Allow-Methods": "",
+		}},
+		{"OPTIONS", preflight("https://evil.example.com", "GET"), false, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+	} {
+		c, called := process(m, v.method, "/", v.header)
+		if called != v.called {
+			t.Errorf(`CORSMiddleware.Process(app, c, func) with %v %v; next called => %#v; want %#v`, v.method, v.header, called, v.called)
+		}
+		for key, expect := range v.expect {
+			actual := c.Response.Header().Get(key)
+			if !reflect.DeepEqual(actual, expect) {
+				t.Errorf(`CORSMiddleware.Process(app, c, func) with %v %v; header %v => %#v; want %#v`, v.method, v.header, key, actual, expect)
+			}
+		}
+	}
+
+	m = &kocha.CORSMiddleware{
+		AllowOrigins: []string{"*"},
+		Routes:       []string{"json"},
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		path   string
+		header http.Header
+		expect map[string]string
+	}{
+		{"/json", http.Header{"Origin": {"https://example.com"}}, map[string]string{
+			"Access-Control-Allow-Origin": "*",
+			"Vary":                        "",
+		}},
+		{"/json", nil, map[string]string{
+			"Access-Control-Allow-Origin": "*",
+			"Vary":                        "",
+		}},
+		{"/", http.Header{"Origin": {"https://example.com"}}, map[string]string{
+			"Access-Control-Allow-Origin": "",
+			"Vary":                        "",
+		}},
+	} {
+		c, _ := process(m, "GET", v.path, v.header)
+		for key, expect := range v.expect {
+			actual := c.Response.Header().Get(key)
+			if !reflect.DeepEqual(actual, expect) {
+				t.Errorf(`CORSMiddleware.Process(app, c, func) with GET %v %v; header %v => %#v; want %#v`, v.path, v.header, key, actual, expect)
+			}
+		}
+	}
+}
+
+func TestCORSMiddleware_Validate(t *testing.T) {
+	for _, v := range []struct {
+		m      *kocha.CORSMiddleware
+		expect error
+	}{
+		{nil, fmt.Errorf("kocha: cors: middleware is nil")},
+		{&kocha.CORSMiddleware{}, fmt.Errorf("kocha: cors: either AllowOrigins or AllowOriginFunc must be specified")},
+		{&kocha.CORSMiddleware{AllowOrigins: []string{"*"}, AllowCredentials: true}, fmt.Errorf(`kocha: cors: AllowCredentials cannot be used with the origin "*"`)},
+		{&kocha.CORSMiddleware{AllowOrigins: []string{"https://*.*.example.com"}}, fmt.Errorf("kocha: cors: invalid origin `https://*.*.example.com'")},
+		{&kocha.CORSMiddleware{AllowOrigins: []string{"*"}}, nil},
+	} {
+		actual := v.m.Validate()
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`CORSMiddleware.Validate() with %#v => %#v; want %#v`, v.m, actual, expect)
+		}
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	now := time.Date(2015, 12, 1, 0, 0, 0, 0, time.UTC)
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	app := kocha.NewTestApp()
+	m := &kocha.RateLimitMiddleware{
+		Rate: kocha.RateLimit{Limit: 2, Period: time.Minute},
+		RouteRates: map[string]kocha.RateLimit{
+			"post_test": {Limit: 1, Period: time.Minute},
+		},
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	process := func(path, remoteAddr string) (c *kocha.Context, called bool) {
+		r, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c = &kocha.Context{
+			Request:  &kocha.Request{Request: r, RemoteAddr: remoteAddr},
+			Response: &kocha.Response{ResponseWriter: httptest.NewRecorder()},
+			App:      app,
+		}
+		if err := m.Process(app, c, func() error {
+			called = true
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return c, called
+	}
+	for _, v := range []struct {
+		path       string
+		remoteAddr string
+		called     bool
+		expect     map[string]string
+	}{
+		{"/", "192.0.2.1", true, map[string]string{"RateLimit-Limit": "2", "RateLimit-Remaining": "1", "RateLimit-Reset": "30", "Retry-After": ""}},
+		{"/json", "192.0.2.1", true, map[string]string{"RateLimit-Limit": "2", "RateLimit-Remaining": "0", "RateLimit-Reset": "60", "Retry-After": ""}},
+		{"/", "192.0.2.1", false, map[string]string{"RateLimit-Limit": "2", "RateLimit-Remaining": "0", "RateLimit-Reset": "60", "Retry-After": "30"}},
+		{"/", "192.0.2.2", true, map[string]string{"RateLimit-Limit": "2", "RateLimit-Remaining": "1"}},
+		{"/post_test", "192.0.2.1", true, map[string]string{"RateLimit-Limit": "1", "RateLimit-Remaining": "0"}},
+		{"/post_test", "192.0.2.1", false, map[string]string{"RateLimit-Limit": "1", "Retry-After": "60"}},
+	} {
+		c, called := process(v.path, v.remoteAddr)
+		if called != v.called {
+			t.Errorf(`RateLimitMiddleware.Process(app, c, func) with GET %v from %v; next called => %#v; want %#v`, v.path, v.remoteAddr, called, v.called)
+		}
+		if !v.called && c.Response.StatusCode != 429 {
+			t.Errorf(`RateLimitMiddleware.Process(app, c, func) with GET %v from %v; status => %#v; want %#v`, v.path, v.remoteAddr, c.Response.StatusCode, 429)
+		}
+		for key, expect := range v.expect {
+			actual := c.Response.Header().Get(key)
+			if !reflect.DeepEqual(actual, expect) {
+				t.Errorf(`RateLimitMiddleware.Process(app, c, func) with GET %v from %v; header %v => %#v; want %#v`, v.path, v.remoteAddr, key, actual, expect)
+			}
+		}
+	}
+}
+
+func TestRateLimitMiddleware_Validate(t *testing.T) {
+	for _, v := range []struct {
+		m      *kocha.RateLimitMiddleware
+		expect error
+	}{
+		{nil, fmt.Errorf("kocha: ratelimit: middleware is nil")},
+		{&kocha.RateLimitMiddleware{}, fmt.Errorf("kocha: ratelimit: either Rate or RouteRates must be specified")},
+		{&kocha.RateLimitMiddleware{Rate: kocha.RateLimit{Limit: 1}}, fmt.Errorf("kocha: ratelimit: Period must be greater than 0, but 0s")},
+		{&kocha.RateLimitMiddleware{RouteRates: map[string]kocha.RateLimit{"root": {Period: time.Second}}}, fmt.Errorf("kocha: ratelimit: Limit must be greater than 0, but 0 (route `root')")},
+	} {
+		actual := v.m.Validate()
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`RateLimitMiddleware.Validate() with %#v => %#v; want %#v`, v.m, actual, expect)
+		}
+	}
+
+	m := &kocha.RateLimitMiddleware{Rate: kocha.RateLimit{Limit: 1, Period: time.Second}}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var actual interface{} = m.Store
+	var expect interface{} = &kocha.MemoryRateLimitStore{}
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`RateLimitMiddleware.Validate(); Store => %#v; want %#v`, actual, expect)
+	}
+	if m.KeyFunc == nil {
+		t.Errorf(`RateLimitMiddleware.Validate(); KeyFunc => nil; want not nil`)
+	}
+}
+
+func TestSecurityHeadersMiddleware(t *testing.T) {
+	app := kocha.NewTestApp()
+	process := func(m *kocha.SecurityHeadersMiddleware, ssl bool) *kocha.Context {
+		r, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ssl {
+			r.TLS = &tls.ConnectionState{}
+		}
+		c := &kocha.Context{
+			Request:  &kocha.Request{Request: r},
+			Response: &kocha.Response{ResponseWriter: httptest.NewRecorder()},
+			App:      app,
+		}
+		if err := m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Process(app, c, func() error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	for _, v := range []struct {
+		m      *kocha.SecurityHeadersMiddleware
+		ssl    bool
+		expect map[string]string
+	}{
+		{&kocha.SecurityHeadersMiddleware{}, false, map[string]string{
+			"Strict-Transport-Security": "",
+			"X-Frame-Options":           "SAMEORIGIN",
+			"X-Content-Type-Options":    "nosniff",
+			"Referrer-Policy":           "strict-origin-when-cross-origin",
+			"Content-Security-Policy":   "",
+		}},
+		{&kocha.SecurityHeadersMiddleware{}, true, map[string]string{
+			"Strict-Transport-Security": "max-age=15552000",
+		}},
+		{&kocha.SecurityHeadersMiddleware{
+			HSTSMaxAge:            time.Hour,
+			HSTSIncludeSubdomains: true,
+			HSTSPreload:           true,
+			FrameOptions:          "DENY",
+			ReferrerPolicy:        "-",
+		}, true, map[string]string{
+			"Strict-Transport-Security": "max-age=3600; includeSubDomains; preload",
+			"X-Frame-Options":           "DENY",
+			"Referrer-Policy":           "",
+		}},
+		{&kocha.SecurityHeadersMiddleware{HSTSMaxAge: -1}, true, map[string]string{
+			"Strict-Transport-Security": "",
+		}},
+		{&kocha.SecurityHeadersMiddleware{
+			ContentSecurityPolicy: "default-src 'self';",
+			CSPReportURI:          "/csp_report",
+		}, false, map[string]string{
+			"Content-Security-Policy":             "default-src 'self'; report-uri /csp_report",
+			"Content-Security-Policy-Report-Only": "",
+		}},
+		{&kocha.SecurityHeadersMiddleware{
+			ContentSecurityPolicy: "default-src 'self'",
+			CSPReportOnly:         true,
+		}, false, map[string]string{
+			"Content-Security-Policy":             "",
+			"Content-Security-Policy-Report-Only": "default-src 'self'",
+		}},
+	} {
+		c := process(v.m, v.ssl)
+		for key, expect := range v.expect {
+			actual := c.Response.Header().Get(key)
+			if !reflect.DeepEqual(actual, expect) {
+				t.Errorf(`SecurityHeadersMiddleware.Process(app, c, func) with %#v; header %v => %#v; want %#v`, v.m, key, actual, expect)
+			}
+		}
+	}
+
+	m := &kocha.SecurityHeadersMiddleware{ContentSecurityPolicy: "script-src 'self' {nonce}"}
+	c := process(m, false)
+	if c.CSPNonce == "" {
+		t.Fatalf(`SecurityHeadersMiddleware.Process(app, c, func); c.CSPNonce => %#v; want not empty`, c.CSPNonce)
+	}
+	actual := c.Response.Header().Get("Content-Security-Policy")
+	expect := "script-src 'self' 'nonce-" + c.CSPNonce + "'"
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`SecurityHeadersMiddleware.Process(app, c, func); Content-Security-Policy => %#v; want %#v`, actual, expect)
+	}
+	if c2 := process(m, false); c2.CSPNonce == c.CSPNonce {
+		t.Errorf(`SecurityHeadersMiddleware.Process(app, c, func) twice; c.CSPNonce => %#v; want not %#v`, c2.CSPNonce, c.CSPNonce)
+	}
+
+	m = &kocha.SecurityHeadersMiddleware{CSPReportURI: "/csp_report"}
+	if err := m.Validate(); err == nil {
+		t.Errorf(`SecurityHeadersMiddleware.Validate() with %#v => %#v; want error`, m, err)
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	app := kocha.NewTestApp()
+	var buf bytes.Buffer
+	app.Logger = log.New(&buf, &log.LTSVFormatter{}, log.INFO)
+	m := &kocha.RequestIDMiddleware{
+		Generator: func() (string, error) { return "generated", nil },
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		header string
+		expect string
+	}{
+		{"", "generated"},
+		{"abc-123_XYZ.4:5", "abc-123_XYZ.4:5"},
+		{"invalid id", "generated"},
+		{"invalid\nid", "generated"},
+		{strings.Repeat("a", 129), "generated"},
+	} {
+		buf.Reset()
+		r, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.header != "" {
+			r.Header.Set("X-Request-Id", v.header)
+		}
+		c := &kocha.Context{
+			Request:  &kocha.Request{Request: r},
+			Response: &kocha.Response{ResponseWriter: httptest.NewRecorder()},
+			App:      app,
+		}
+		if err := m.Process(app, c, func() error {
+			c.Logger.Info("test")
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		var actual interface{} = c.RequestID
+		var expect interface{} = v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`RequestIDMiddleware.Process(app, c, next) with X-Request-Id %#v; c.RequestID => %#v; want %#v`, v.header, actual, expect)
+		}
+		actual = c.Response.Header().Get("X-Request-Id")
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`RequestIDMiddleware.Process(app, c, next) with X-Request-Id %#v; X-Request-Id header => %#v; want %#v`, v.header, actual, expect)
+		}
+		actual = strings.Contains(buf.String(), "\trequest_id:"+v.expect+"\n")
+		expect = true
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`RequestIDMiddleware.Process(app, c, next) with X-Request-Id %#v; log => %#v; want to contain request_id`, v.header, buf.String())
+		}
+	}
+}
+
+func TestRequestIDMiddleware_Validate(t *testing.T) {
+	var m *kocha.RequestIDMiddleware
+	var actual interface{} = m.Validate()
+	var expect interface{} = fmt.Errorf("kocha: request id: middleware is nil")
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`RequestIDMiddleware.Validate() => %#v; want %#v`, actual, expect)
+	}
+
+	m = &kocha.RequestIDMiddleware{}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	actual = m.HeaderName
+	expect = "X-Request-Id"
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`RequestIDMiddleware.Validate(); HeaderName => %#v; want %#v`, actual, expect)
+	}
+	id, err := m.Generator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual = len(id)
+	expect = 32
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`len(RequestIDMiddleware.Generator()) => %#v; want %#v`, actual, expect)
+	}
+}
+
+func TestRequestLoggingMiddleware(t *testing.T) {
+	now := time.Date(2015, 3, 4, 5, 6, 7, 0, time.UTC)
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	app := kocha.NewTestApp()
+	var buf bytes.Buffer
+	app.Logger = log.New(&buf, &log.RawFormatter{}, log.INFO)
+	userAgent := "test-agent"
+	process := func(m *kocha.RequestLoggingMiddleware, path string, status int) string {
+		buf.Reset()
+		r, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.RequestURI = path
+		r.Header.Set("User-Agent", userAgent)
+		c := &kocha.Context{
+			Name:      "root",
+			Request:   &kocha.Request{Request: r, RemoteAddr: "192.0.2.1"},
+			Response:  &kocha.Response{ResponseWriter: httptest.NewRecorder(), StatusCode: status},
+			App:       app,
+			Logger:    app.Logger,
+			RequestID: "reqid",
+		}
+		if err := m.Process(app, c, func() error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	m := &kocha.RequestLoggingMiddleware{Format: kocha.AccessLogCombined, SkipPaths: []string{"/healthz"}}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var actual interface{} = process(m, "/?q=1", http.StatusOK)
+	var expect interface{} = `192.0.2.1 - - [04/Mar/2015:05:06:07 +0000] "GET /?q=1 HTTP/1.1" 200 - "-" "test-agent"` + "\n"
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`RequestLoggingMiddleware{Format: AccessLogCombined}.Process(app, c, next) logs %#v; want %#v`, actual, expect)
+	}
+	userAgent = "evil\" \"forged\\\n\x01"
+	actual = process(m, "/", http.StatusOK)
+	expect = `192.0.2.1 - - [04/Mar/2015:05:06:07 +0000] "GET / HTTP/1.1" 200 - "-" "evil\" \"forged\\\n\x01"` + "\n"
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`RequestLoggingMiddleware{Format: AccessLogCombined}.Process(app, c, next) with User-Agent %#v logs %#v; want %#v`, userAgent, actual, expect)
+	}
+	userAgent = "test-agent"
+	actual = process(m, "/healthz", http.StatusOK)
+	expect = ""
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`RequestLoggingMiddleware{SkipPaths: %#v}.Process(app, c, next) logs %#v; want %#v`, m.SkipPaths, actual, expect)
+	}
+
+	app.Logger = log.New(&buf, &log.LTSVFormatter{}, log.INFO)
+	m = &kocha.RequestLoggingMiddleware{Fields: []string{"status", "route", "request_id", "latency"}}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	actual = process(m, "/", http.StatusOK)
+	expect = "level:INFO\ttime:" + now.Format(time.RFC3339Nano) + "\tlatency:0\trequest_id:reqid\troute:root\tstatus:200\n"
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`RequestLoggingMiddleware{Fields: %#v}.Process(app, c, next) logs %#v; want %#v`, m.Fields, actual, expect)
+	}
+
+	m = &kocha.RequestLoggingMiddleware{Fields: []string{"status"}, SampleSuccess: 3}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range []struct {
+		status int
+		expect bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusOK, false},
+		{http.StatusNotFound, true},
+		{http.StatusOK, false},
+		{http.StatusInternalServerError, true},
+		{http.StatusOK, true},
+	} {
+		actual = process(m, "/", v.status) != ""
+		expect = v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`RequestLoggingMiddleware{SampleSuccess: 3}.Process(app, c, next) #%d with status %v; logged => %#v; want %#v`, i, v.status, actual, expect)
+		}
+	}
+}
+
+func TestRequestLoggingMiddleware_Validate(t *testing.T) {
+	for _, v := range []struct {
+		m      *kocha.RequestLoggingMiddleware
+		expect error
+	}{
+		{nil, fmt.Errorf("kocha: request logging: middleware is nil")},
+		{&kocha.RequestLoggingMiddleware{}, nil},
+		{&kocha.RequestLoggingMiddleware{Format: 100}, fmt.Errorf("kocha: request logging: unknown Format: 100")},
+		{&kocha.RequestLoggingMiddleware{Fields: []string{"status", "unknown"}}, fmt.Errorf("kocha: request logging: unknown field: unknown")},
+		{&kocha.RequestLoggingMiddleware{SampleSuccess: -1}, fmt.Errorf("kocha: request logging: SampleSuccess must be greater than or equal to 0")},
+	} {
+		actual := v.m.Validate()
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`%#v.Validate() => %#v; want %#v`, v.m, actual, expect)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package kocha
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/naoina/kocha/util"
+)
+
+// RateLimitAlgorithm represents an algorithm of the rate limiting.
+type RateLimitAlgorithm uint8
+
+// The algorithms of the rate limiting.
+const (
+	// TokenBucket is the token bucket algorithm.
+	// It allows the burst up to RateLimit.Limit and refills the tokens
+	// continuously.
+	TokenBucket RateLimitAlgorithm = iota
+
+	// SlidingWindow is the sliding window algorithm.
+	// It estimates the number of requests in the last RateLimit.Period from the
+	// counts of the current and the previous fixed windows.
+	SlidingWindow
+)
+
+// RateLimit represents a limit of the rate.
+type RateLimit struct {
+	Limit     int                // maximum number of requests per Period.
+	Period    time.Duration      // period of the limit.
+	Algorithm RateLimitAlgorithm // algorithm of the rate limiting.
+}
+
+func (r RateLimit) isZero() bool {
+	return r.Limit == 0 && r.Period == 0
+}
+
+func (r RateLimit) validate() error {
+	if r.Limit < 1 {
+		return fmt.Errorf("kocha: ratelimit: Limit must be greater than 0, but %v", r.Limit)
+	}
+	if r.Period <= 0 {
+		return fmt.Errorf("kocha: ratelimit: Period must be greater than 0, but %v", r.Period)
+	}
+	switch r.Algorithm {
+	case TokenBucket, SlidingWindow:
+		return nil
+	}
+	return fmt.Errorf("kocha: ratelimit: unknown algorithm: %v", r.Algorithm)
+}
+
+// RateLimitResult represents a result of the rate limiting.
+type RateLimitResult struct {
+	Allowed    bool          // whether the request is allowed.
+	Limit      int           // maximum number of requests per period.
+	Remaining  int           // remaining number of requests.
+	Reset      time.Duration // time until the quota is fully restored.
+	RetryAfter time.Duration // time until the next request is allowed if not allowed.
+}
+
+// RateLimitStore is the interface that storage of counters for the rate
+// limiting.
+// The implementation must be safe for concurrent use. If you want to share the
+// counters between multiple servers, implement RateLimitStore on the shared
+// storage such as Redis.
+type RateLimitStore interface {
+	// Take consumes a quota of key in accordance with rate.
+	Take(key string, rate RateLimit) (RateLimitResult, error)
+}
+
+// DefaultRateLimitShards is the default number of shards of
+// MemoryRateLimitStore.
+const DefaultRateLimitShards = 32
+
+// MemoryRateLimitStore implements the RateLimitStore interface.
+// It stores the counters to memory that is split into the shards to reduce
+// lock contentions. Note that counters won't be shared between servers.
+type MemoryRateLimitStore struct {
+	// Shards is the number of shards.
+	// Default is DefaultRateLimitShards.
+	Shards int
+
+	shards []*rateLimitShard
+	once   sync.Once
+}
+
+// Take implements the RateLimitStore interface.
+func (s *MemoryRateLimitStore) Take(key string, rate RateLimit) (RateLimitResult, error) {
+	s.once.Do(s.init)
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	shard := s.shards[h.Sum32()%uint32(len(s.shards))]
+	return shard.take(key, rate, util.Now())
+}
+
+func (s *MemoryRateLimitStore) init() {
+	n := s.Shards
+	if n < 1 {
+		n = DefaultRateLimitShards
+	}
+	s.shards = make([]*rateLimitShard, n)
+	for i := range s.shards {
+		s.shards[i] = &rateLimitShard{m: make(map[string]*rateLimitEntry)}
+	}
+}
+
+// rateLimitSweepInterval is the interval to delete the expired entries from a
+// shard.
+const rateLimitSweepInterval = time.Minute
+
+type rateLimitShard struct {
+	m         map[string]*rateLimitEntry
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+type rateLimitEntry struct {
+	// for TokenBucket.
+	tokens float64
+	last   time.Time
+
+	// for SlidingWindow.
+	window    time.Time
+	curr      int
+	prev      int
+	expiresAt time.Time
+}
+
+func (s *rateLimitShard) take(key string, rate RateLimit, now time.Time) (RateLimitResult, error) {
+	if err := rate.validate(); err != nil {
+		return RateLimitResult{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) >= rateLimitSweepInterval {
+		s.sweep(now)
+	}
+	e := s.m[key]
+	if e == nil {
+		e = &rateLimitEntry{tokens: float64(rate.Limit), last: now, window: now.Truncate(rate.Period)}
+		s.m[key] = e
+	}
+	var result RateLimitResult
+	switch rate.Algorithm {
+	case TokenBucket:
+		result = e.takeTokenBucket(rate, now)
+	case SlidingWindow:
+		result = e.takeSlidingWindow(rate, now)
+	}
+	e.expiresAt = now.Add(result.Reset)
+	return result, nil
+}
+
+func (s *rateLimitShard) sweep(now time.Time) {
+	for k, e := range s.m {
+		if !e.expiresAt.After(now) {
+			delete(s.m, k)
+		}
+	}
+	s.lastSweep = now
+}
+
+func (e *rateLimitEntry) takeTokenBucket(rate RateLimit, now time.Time) RateLimitResult {
+	perToken := float64(rate.Period) / float64(rate.Limit)
+	if elapsed := now.Sub(e.last); elapsed > 0 {
+		e.tokens = math.Min(float64(rate.Limit), e.tokens+float64(elapsed)/perToken)
+	}
+	e.last = now
+	result := RateLimitResult{Limit: rate.Limit}
+	if e.tokens >= 1 {
+		e.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - e.tokens) * perToken)
+	}
+	result.Remaining = int(e.tokens)
+	result.Reset = time.Duration((float64(rate.Limit) - e.tokens) * perToken)
+	return result
+}
+
+func (e *rateLimitEntry) takeSlidingWindow(rate RateLimit, now time.Time) RateLimitResult {
+	window := now.Truncate(rate.Period)
+	if d := window.Sub(e.window); d >= 2*rate.Period {
+		e.prev, e.curr = 0, 0
+	} else if d >= rate.Period {
+		e.prev, e.curr = e.curr, 0
+	}
+	e.window = window
+	weight := 1 - float64(now.Sub(window))/float64(rate.Period)
+	count := float64(e.prev)*weight + float64(e.curr)
+	result := RateLimitResult{Limit: rate.Limit}
+	if count < float64(rate.Limit) {
+		e.curr++
+		count++
+		result.Allowed = true
+	}
+	result.Reset = window.Add(rate.Period).Sub(now)
+	if e.curr > 0 {
+		result.Reset += rate.Period
+	}
+	if !result.Allowed {
+		result.RetryAfter = window.Add(rate.Period).Sub(now)
+	}
+	if remaining := rate.Limit - int(math.Ceil(count)); remaining > 0 {
+		result.Remaining = remaining
+	}
+	return result
+}
+
+// RemoteAddrRateLimitKey returns a key for the rate limiting from
+// Request.RemoteAddr.
+func RemoteAddrRateLimitKey(c *Context) string {
+	return c.Request.RemoteAddr
+}
+
+// SessionRateLimitKey returns a function that returns a key for the rate
+// limiting from the session value associated with key.
+// If the session value is empty, the function returns Request.RemoteAddr
+// instead.
+func SessionRateLimitKey(key string) func(c *Context) string {
+	return func(c *Context) string {
+		if v := c.Session.Get(key); v != "" {
+			return "session:" + v
+		}
+		return RemoteAddrRateLimitKey(c)
+	}
+}
@@ -0,0 +1,86 @@
+package kocha_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/naoina/kocha"
+	"github.com/naoina/kocha/util"
+)
+
+func TestMemoryRateLimitStore_Take_withTokenBucket(t *testing.T) {
+	now := time.Date(2015, 12, 1, 0, 0, 0, 0, time.UTC)
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	store := &kocha.MemoryRateLimitStore{}
+	rate := kocha.RateLimit{Limit: 2, Period: 10 * time.Second, Algorithm: kocha.TokenBucket}
+	for _, v := range []struct {
+		elapsed time.Duration
+		expect  kocha.RateLimitResult
+	}{
+		{0, kocha.RateLimitResult{Allowed: true, Limit: 2, Remaining: 1, Reset: 5 * time.Second}},
+		{0, kocha.RateLimitResult{Allowed: true, Limit: 2, Remaining: 0, Reset: 10 * time.Second}},
+		{0, kocha.RateLimitResult{Allowed: false, Limit: 2, Remaining: 0, Reset: 10 * time.Second, RetryAfter: 5 * time.Second}},
+		{2 * time.Second, kocha.RateLimitResult{Allowed: false, Limit: 2, Remaining: 0, Reset: 8 * time.Second, RetryAfter: 3 * time.Second}},
+		{3 * time.Second, kocha.RateLimitResult{Allowed: true, Limit: 2, Remaining: 0, Reset: 10 * time.Second}},
+	} {
+		now = now.Add(v.elapsed)
+		actual, err := store.Take("test", rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`MemoryRateLimitStore.Take(%#v, %#v) after %v => %#v; want %#v`, "test", rate, v.elapsed, actual, expect)
+		}
+	}
+	actual, err := store.Take("another", rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !actual.Allowed {
+		t.Errorf(`MemoryRateLimitStore.Take(%#v, %#v).Allowed => %#v; want %#v`, "another", rate, actual.Allowed, true)
+	}
+}
+
+func TestMemoryRateLimitStore_Take_withSlidingWindow(t *testing.T) {
+	now := time.Date(2015, 12, 1, 0, 0, 0, 0, time.UTC)
+	util.Now = func() time.Time { return now }
+	defer func() { util.Now = time.Now }()
+	store := &kocha.MemoryRateLimitStore{Shards: 1}
+	rate := kocha.RateLimit{Limit: 2, Period: 10 * time.Second, Algorithm: kocha.SlidingWindow}
+	for _, v := range []struct {
+		elapsed time.Duration
+		expect  kocha.RateLimitResult
+	}{
+		{0, kocha.RateLimitResult{Allowed: true, Limit: 2, Remaining: 1, Reset: 20 * time.Second}},
+		{5 * time.Second, kocha.RateLimitResult{Allowed: true, Limit: 2, Remaining: 0, Reset: 15 * time.Second}},
+		{0, kocha.RateLimitResult{Allowed: false, Limit: 2, Remaining: 0, Reset: 15 * time.Second, RetryAfter: 5 * time.Second}},
+		{10 * time.Second, kocha.RateLimitResult{Allowed: true, Limit: 2, Remaining: 0, Reset: 15 * time.Second}},
+		{25 * time.Second, kocha.RateLimitResult{Allowed: true, Limit: 2, Remaining: 1, Reset: 20 * time.Second}},
+	} {
+		now = now.Add(v.elapsed)
+		actual, err := store.Take("test", rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`MemoryRateLimitStore.Take(%#v, %#v) after %v => %#v; want %#v`, "test", rate, v.elapsed, actual, expect)
+		}
+	}
+}
+
+func TestMemoryRateLimitStore_Take_withInvalidRate(t *testing.T) {
+	store := &kocha.MemoryRateLimitStore{}
+	for _, rate := range []kocha.RateLimit{
+		{Limit: 0, Period: time.Second},
+		{Limit: 1, Period: 0},
+		{Limit: 1, Period: time.Second, Algorithm: 100},
+	} {
+		if _, err := store.Take("test", rate); err == nil {
+			t.Errorf(`MemoryRateLimitStore.Take(%#v, %#v) => %#v; want error`, "test", rate, err)
+		}
+	}
+}
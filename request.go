@@ -1,6 +1,7 @@
 package kocha
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	"strings"
@@ -18,28 +19,32 @@ type Request struct {
 	*http.Request
 
 	// RemoteAddr is similar to http.Request.RemoteAddr, but IP only.
+	// If the request came through the trusted proxies, RemoteAddr is the
+	// address of the client that is taken from the forwarded headers.
+	// See also Config.TrustedProxies.
 	RemoteAddr string
+
+	scheme string
 }
 
 // newRequest returns a new Request that given a *http.Request.
-func newRequest(req *http.Request) *Request {
+// The forwarded headers will be used only if the request came through the
+// trusted proxies.
+func newRequest(req *http.Request, trusted trustedProxies) *Request {
 	r := requestPool.Get().(*Request)
 	r.Request = req
-	r.RemoteAddr = remoteAddr(req)
+	r.RemoteAddr, r.scheme = resolveClient(req, trusted)
 	return r
 }
 
 // Scheme returns current scheme of HTTP connection.
+// The forwarded headers such as X-Forwarded-Proto are taken into account only
+// if the request came through the trusted proxies.
 func (r *Request) Scheme() string {
-	switch {
-	case r.Header.Get("Https") == "on", r.Header.Get("X-Forwarded-Ssl") == "on":
-		return "https"
-	case r.Header.Get("X-Forwarded-Scheme") != "":
-		return r.Header.Get("X-Forwarded-Scheme")
-	case r.Header.Get("X-Forwarded-Proto") != "":
-		return strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]
+	if r.scheme != "" {
+		return r.scheme
 	}
-	return "http"
+	return connScheme(r.Request)
 }
 
 // IsSSL returns whether the current connection is secure.
@@ -56,13 +61,198 @@ func (r *Request) reuse() {
 	requestPool.Put(r)
 }
 
-func remoteAddr(r *http.Request) string {
-	if addr := r.Header.Get("X-Forwarded-For"); addr != "" {
-		return strings.TrimSpace(addr[strings.LastIndex(addr, ",")+1:])
+// trustedProxies represents the networks of the trusted proxies.
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses the CIDRs or IP addresses.
+func parseTrustedProxies(addrs []string) (trustedProxies, error) {
+	var nets trustedProxies
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("kocha: invalid trusted proxy address: %v", addr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("kocha: invalid trusted proxy address: %v", err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// contains returns whether the addr is one of the trusted proxies.
+func (t trustedProxies) contains(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range t {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHop represents a hop in the forwarded chain.
+type forwardedHop struct {
+	addr  string
+	proto string
+}
+
+// resolveClient returns the address and the scheme of the client.
+// It walks the forwarded chain from right to left while the hops are trusted,
+// and returns the address and the scheme of the first untrusted hop.
+func resolveClient(req *http.Request, trusted trustedProxies) (addr, scheme string) {
+	addr, scheme = peerAddr(req), connScheme(req)
+	if !trusted.contains(addr) {
+		return addr, scheme
+	}
+	hops := parseForwarded(req.Header["Forwarded"])
+	if hops == nil {
+		hops = parseXForwarded(req.Header)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i].addr != "" {
+			addr = hops[i].addr
+		}
+		if hops[i].proto != "" {
+			scheme = hops[i].proto
+		}
+		if !trusted.contains(hops[i].addr) {
+			break
+		}
+	}
+	return addr, scheme
+}
+
+// parseForwarded parses Forwarded headers.
+// See https://tools.ietf.org/html/rfc7239 for more details.
+func parseForwarded(values []string) []forwardedHop {
+	var hops []forwardedHop
+	for _, v := range values {
+		for _, elem := range splitQuoted(v, ',') {
+			var hop forwardedHop
+			for _, pair := range splitQuoted(elem, ';') {
+				i := strings.IndexByte(pair, '=')
+				if i < 0 {
+					continue
+				}
+				value := strings.Trim(strings.TrimSpace(pair[i+1:]), `"`)
+				switch strings.ToLower(strings.TrimSpace(pair[:i])) {
+				case "for":
+					hop.addr = stripPort(value)
+				case "proto":
+					hop.proto = strings.ToLower(value)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseXForwarded parses X-Forwarded-* headers.
+// X-Forwarded-Proto is associated with the hop of X-Forwarded-For in the same
+// position if both have the same length. Otherwise, the last value of
+// X-Forwarded-Proto and the other headers are associated with the last hop.
+func parseXForwarded(header http.Header) []forwardedHop {
+	var hops []forwardedHop
+	for _, v := range header["X-Forwarded-For"] {
+		for _, addr := range strings.Split(v, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				hops = append(hops, forwardedHop{addr: stripPort(addr)})
+			}
+		}
+	}
+	var protos []string
+	for _, v := range header["X-Forwarded-Proto"] {
+		for _, proto := range strings.Split(v, ",") {
+			protos = append(protos, strings.ToLower(strings.TrimSpace(proto)))
+		}
+	}
+	if len(protos) > 0 && len(protos) == len(hops) {
+		for i := range hops {
+			hops[i].proto = protos[i]
+		}
+		return hops
+	}
+	var proto string
+	switch {
+	case header.Get("Https") == "on", header.Get("X-Forwarded-Ssl") == "on":
+		proto = "https"
+	case header.Get("X-Forwarded-Scheme") != "":
+		proto = strings.ToLower(header.Get("X-Forwarded-Scheme"))
+	case len(protos) > 0:
+		proto = protos[len(protos)-1]
+	}
+	if proto == "" {
+		return hops
 	}
+	if len(hops) == 0 {
+		return []forwardedHop{{proto: proto}}
+	}
+	hops[len(hops)-1].proto = proto
+	return hops
+}
+
+// splitQuoted splits s by sep that isn't in the quoted-string.
+func splitQuoted(s string, sep byte) []string {
+	var result []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			if quoted {
+				i++
+			}
+		case sep:
+			if !quoted {
+				result = append(result, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(result, s[start:])
+}
+
+// stripPort returns the node without the port and the brackets of IPv6.
+func stripPort(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if i := strings.IndexByte(node, ']'); i > 0 {
+			return node[1:i]
+		}
+		return node
+	}
+	if strings.Count(node, ":") == 1 {
+		return node[:strings.IndexByte(node, ':')]
+	}
+	return node
+}
+
+func peerAddr(r *http.Request) string {
 	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
 		return r.RemoteAddr
 	}
 	return host
 }
+
+func connScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -1,50 +1,74 @@
 package kocha
 
 import (
+	"crypto/tls"
 	"net/http"
 	"reflect"
 	"testing"
 )
 
 func TestRequest_RemoteAddr(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
 	for _, v := range []struct {
-		header string
-		value  string
-		expect string
+		remoteAddr string
+		header     http.Header
+		expect     string
 	}{
-		{"X-Forwarded-For", "192.168.0.1", "192.168.0.1"},
-		{"X-Forwarded-For", "192.168.0.1, 192.168.0.2, 192.168.0.3", "192.168.0.3"},
-		{"X-Forwarded-For", "", "127.0.0.1"},
+		{"127.0.0.1:12345", http.Header{"X-Forwarded-For": {"192.168.0.1"}}, "192.168.0.1"},
+		{"127.0.0.1:12345", http.Header{"X-Forwarded-For": {"192.168.0.1, 192.168.0.2, 192.168.0.3"}}, "192.168.0.3"},
+		{"127.0.0.1:12345", http.Header{"X-Forwarded-For": {"192.168.0.1, 192.168.0.2, 10.0.0.2"}}, "192.168.0.2"},
+		{"127.0.0.1:12345", http.Header{"X-Forwarded-For": {"192.168.0.1, 10.0.0.3", "10.0.0.2"}}, "192.168.0.1"},
+		{"127.0.0.1:12345", http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"127.0.0.1:12345", http.Header{"X-Forwarded-For": {""}}, "127.0.0.1"},
+		{"127.0.0.1:12345", http.Header{"Forwarded": {`for=192.0.2.60;proto=http;by=203.0.113.43`}}, "192.0.2.60"},
+		{"127.0.0.1:12345", http.Header{"Forwarded": {`for="[2001:db8:cafe::17]:4711", for=10.0.0.2`}}, "2001:db8:cafe::17"},
+		{"127.0.0.1:12345", http.Header{"Forwarded": {`for=192.0.2.43, for="10.0.0.5:8080"`}, "X-Forwarded-For": {"192.168.0.1"}}, "192.0.2.43"},
+		{"[::1]:12345", http.Header{"X-Forwarded-For": {"192.168.0.1"}}, "192.168.0.1"},
+		{"192.168.0.10:12345", http.Header{"X-Forwarded-For": {"192.168.0.1"}}, "192.168.0.10"},
+		{"192.168.0.10:12345", http.Header{"Forwarded": {"for=192.168.0.1"}}, "192.168.0.10"},
 	} {
-		r := &http.Request{Header: make(http.Header), RemoteAddr: "127.0.0.1:12345"}
-		r.Header.Set(v.header, v.value)
-		req := newRequest(r)
+		r := &http.Request{Header: v.header, RemoteAddr: v.remoteAddr}
+		req := newRequest(r, trusted)
 		actual := req.RemoteAddr
 		expect := v.expect
 		if !reflect.DeepEqual(actual, expect) {
-			t.Errorf(`Request.RemoteAddr with "%v: %v" => %#v; want %#v`, v.header, v.value, actual, expect)
+			t.Errorf(`Request.RemoteAddr from %v with %v => %#v; want %#v`, v.remoteAddr, v.header, actual, expect)
 		}
 	}
 }
 
 func TestRequest_Scheme(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
 	for _, v := range []struct {
-		header string
-		value  string
-		expect string
+		remoteAddr string
+		header     http.Header
+		expect     string
 	}{
-		{"HTTPS", "on", "https"},
-		{"X-Forwarded-SSL", "on", "https"},
-		{"X-Forwarded-Scheme", "file", "file"},
-		{"X-Forwarded-Proto", "gopher", "gopher"},
-		{"X-Forwarded-Proto", "https, http, file", "https"},
+		{"127.0.0.1:12345", http.Header{}, "http"},
+		{"127.0.0.1:12345", http.Header{"Https": {"on"}}, "https"},
+		{"127.0.0.1:12345", http.Header{"X-Forwarded-Ssl": {"on"}}, "https"},
+		{"127.0.0.1:12345", http.Header{"X-Forwarded-Scheme": {"file"}}, "file"},
+		{"127.0.0.1:12345", http.Header{"X-Forwarded-Proto": {"gopher"}}, "gopher"},
+		{"127.0.0.1:12345", http.Header{"X-Forwarded-Proto": {"https, http, file"}}, "file"},
+		{"127.0.0.1:12345", http.Header{"X-Forwarded-For": {"192.168.0.1, 127.0.0.1"}, "X-Forwarded-Proto": {"https, http"}}, "https"},
+		{"127.0.0.1:12345", http.Header{"Forwarded": {"for=192.0.2.60;proto=https, for=127.0.0.1;proto=http"}}, "https"},
+		{"127.0.0.1:12345", http.Header{"Forwarded": {"for=192.0.2.60;proto=http, for=192.0.2.61;proto=https"}}, "https"},
+		{"192.168.0.10:12345", http.Header{"Https": {"on"}}, "http"},
+		{"192.168.0.10:12345", http.Header{"X-Forwarded-Proto": {"https"}}, "http"},
+		{"192.168.0.10:12345", http.Header{"Forwarded": {"proto=https"}}, "http"},
 	} {
-		req := &Request{Request: &http.Request{Header: make(http.Header)}}
-		req.Header.Set(v.header, v.value)
+		r := &http.Request{Header: v.header, RemoteAddr: v.remoteAddr}
+		req := newRequest(r, trusted)
 		actual := req.Scheme()
 		expect := v.expect
 		if !reflect.DeepEqual(actual, expect) {
-			t.Errorf(`Request.Scheme() with "%v: %v" => %#v; want %#v`, v.header, v.value, actual, expect)
+			t.Errorf(`Request.Scheme() from %v with %v => %#v; want %#v`, v.remoteAddr, v.header, actual, expect)
 		}
 	}
 }
@@ -59,12 +83,31 @@ func TestRequest_IsSSL(t *testing.T) {
 
 	req.Header.Set("HTTPS", "on")
 	actual = req.IsSSL()
+	expected = false
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expect %v, but %v", expected, actual)
+	}
+
+	req.TLS = &tls.ConnectionState{}
+	actual = req.IsSSL()
 	expected = true
 	if !reflect.DeepEqual(actual, expected) {
 		t.Errorf("Expect %v, but %v", expected, actual)
 	}
 }
 
+func TestParseTrustedProxies(t *testing.T) {
+	for _, addrs := range [][]string{
+		{"invalid"},
+		{"10.0.0.0/33"},
+		{"127.0.0.1", "::1/129"},
+	} {
+		if _, err := parseTrustedProxies(addrs); err == nil {
+			t.Errorf(`parseTrustedProxies(%#v) => %#v; want error`, addrs, err)
+		}
+	}
+}
+
 func TestRequest_IsXHR(t *testing.T) {
 	r, err := http.NewRequest("GET", "/", nil)
 	if err != nil {
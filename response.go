@@ -48,6 +48,14 @@ func (r *Response) SetCookie(cookie *http.Cookie) {
 	http.SetCookie(r, cookie)
 }
 
+// size returns the size of the response body.
+func (r *Response) size() int {
+	if r.resp == nil {
+		return 0
+	}
+	return r.resp.Body.Len()
+}
+
 func (r *Response) writeTo(w http.ResponseWriter) error {
 	for key, values := range r.Header() {
 		for _, v := range values {
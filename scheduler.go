@@ -0,0 +1,166 @@
+package kocha
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/naoina/kocha/cron"
+	"github.com/naoina/kocha/log"
+	"github.com/naoina/kocha/util"
+)
+
+// Scheduler represents the scheduler of the periodic jobs.
+// The scheduler is started and stopped by Run together with the event
+// workers.
+type Scheduler struct {
+	// Jobs is a list of the jobs.
+	Jobs []*Job
+
+	// Location is the time zone of the cron expressions of the jobs.
+	// If nil, the local time zone will be used.
+	Location *time.Location
+
+	app  *Application
+	jobs []*scheduledJob
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Job represents a periodic job.
+// Either Event or Func must be specified.
+type Job struct {
+	// Name is the name of the job for the log.
+	// If empty, Spec will be used.
+	Name string
+
+	// Spec is the cron expression of the schedule.
+	// See cron.ParseInLocation for the syntax. The time zone can be specified
+	// by the prefix such as "CRON_TZ=Asia/Tokyo".
+	Spec string
+
+	// Event is the name of the event to emit at the scheduled time.
+	// The event must be defined in Event.HandlerMap.
+	Event string
+
+	// Args is the arguments of Event.
+	Args []interface{}
+
+	// Func is the function to run at the scheduled time directly.
+	Func func(app *Application) error
+}
+
+// scheduledJob is the job with the parsed schedule.
+type scheduledJob struct {
+	*Job
+	schedule cron.Schedule
+	running  bool
+	mu       sync.Mutex
+}
+
+func (s *Scheduler) build(app *Application) (*Scheduler, error) {
+	if s == nil {
+		s = &Scheduler{}
+	}
+	s.app = app
+	s.jobs = make([]*scheduledJob, 0, len(s.Jobs))
+	for _, job := range s.Jobs {
+		if (job.Event == "") == (job.Func == nil) {
+			return nil, fmt.Errorf("kocha: scheduler: either Event or Func must be specified in job `%s'", job.name())
+		}
+		schedule, err := cron.ParseInLocation(job.Spec, s.Location)
+		if err != nil {
+			return nil, err
+		}
+		s.jobs = append(s.jobs, &scheduledJob{Job: job, schedule: schedule})
+	}
+	return s, nil
+}
+
+func (s *Scheduler) start() {
+	s.done = make(chan struct{})
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(job)
+	}
+}
+
+// stop stops the scheduler and waits for the running jobs to complete.
+func (s *Scheduler) stop() {
+	if s.done == nil {
+		return
+	}
+	close(s.done)
+	s.wg.Wait()
+	s.done = nil
+}
+
+// loop runs the job at each scheduled time until the scheduler is stopped.
+func (s *Scheduler) loop(job *scheduledJob) {
+	defer s.wg.Done()
+	for {
+		now := util.Now()
+		next := job.schedule.Next(now)
+		if next.IsZero() {
+			s.logger(job).Warn("kocha: scheduler: job won't be run any more")
+			return
+		}
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-timer.C:
+		case <-s.done:
+			timer.Stop()
+			return
+		}
+		s.wg.Add(1)
+		go s.run(job)
+	}
+}
+
+// run runs the job unless the previous run of the job is still running.
+func (s *Scheduler) run(job *scheduledJob) {
+	defer s.wg.Done()
+	logger := s.logger(job)
+	job.mu.Lock()
+	if job.running {
+		job.mu.Unlock()
+		logger.Warn("kocha: scheduler: job has been skipped because the previous run is still running")
+		return
+	}
+	job.running = true
+	job.mu.Unlock()
+	defer func() {
+		job.mu.Lock()
+		job.running = false
+		job.mu.Unlock()
+	}()
+	defer func() {
+		if err := recover(); err != nil {
+			logStackAndError(logger, err)
+		}
+	}()
+	start := time.Now()
+	var err error
+	if job.Func != nil {
+		err = job.Func(s.app)
+	} else {
+		err = s.app.Event.Trigger(job.Event, job.Args...)
+	}
+	logger = logger.With(log.Fields{"duration": time.Since(start).String()})
+	if err != nil {
+		log.WithError(logger, err).Error("kocha: scheduler: job has failed")
+		return
+	}
+	logger.Info("kocha: scheduler: job has finished")
+}
+
+func (s *Scheduler) logger(job *scheduledJob) log.Logger {
+	return log.Named(s.app.Logger, "kocha.scheduler").With(log.Fields{"job": job.name()})
+}
+
+func (job *Job) name() string {
+	if job.Name != "" {
+		return job.Name
+	}
+	return job.Spec
+}
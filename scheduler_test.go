@@ -0,0 +1,101 @@
+package kocha
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/naoina/kocha/log"
+)
+
+type syncBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestScheduler(t *testing.T) {
+	var buf syncBuffer
+	app := &Application{Logger: log.New(&buf, &log.LTSVFormatter{}, log.INFO)}
+	called := make(chan int, 10)
+	release := make(chan struct{})
+	var n int
+	s, err := (&Scheduler{
+		Jobs: []*Job{
+			{
+				Name: "testJob",
+				Spec: "* * * * * *",
+				Func: func(app *Application) error {
+					n++
+					called <- n
+					if n == 1 {
+						<-release
+						return fmt.Errorf("testError")
+					}
+					return nil
+				},
+			},
+		},
+	}).build(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.start()
+	select {
+	case <-called:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("job hasn't been called within 3 seconds")
+	}
+	// wait for the next run to be skipped.
+	time.Sleep(1500 * time.Millisecond)
+	close(release)
+	select {
+	case actual := <-called:
+		expected := 2
+		if actual != expected {
+			t.Errorf("number of calls => %#v; want %#v", actual, expected)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("job hasn't been called within 3 seconds after the previous run")
+	}
+	s.stop()
+
+	actual := buf.String()
+	for _, expected := range []string{
+		"message:kocha: scheduler: job has been skipped because the previous run is still running",
+		"message:kocha: scheduler: job has failed",
+		"message:kocha: scheduler: job has finished",
+		"job:testJob",
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("log => %#v; want contains %#v", actual, expected)
+		}
+	}
+}
+
+func TestScheduler_build(t *testing.T) {
+	f := func(app *Application) error { return nil }
+	for _, job := range []*Job{
+		{Spec: "* * * * *"},
+		{Spec: "* * * * *", Event: "test", Func: f},
+		{Spec: "* * *", Func: f},
+	} {
+		if _, err := (&Scheduler{Jobs: []*Job{job}}).build(&Application{}); err == nil {
+			t.Errorf("Scheduler{Jobs: %#v}.build(app) => _, nil; want error", job)
+		}
+	}
+}
@@ -103,6 +103,11 @@ func (t *Template) buildFuncMap() (*Template, error) {
 		"raw":             t.raw,
 		"invoke_template": t.invokeTemplate,
 		"flash":           t.flash,
+		"flashes":         t.flashes,
+		"csrf_token":      t.csrfToken,
+		"csrf_field":      t.csrfField,
+		"csrf_meta":       t.csrfMeta,
+		"csp_nonce":       t.cspNonce,
 		"join":            t.join,
 	}
 	for name, fn := range t.FuncMap {
@@ -289,6 +294,56 @@ func (t *Template) flash(c *Context, key string) string {
 	return c.Flash.Get(key)
 }
 
+// flashes is for "flashes" template function.
+// This is a shorthand for {{.Flash.All}} in template.
+func (t *Template) flashes(c *Context) []FlashEntry {
+	return c.Flash.All()
+}
+
+// csrfToken is for "csrf_token" template function.
+// This is a shorthand for {{.CSRFToken}} in template.
+func (t *Template) csrfToken(c *Context) string {
+	return c.CSRFToken
+}
+
+// csrfField is for "csrf_field" template function.
+// It returns a hidden input element of the CSRF token.
+func (t *Template) csrfField(c *Context) (template.HTML, error) {
+	m, err := t.csrfMiddleware()
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+		template.HTMLEscapeString(m.FieldName), template.HTMLEscapeString(c.CSRFToken))), nil
+}
+
+// csrfMeta is for "csrf_meta" template function.
+// It returns meta elements of the name of form field and the CSRF token.
+func (t *Template) csrfMeta(c *Context) (template.HTML, error) {
+	m, err := t.csrfMiddleware()
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(fmt.Sprintf(`<meta name="csrf-param" content="%s"><meta name="csrf-token" content="%s">`,
+		template.HTMLEscapeString(m.FieldName), template.HTMLEscapeString(c.CSRFToken))), nil
+}
+
+func (t *Template) csrfMiddleware() (*CSRFMiddleware, error) {
+	for _, m := range t.app.Config.Middlewares {
+		if m, ok := m.(*CSRFMiddleware); ok {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("kocha: csrf: CSRFMiddleware isn't added to middlewares")
+}
+
+// cspNonce is for "csp_nonce" template function.
+// This is a shorthand for {{.CSPNonce}} in template.
+// e.g. <script nonce="{{csp_nonce .}}">...</script>
+func (t *Template) cspNonce(c *Context) string {
+	return c.CSPNonce
+}
+
 // join is for "join" template function.
 func (t *Template) join(a interface{}, sep string) (string, error) {
 	v := reflect.ValueOf(a)
@@ -284,6 +284,77 @@ func TestTemplateFuncMap_flash(t *testing.T) {
 	}
 }
 
+func TestTemplateFuncMap_flashes(t *testing.T) {
+	c := newTestContext("testctrlr", "")
+	funcMap := template.FuncMap(c.App.Template.FuncMap)
+	c.Flash = kocha.Flash{}
+	c.Flash.Add(kocha.FlashSuccess, "saved")
+	c.Flash.Add(kocha.FlashError, "failed1")
+	c.Flash.Now(kocha.FlashError, "failed2")
+	tmpl := template.Must(template.New("test").Funcs(funcMap).Parse(`{{range flashes .}}[{{.Key}}:{{.Text}}]{{end}}`))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	actual := buf.String()
+	expect := "[error:failed1][error:failed2][success:saved]"
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`{{range flashes .}}...{{end}} => %#v; want %#v`, actual, expect)
+	}
+}
+
+func TestTemplateFuncMap_csrf(t *testing.T) {
+	c := newTestContext("testctrlr", "")
+	funcMap := template.FuncMap(c.App.Template.FuncMap)
+	c.CSRFToken = "test<token>"
+	for _, v := range []struct {
+		tmpl   string
+		expect string
+	}{
+		{`{{csrf_token .}}`, "test&lt;token&gt;"},
+		{`{{csrf_field .}}`, `<input type="hidden" name="_csrf_token" value="test&lt;token&gt;">`},
+		{`{{csrf_meta .}}`, `<meta name="csrf-param" content="_csrf_token"><meta name="csrf-token" content="test&lt;token&gt;">`},
+	} {
+		c.App.Config.Middlewares = nil
+		tmpl := template.Must(template.New("test").Funcs(funcMap).Parse(v.tmpl))
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, c); v.tmpl != `{{csrf_token .}}` && err == nil {
+			t.Errorf(`%v without CSRFMiddleware => %#v; want error`, v.tmpl, err)
+		}
+		m := &kocha.CSRFMiddleware{}
+		if err := m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		c.App.Config.Middlewares = []kocha.Middleware{m}
+		buf.Reset()
+		if err := tmpl.Execute(&buf, c); err != nil {
+			t.Error(err)
+			continue
+		}
+		actual := buf.String()
+		expect := v.expect
+		if !reflect.DeepEqual(actual, expect) {
+			t.Errorf(`%v => %#v; want %#v`, v.tmpl, actual, expect)
+		}
+	}
+}
+
+func TestTemplateFuncMap_cspNonce(t *testing.T) {
+	c := newTestContext("testctrlr", "")
+	funcMap := template.FuncMap(c.App.Template.FuncMap)
+	c.CSPNonce = "dGVzdG5vbmNl"
+	tmpl := template.Must(template.New("test").Funcs(funcMap).Parse(`<script nonce="{{csp_nonce .}}"></script>`))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	actual := buf.String()
+	expect := `<script nonce="dGVzdG5vbmNl"></script>`
+	if !reflect.DeepEqual(actual, expect) {
+		t.Errorf(`<script nonce="{{csp_nonce .}}"></script> => %#v; want %#v`, actual, expect)
+	}
+}
+
 func TestTemplateFuncMap_join(t *testing.T) {
 	app := kocha.NewTestApp()
 	funcMap := template.FuncMap(app.Template.FuncMap)